@@ -0,0 +1,280 @@
+package core
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	ht "html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+)
+
+//go:embed admin/*.html
+var adminFS embed.FS
+
+// AdminRole is the role allowed into the admin dashboard
+var AdminRole = "admin"
+
+// AuditAdminPrefix starts the audit action of every change made through the
+// dashboard, which records the admin alongside the model's own event
+const AuditAdminPrefix = "admin."
+
+var (
+	// AdminLogLines is how many of the latest log lines the logs page shows
+	AdminLogLines = 200
+	// AdminTransactionLimit is how many of the latest MMG transactions the payments page shows
+	AdminTransactionLimit = 100
+	// AdminMagicLinkLimit is how many of the latest magic links the magic links page shows
+	AdminMagicLinkLimit = 100
+)
+
+// most of a log file the logs page reads from its end
+const adminLogTail = 512 * 1024
+
+// adminPages are the dashboard pages, each rendered inside admin/layout.html
+var adminPages = []string{"users", "shelf", "magic-links", "payments", "sitemap", "logs"}
+
+// MountAdmin adds the admin dashboard under prefix for users with AdminRole, e.g. for the prefix "/admin":
+// /admin/users lists and searches users to manage their roles and lockouts, /admin/shelf edits the shelf,
+// /admin/magic-links lists magic links, /admin/payments shows MMG merchants, products and transactions,
+// /admin/sitemap lists the sitemap entries and /admin/logs shows the latest lines of the app log.
+func (base *Base) MountAdmin(router fiber.Router, prefix string) {
+	pages := make(map[string]*ht.Template, len(adminPages))
+	funcs := ht.FuncMap{
+		"humanTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.UTC().Format("Jan 02, 2006 @ 15:04 hrs")
+		},
+	}
+	for _, page := range adminPages {
+		pages[page] = ht.Must(ht.New("").Funcs(funcs).ParseFS(adminFS, "admin/layout.html", "admin/"+page+".html"))
+	}
+	render := func(c *fiber.Ctx, page, title string, data fiber.Map) error {
+		data["Title"] = title
+		data["Page"] = page
+		data["Prefix"] = prefix
+		data["csrf"] = c.Locals("csrf")
+		data["flash"] = c.Locals("flash")
+		var buf bytes.Buffer
+		if err := pages[page].ExecuteTemplate(&buf, "layout", data); err != nil {
+			return err
+		}
+		c.Type("html")
+		return c.Send(buf.Bytes())
+	}
+
+	admin := router.Group(prefix, models.RequireRoleMiddleware(base.Store, base.Flash, AdminRole))
+
+	admin.Get("/", func(c *fiber.Ctx) error {
+		return c.Redirect(prefix + "/users")
+	})
+
+	admin.Get("/users", func(c *fiber.Ctx) error {
+		query := models.ParseUserQuery(c)
+		page, err := base.Users.List(c.Context(), query)
+		if errors.Is(err, models.ErrInvalidCursor) || errors.Is(err, models.ErrInvalidSort) {
+			return base.Flash.Redirect(c, prefix+"/users", "That page of users no longer exists")
+		}
+		if err != nil {
+			return err
+		}
+		locked := make(map[string]string)
+		for _, user := range page.Users {
+			if !user.LockedUntil.IsZero() {
+				locked[user.Email] = user.LockedUntil.UTC().Format("Jan 02, 2006 @ 15:04 hrs")
+			}
+		}
+		roles, err := base.Roles.List()
+		if err != nil {
+			return err
+		}
+		return render(c, "users", "Users", fiber.Map{
+			"Query":             query,
+			"Users":             page.Users,
+			"Pagination":        page.Pagination(c),
+			"Locked":            locked,
+			"Roles":             roles,
+			"ImpersonationPath": base.impersonationPath,
+		})
+	})
+
+	admin.Post("/users/roles", func(c *fiber.Ctx) error {
+		userEmail := strings.TrimSpace(c.FormValue("email"))
+		role := strings.TrimSpace(c.FormValue("role"))
+		if userEmail == "" || role == "" {
+			return base.Flash.Redirect(c, prefix+"/users", "Choose a user and a role")
+		}
+		if c.FormValue("action") == "remove" {
+			if err := base.Users.RemoveRole(userEmail, role); err != nil {
+				return err
+			}
+			log.Infof("admin removed role %s from %s", role, userEmail)
+			base.audit(c, AuditAdminPrefix+models.AuditRoleRemove, userEmail, map[string]any{"role": role})
+			return base.Flash.Redirect(c, prefix+"/users", "Removed %s from %s", role, userEmail)
+		}
+		if err := base.Users.AssignRole(userEmail, role); err != nil {
+			return err
+		}
+		log.Infof("admin assigned role %s to %s", role, userEmail)
+		base.audit(c, AuditAdminPrefix+models.AuditRoleAssign, userEmail, map[string]any{"role": role})
+		return base.Flash.Redirect(c, prefix+"/users", "Gave %s the %s role", userEmail, role)
+	})
+
+	admin.Post("/users/lock", func(c *fiber.Ctx) error {
+		userEmail := strings.TrimSpace(c.FormValue("email"))
+		until, err := time.Parse(time.DateOnly, c.FormValue("until"))
+		if err != nil || !until.After(time.Now()) {
+			return base.Flash.Redirect(c, prefix+"/users", "Choose a date in the future to lock %s until", userEmail)
+		}
+		if err := base.Users.Lock(userEmail, until); err != nil {
+			return err
+		}
+		log.Infof("admin locked %s until %s", userEmail, until.Format(time.DateOnly))
+		base.audit(c, AuditAdminPrefix+models.AuditAccountLock, userEmail, map[string]any{"until": until})
+		return base.Flash.Redirect(c, prefix+"/users", "Locked %s until %s", userEmail, until.Format(time.DateOnly))
+	})
+
+	admin.Post("/users/unlock", func(c *fiber.Ctx) error {
+		userEmail := strings.TrimSpace(c.FormValue("email"))
+		if err := base.UnlockLogin(userEmail); err != nil {
+			return err
+		}
+		log.Infof("admin unlocked %s", userEmail)
+		base.audit(c, AuditAdminPrefix+models.AuditAccountUnlock, userEmail, nil)
+		return base.Flash.Redirect(c, prefix+"/users", "Unlocked %s", userEmail)
+	})
+
+	admin.Get("/shelf", func(c *fiber.Ctx) error {
+		filter := strings.TrimSpace(c.Query("q"))
+		return render(c, "shelf", "Shelf", fiber.Map{
+			"Filter": filter,
+			"Shelf":  base.Shelf.GetMany(filter),
+		})
+	})
+
+	admin.Post("/shelf", func(c *fiber.Ctx) error {
+		name := strings.TrimSpace(c.FormValue("name"))
+		if name == "" {
+			return base.Flash.Redirect(c, prefix+"/shelf", "Enter a key")
+		}
+		base.Shelf.Set(name, c.FormValue("value"))
+		base.audit(c, AuditAdminPrefix+"shelf.set", name, nil)
+		return base.Flash.Redirect(c, prefix+"/shelf", "Saved %s", name)
+	})
+
+	admin.Get("/magic-links", func(c *fiber.Ctx) error {
+		links, err := base.Mail.ListMagicLinks(AdminMagicLinkLimit)
+		if err != nil {
+			return err
+		}
+		return render(c, "magic-links", "Magic links", fiber.Map{
+			"Links": links,
+		})
+	})
+
+	admin.Get("/payments", func(c *fiber.Ctx) error {
+		merchants, err := base.MMG.ListMerchants()
+		if err != nil {
+			return err
+		}
+		products, err := base.MMG.ListProducts()
+		if err != nil {
+			return err
+		}
+		transactions, err := base.MMG.ListTransactions(AdminTransactionLimit)
+		if err != nil {
+			return err
+		}
+		return render(c, "payments", "Payments", fiber.Map{
+			"Merchants":    merchants,
+			"Products":     products,
+			"Transactions": transactions,
+		})
+	})
+
+	admin.Post("/payments/merchants", func(c *fiber.Ctx) error {
+		number, err := strconv.Atoi(strings.TrimSpace(c.FormValue("number")))
+		name := strings.TrimSpace(c.FormValue("name"))
+		if err != nil || name == "" {
+			return base.Flash.Redirect(c, prefix+"/payments", "Enter the merchant's name and number")
+		}
+		if err := base.MMG.RegisterMerchant(number, name); err != nil {
+			return base.Flash.Redirect(c, prefix+"/payments", "Could not register the merchant: %v", err)
+		}
+		base.audit(c, AuditAdminPrefix+"mmg.merchant.register", name, map[string]any{"number": number})
+		return base.Flash.Redirect(c, prefix+"/payments", "Registered %s", name)
+	})
+
+	admin.Post("/payments/products", func(c *fiber.Ctx) error {
+		code := strings.TrimSpace(c.FormValue("code"))
+		description := strings.TrimSpace(c.FormValue("description"))
+		if code == "" || description == "" {
+			return base.Flash.Redirect(c, prefix+"/payments", "Enter the product's code and description")
+		}
+		if err := base.MMG.AddProduct(code, description); err != nil {
+			return base.Flash.Redirect(c, prefix+"/payments", "Could not add the product: %v", err)
+		}
+		base.audit(c, AuditAdminPrefix+"mmg.product.add", code, map[string]any{"description": description})
+		return base.Flash.Redirect(c, prefix+"/payments", "Added %s", code)
+	})
+
+	admin.Get("/sitemap", func(c *fiber.Ctx) error {
+		return render(c, "sitemap", "Sitemap", fiber.Map{
+			"Locations": base.SiteMap.Get(),
+		})
+	})
+
+	admin.Get("/logs", func(c *fiber.Ctx) error {
+		filter := c.Query("q")
+		lines, err := tailLines(base.appName+".log", AdminLogLines, filter)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return render(c, "logs", "Logs", fiber.Map{
+			"Filter": filter,
+			"File":   base.appName + ".log",
+			"Lines":  lines,
+		})
+	})
+}
+
+// tailLines returns up to n of the last lines of the file that contain filter,
+// newest first
+func tailLines(path string, n int, filter string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-adminLogTail, 0)
+	b, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+	all := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if offset > 0 {
+		// the first line was cut in half
+		all = all[1:]
+	}
+
+	var lines []string
+	for i := len(all) - 1; i >= 0 && len(lines) < n; i-- {
+		if all[i] != "" && strings.Contains(all[i], filter) {
+			lines = append(lines, all[i])
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// audit actions recorded by boiler's own handlers
+const (
+	AuditLoginSuccess       = "login.success"
+	AuditLoginFailure       = "login.failure"
+	AuditImpersonationStart = "impersonation.start"
+	AuditImpersonationStop  = "impersonation.stop"
+)
+
+// AuditPurgeInterval is how often events older than models.AuditRetention are deleted
+var AuditPurgeInterval = 24 * time.Hour
+
+// audit records an event for the request, logging rather than failing the request
+func (base *Base) audit(c *fiber.Ctx, action, target string, metadata map[string]any) {
+	if err := base.Audit.Record(c, action, target, metadata); err != nil {
+		log.Errorf("audit %s %s error: %v", action, target, err)
+	}
+}
+
+// requestID keeps the X-Request-ID set by a proxy, or a new one, in
+// c.Locals("requestid") for the audit log and echoes it in the response
+func requestID(c *fiber.Ctx) error {
+	id := c.Get(fiber.HeaderXRequestID)
+	if !validRequestID(id) {
+		id = helpers.GetRandomUUID()
+	}
+	c.Locals("requestid", id)
+	c.Set(fiber.HeaderXRequestID, id)
+	return c.Next()
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// purgeAuditEvents deletes expired audit events every AuditPurgeInterval until ctx is done
+func (base *Base) purgeAuditEvents(ctx context.Context) {
+	if models.AuditRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(AuditPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := base.Audit.Purge(ctx, models.AuditRetention)
+		if err != nil {
+			log.Errorf("audit purge error: %v", err)
+		} else if purged > 0 {
+			log.Infof("purged %d audit events older than %v", purged, models.AuditRetention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package core
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	ht "html/template"
 	"io"
@@ -22,8 +24,11 @@ import (
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/template/html/v2"
 	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/dialect"
 	"github.com/joashgobin/boiler/email"
 	"github.com/joashgobin/boiler/helpers"
+	"github.com/joashgobin/boiler/migrate"
+	"github.com/joashgobin/boiler/oidc"
 	"github.com/joashgobin/boiler/payments"
 	"go.rumenx.com/sitemap"
 	fiberadapter "go.rumenx.com/sitemap/adapters/fiber"
@@ -38,28 +43,49 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/pprof"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/session"
-	"github.com/gofiber/storage/valkey"
 )
 
 type Base struct {
 	// public variables
-	Users     models.UserModelInterface
-	DB        *sql.DB
-	Store     *session.Store
-	Shelf     helpers.ShelfModelInterface
-	Flash     helpers.FlashInterface
-	Bank      helpers.BankInterface
-	MMG       payments.MMGInterface
-	Mail      email.MailInterface
-	Anchor    string
-	QR        helpers.QRInterface
-	WaitGroup *sync.WaitGroup
-	SiteMap   helpers.SitemapInterface
+	Users          models.UserModelInterface
+	Passkeys       models.PasskeyModelInterface
+	Roles          models.RoleModelInterface
+	Sessions       models.SessionModelInterface
+	Identities     models.IdentityModelInterface
+	Impersonations models.ImpersonationModelInterface
+	Audit          models.AuditModelInterface
+	DB             *sql.DB
+	Store          *session.Store
+	Shelf          helpers.ShelfModelInterface
+	Flash          helpers.FlashInterface
+	Bank           helpers.BankInterface
+	MMG            payments.MMGInterface
+	Mail           email.MailInterface
+	Lists          email.ListModelInterface
+	Anchor         string
+	QR             helpers.QRInterface
+	WaitGroup      *sync.WaitGroup
+	SiteMap        helpers.SitemapInterface
 
 	// private variables
-	isProd bool
-	domain string
-	port   string
+	isProd     bool
+	domain     string
+	port       string
+	appName    string
+	migrations []*migrate.Runner
+
+	oidcProviders map[string]*oidc.Provider
+	signInLinks   []SignInLink
+
+	impersonationPath string
+	unsubscribePath   string
+
+	// stopWorkers stops the background workers, such as the email outbox
+	stopWorkers context.CancelFunc
+	// mailTransport delivers the emails queued through Mail
+	mailTransport email.Transport
+	// logFile receives the app's log next to stdout, closed by Serve
+	logFile io.Closer
 }
 
 type AppConfig struct {
@@ -72,6 +98,23 @@ type AppConfig struct {
 	SiteInfo     *map[string]string
 	FuncMap      map[string]interface{}
 	IsProduction bool
+
+	// EmailTemplates holds the app's email templates in an "emails" directory,
+	// parsed at startup for Mail.SendTemplate
+	EmailTemplates *embed.FS
+
+	// Migrations holds the app's own migration files in a "migrations" directory,
+	// applied after the built-in ones
+	Migrations *embed.FS
+
+	// Storage is used for sessions, csrf, idempotency and the bank when set,
+	// otherwise StorageBackend selects one of the built-in storages
+	Storage        fiber.Storage
+	StorageBackend string
+
+	// Dialect selects the SQL flavour used to connect, migrate and query,
+	// MySQL when nil; the app imports the matching database/sql driver
+	Dialect dialect.Dialect
 }
 
 func (base *Base) URL() string {
@@ -82,7 +125,7 @@ func (base *Base) URL() string {
 	}
 }
 
-func (base Base) Serve(app *fiber.App) {
+func (base *Base) Serve(app *fiber.App) {
 	app.Get("/sitemap.xml", fiberadapter.Sitemap(func() *sitemap.Sitemap {
 		sm := sitemap.New()
 		for _, location := range base.SiteMap.Get() {
@@ -95,6 +138,8 @@ func (base Base) Serve(app *fiber.App) {
 		return base.QR.Send(c, base.URL())
 	})
 
+	base.mountMailbox(app)
+
 	app.Get("/image", func(c *fiber.Ctx) error {
 		finalPath := c.Query("path")
 		return c.SendString("<img alt='" + finalPath + "' style='opacity:0' onload='this.style.opacity=1' class='gen-image' src='" + finalPath + "' width=100%>")
@@ -118,18 +163,29 @@ func (base Base) Serve(app *fiber.App) {
 
 	// cleanup tasks
 	log.Info("running cleanup tasks...")
+	if base.stopWorkers != nil {
+		base.stopWorkers()
+	}
+	// background tasks still need the database
+	if base.WaitGroup != nil {
+		base.WaitGroup.Wait()
+	}
 	if base.DB != nil {
 		if err := base.DB.Close(); err != nil {
 			log.Errorf("failed to close database connection: %v", err)
 		}
 	}
-	if base.WaitGroup != nil {
-		base.WaitGroup.Wait()
+	if closer, ok := base.mailTransport.(io.Closer); ok {
+		closer.Close()
 	}
 
 	base.Bank.Close()
 
 	log.Info("fiber app was successfully shutdown.")
+	if base.logFile != nil {
+		log.SetOutput(os.Stdout)
+		base.logFile.Close()
+	}
 }
 
 func showElapsed(description string, start time.Time) {
@@ -139,23 +195,29 @@ func showElapsed(description string, start time.Time) {
 	}
 }
 
-// NewApp returns a configured fiber app with session, csrf and other middleware
-func NewApp(config AppConfig) (*fiber.App, Base) {
-	if config.User == "" {
-		fmt.Println("config error: user not specified e.g. john")
+// NewApp returns a configured fiber app with session, csrf and other middleware.
+// It exits if the app cannot be configured; use New to handle the error instead.
+func NewApp(config AppConfig) (*fiber.App, *Base) {
+	app, base, err := New(context.Background(), WithConfig(config))
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	if config.IP == "" {
-		fmt.Println("config error: IP not specified e.g. example.com")
-		os.Exit(1)
+	return app, base
+}
+
+// New returns a configured fiber app with session, csrf and other middleware
+func New(ctx context.Context, opts ...Option) (*fiber.App, *Base, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
-	if config.Port == "" {
-		fmt.Println("config error: port not specified e.g. 9910")
-		os.Exit(1)
+	config := o.config
+	if o.templates != nil {
+		config.Templates = o.templates
 	}
-	if config.AppName == "" {
-		fmt.Println("config error: app name not specified e.g. myapp")
-		os.Exit(1)
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
 	}
 
 	start := time.Now()
@@ -165,43 +227,43 @@ func NewApp(config AppConfig) (*fiber.App, Base) {
 	fingerprints := make(map[string]string, 50)
 	optimizations := make(map[string]string, 50)
 
-	// generate new minified style file with fingerprint in file name
-	helpers.GenerateFingerprintsForFolder("static", "static/gen", ".css", &fingerprints)
+	if o.assetPipeline {
+		// generate new minified style file with fingerprint in file name
+		helpers.GenerateFingerprintsForFolder("static", "static/gen", ".css", &fingerprints)
 
-	// optimize css files for used class names
-	err := helpers.SaveCSSClasses(config.Templates, "static/gen/mango-opt.css",
-		"static/styles/mango-tokens.css", "static/styles/mango-utils.css", "static/styles/mango-blocks.css")
-	if err != nil {
-		log.Errorf("failed to crunch CSS: %v", err)
-	}
+		// optimize css files for used class names
+		err := helpers.SaveCSSClasses(config.Templates, "static/gen/mango-opt.css",
+			"static/styles/mango-tokens.css", "static/styles/mango-utils.css", "static/styles/mango-blocks.css")
+		if err != nil {
+			log.Errorf("failed to crunch CSS: %v", err)
+		}
 
-	// combine stylesheet files into a single file and fingerprint
-	helpers.CombineAndFingerprint("static/gen/mango-final.css", &fingerprints,
-		"static/styles/mango.css", "static/styles/mango-tokens.css", "static/styles/mango-utils.css", "static/styles/mango-blocks.css")
+		// combine stylesheet files into a single file and fingerprint
+		helpers.CombineAndFingerprint("static/gen/mango-final.css", &fingerprints,
+			"static/styles/mango.css", "static/styles/mango-tokens.css", "static/styles/mango-utils.css", "static/styles/mango-blocks.css")
 
-	helpers.CombineAndFingerprint("static/gen/mango-simplified.css", &fingerprints,
-		"static/styles/mango.css", "static/gen/mango-opt.css")
+		helpers.CombineAndFingerprint("static/gen/mango-simplified.css", &fingerprints,
+			"static/styles/mango.css", "static/gen/mango-opt.css")
 
-	// log.Info("fingerprints:", fingerprints)
+		// log.Info("fingerprints:", fingerprints)
 
-	// convert all images to webp
-	helpers.ConvertInFolderToWebp("static/img", "static/gen/img", ".jpeg", &optimizations)
-	helpers.ConvertInFolderToWebp("static/img", "static/gen/img", ".jpg", &optimizations)
-	helpers.ConvertInFolderToWebp("static/img", "static/gen/img", ".png", &optimizations)
-	// fmt.Println(optimizations)
+		// convert all images to webp
+		helpers.ConvertInFolderToWebp("static/img", "static/gen/img", ".jpeg", &optimizations)
+		helpers.ConvertInFolderToWebp("static/img", "static/gen/img", ".jpg", &optimizations)
+		helpers.ConvertInFolderToWebp("static/img", "static/gen/img", ".png", &optimizations)
+		// fmt.Println(optimizations)
 
-	showElapsed("app resource optimization time", start)
+		showElapsed("app resource optimization time", start)
+	}
 
 	// get core directory
 	_, filename, _, ok := runtime.Caller(0)
 	if !ok {
-		log.Error("failed to get caller information")
-		return nil, Base{}
+		return nil, nil, errors.New("failed to get caller information")
 	}
 	coreDir, err := filepath.Abs(filename)
 	if err != nil {
-		log.Fatalf("failed to get absolute path: %v", err)
-		return nil, Base{}
+		return nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
 	if !fiber.IsChild() {
@@ -245,6 +307,11 @@ func NewApp(config AppConfig) (*fiber.App, Base) {
 			helpers.FileSubstitute(filepath.Dir(coreDir)+"/air/config.env", "config.env", map[string]string{})
 		}
 
+		// create the DKIM key and its DNS record when signing is configured
+		if err := generateDKIMKey(); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate DKIM key: %w", err)
+		}
+
 		if !helpers.FileExists("static/main.css") {
 			helpers.TouchFile("static/main.css")
 		}
@@ -310,7 +377,7 @@ exec bash
 
 	showElapsed("app resource copy time", start)
 
-	if !fiber.IsChild() {
+	if o.assetPipeline && !fiber.IsChild() {
 		// generate favicon
 		helpers.ConvertJPGToPNG("static/img/favicon.jpg", "static/img/favicon.png")
 		helpers.GenerateFavicon("static/img/favicon.png", "static/gen/img/")
@@ -379,10 +446,17 @@ exec bash
 			return ht.HTML(start)
 		},
 		"role": func(roles interface{}, role string) bool {
-			if roles == nil {
-				return false
+			switch roles := roles.(type) {
+			case models.RoleSet:
+				return roles.Has(role)
+			case string:
+				return strings.Contains(roles, "|"+role+"|")
 			}
-			return strings.Contains(roles.(string), "|"+role+"|")
+			return false
+		},
+		"can": func(user interface{}, permission string) bool {
+			u, ok := user.(models.User)
+			return ok && u.Can(permission)
 		},
 		"default": func(def string, value interface{}) interface{} {
 			if value == nil {
@@ -592,32 +666,68 @@ exec bash
 	}
 
 	if err := engine.Load(); err != nil {
-		log.Errorf("failed to load templates: %v", err)
-		return nil, Base{}
+		return nil, nil, fmt.Errorf("failed to load templates: %w", err)
 	}
 
 	showElapsed("template engine load time", start)
 
-	// declare database URIs
-	var dbURI string = os.Getenv("FIBER_USER_URI")
-	// var storageURI string = dbURI + appName + "?multiStatements=true"
+	// open database corresponding to app name
+	db := o.db
+	if db == nil {
+		db, err = openDB(ctx, config.Dialect, config.AppName)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// close what New opened itself when it fails further down, so retrying doesn't leak
+	var logFile *os.File
+	fail := func(err error) (*fiber.App, *Base, error) {
+		if o.db == nil {
+			db.Close()
+		}
+		if logFile != nil {
+			log.SetOutput(os.Stdout)
+			logFile.Close()
+		}
+		return nil, nil, err
+	}
+
+	// apply pending boiler and app migrations
+	runners, err := migrationRunners(db, config)
+	if err != nil {
+		return fail(err)
+	}
+	if o.autoMigrate {
+		if err := applyMigrations(ctx, runners); err != nil {
+			return fail(err)
+		}
+	}
 
 	// initialize fiber storage middleware
-	storage := valkey.New(valkey.Config{
-		InitAddress: []string{"localhost:6379"},
-		Username:    "",
-		Password:    "",
-		SelectDB:    0,
-		Reset:       false,
-		TLSConfig:   nil,
-	})
+	storage := o.storage
+	if storage == nil {
+		storage = config.Storage
+	}
+	if storage == nil {
+		storage, err = newStorage(config.StorageBackend, db, config.Dialect)
+		if err != nil {
+			return fail(err)
+		}
+	}
 
-	// create new fiber app with prefork enabled
+	// memory storage is not shared between preforked processes
+	if _, ok := storage.(*helpers.MemoryStorage); ok && o.prefork {
+		log.Warn("prefork disabled since memory storage is not shared between processes")
+		o.prefork = false
+	}
+
+	// create new fiber app with prefork enabled by default
 	app := fiber.New(fiber.Config{
 		Views:             engine,
 		ViewsLayout:       "views/layouts/main",
 		PassLocalsToViews: true,
-		Prefork:           true, //config.IsProduction,
+		Prefork:           o.prefork, //config.IsProduction,
 	})
 
 	// initialize fiber session middleware
@@ -633,10 +743,18 @@ exec bash
 	}
 	store := session.New(sessConfig)
 
+	// start over when a session was saved by an older version of the app
+	app.Use(helpers.SessionResetMiddleware(store, config.AppName+"_fiber_session"))
+
 	// create csrf handler
 	csrfErrorHandler := func(c *fiber.Ctx, err error) error {
 		// log.Infof("CSRF Error: %v Request: %v From: %v\n", err, c.OriginalURL(), c.IP())
 
+		// API clients whose token didn't authenticate are told so rather than about CSRF
+		if auth, ok := c.Locals("tokenauth").(tokenAuth); ok && auth.err != nil {
+			return tokenError(c, auth.err)
+		}
+
 		// check accepted content types
 		switch c.Accepts("html", "json") {
 		case "json":
@@ -658,8 +776,15 @@ exec bash
 		}
 	}
 
+	// base is created further down, the csrf middleware only uses it per request
+	var base *Base
+
 	// initialize fiber csrf middleware
 	csrfMiddleware := csrf.New(csrf.Config{
+		// requests with a valid API token skip the check, see tokenAuthenticated
+		Next: func(c *fiber.Ctx) bool {
+			return base.tokenAuthenticated(c) || base.skipsCSRF(c)
+		},
 		Session:   store,
 		KeyLookup: "form:csrf",
 		// CookieName:     "__Host-csrf", // Recommended to use the __Host- prefix when serving the app over TLS
@@ -681,8 +806,11 @@ exec bash
 	app.Use(logger.New(logger.Config{
 		Format: "[${ip}]:${port} ${status} - ${method} ${path}\n",
 	}))
-	f, err := os.OpenFile(config.AppName+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	iw := io.MultiWriter(os.Stdout, f)
+	logFile, err = os.OpenFile(config.AppName+".log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fail(fmt.Errorf("failed to open log file: %w", err))
+	}
+	iw := io.MultiWriter(os.Stdout, logFile)
 	log.SetOutput(iw)
 
 	// serve static files when in development
@@ -710,13 +838,6 @@ exec bash
 		}
 	}
 
-	// open database corresponding to app name
-	db, err := helpers.OpenDB(dbURI + config.AppName + "?parseTime=true&multiStatements=true")
-	if err != nil {
-		log.Fatal(err)
-		return app, Base{}
-	}
-
 	// set db connections
 	// db.SetMaxIdleConns(2)
 	// db.SetMaxOpenConns(20)
@@ -725,31 +846,70 @@ exec bash
 
 	// create email model
 	mailModel := email.NewMailModel(db, &wg, config.AppName)
+	mailModel.Dialect = config.Dialect
+	mailModel.Transport = mailTransport(o, config.IsProduction)
+	if mailModel.DKIM, err = email.DKIMFromEnv(); err != nil {
+		return fail(fmt.Errorf("failed to load DKIM key: %w", err))
+	}
+	if config.EmailTemplates != nil {
+		if err := mailModel.LoadTemplates(*config.EmailTemplates, config.FuncMap); err != nil {
+			return fail(fmt.Errorf("failed to load email templates: %w", err))
+		}
+	}
+	mmgModel := payments.NewMMG(db, &wg, config.AppName)
+	mmgModel.Dialect = config.Dialect
+
+	bank := helpers.NewBank(storage, config.AppName)
+
+	// record security-relevant changes made by the built-in models
+	auditModel := &models.AuditModel{DB: db, Dialect: config.Dialect}
+	mailModel.Audit = auditModel.Emit
+	mmgModel.Audit = auditModel.Emit
+	mmgModel.Mail = mailModel
+	listModel := &email.ListModel{DB: db, Dialect: config.Dialect, Mail: mailModel, WaitGroup: &wg, Audit: auditModel.Emit}
 
 	// attaching users to base
-	base := Base{
-		Users:     &models.UserModel{DB: db},
-		DB:        db,
-		Store:     store,
-		Shelf:     &helpers.ShelfModel{DB: db},
-		Flash:     &helpers.FlashModel{Store: store},
-		Bank:      helpers.NewBank(storage, config.AppName),
-		MMG:       payments.NewMMG(db, &wg, config.AppName),
-		Anchor:    ":" + config.Port,
-		QR:        helpers.NewQR(),
-		Mail:      mailModel,
-		WaitGroup: &wg,
-		SiteMap:   helpers.NewSitemap(config.IP),
-
-		isProd: config.IsProduction,
-		domain: config.IP,
-		port:   config.Port,
-	}
-
-	// run special migrations
-	helpers.InitShelf(db, config.AppName)
-	models.InitUsers(db, config.AppName)
+	base = &Base{
+		Users:          &models.UserModel{DB: db, Dialect: config.Dialect, Mail: mailModel, Audit: auditModel.Emit},
+		Passkeys:       &models.PasskeyModel{DB: db, Dialect: config.Dialect},
+		Roles:          &models.RoleModel{DB: db, Dialect: config.Dialect},
+		Sessions:       &models.SessionModel{Store: store, Bank: bank},
+		Identities:     &models.IdentityModel{DB: db, Dialect: config.Dialect},
+		Impersonations: &models.ImpersonationModel{DB: db, Dialect: config.Dialect},
+		Audit:          auditModel,
+		DB:             db,
+		Store:          store,
+		Shelf:          &helpers.ShelfModel{DB: db, Dialect: config.Dialect},
+		Flash:          &helpers.FlashModel{Store: store},
+		Bank:           bank,
+		MMG:            mmgModel,
+		Anchor:         ":" + config.Port,
+		QR:             helpers.NewQR(),
+		Mail:           mailModel,
+		Lists:          listModel,
+		WaitGroup:      &wg,
+		SiteMap:        helpers.NewSitemap(config.IP),
+
+		isProd:        config.IsProduction,
+		domain:        config.IP,
+		port:          config.Port,
+		appName:       config.AppName,
+		migrations:    runners,
+		mailTransport: mailModel.Transport,
+		logFile:       logFile,
+	}
+
+	if !fiber.IsChild() {
+		go base.purgeAuditEvents(ctx)
+
+		// Serve stops the workers and waits for the outbox to drain before closing the database
+		workerCtx, stopWorkers := context.WithCancel(ctx)
+		base.stopWorkers = stopWorkers
+		mailModel.StartOutbox(workerCtx)
+		listModel.StartCampaigns(workerCtx)
+	}
 
+	app.Use(requestID)
 	app.Use(etag.New(etag.Config{
 		Weak: false,
 	}))
@@ -762,7 +922,12 @@ exec bash
 	app.Use(pprof.New(pprof.Config{Prefix: "/profiler"}))
 	// app.Get("/metrics", monitor.New())
 
+	// sign out sessions started before a password change
+	app.Use(models.SessionVersionMiddleware(store, base.Users))
+	// index signed in sessions and give each sign-in a new session ID
+	app.Use(models.SessionIndexMiddleware(store, base.Sessions))
 	app.Use(helpers.SessionLocalsMiddleware(store))
+	app.Use(base.impersonationLocals)
 	app.Use(helpers.SessionOldValuesMiddleware(store))
 
 	environment := "dev"
@@ -775,5 +940,5 @@ exec bash
 	}
 
 	// return configured fiber app and database connection pool
-	return app, base
+	return app, base, nil
 }
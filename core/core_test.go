@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/helpers"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+)
+
+var testDialect = dialect.SQLite{Driver: "sqlite3"}
+
+func TestMain(m *testing.M) {
+	// New writes config.env, the deployment files and logs to the working directory
+	dir, err := os.MkdirTemp("", "boiler-core")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	// normally read from config.env
+	viper.Set("MAIL_USERNAME", "Boiler")
+	viper.Set("MAIL_USER_EMAIL", "boiler@example.com")
+	viper.Set("ADMIN_EMAIL", "admin@example.com")
+	gob.Register(models.User{})
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestDB opens a SQLite database in a temporary directory with the boiler
+// migrations applied
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(testDialect.DriverName(), testDialect.DSN(t.TempDir(), "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	runners, err := migrationRunners(db, AppConfig{Dialect: testDialect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyMigrations(context.Background(), runners); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestApp builds the app with New on a SQLite database of its own, with
+// emails delivered to the returned transport
+func newTestApp(t *testing.T) (*fiber.App, *Base, *email.MemoryTransport) {
+	t.Helper()
+	db, err := sql.Open(testDialect.DriverName(), testDialect.DSN(t.TempDir(), "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	transport := &email.MemoryTransport{}
+	app, base, err := New(ctx,
+		WithConfig(AppConfig{User: "test", IP: "example.com", Port: "8080", AppName: "test", Dialect: testDialect}),
+		WithDB(db),
+		WithStorage(helpers.NewMemoryStorage()),
+		WithPrefork(false),
+		WithoutAssetPipeline(),
+		WithMailTransport(transport),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, base, transport
+}
+
+// testClient sends requests to an app, keeping the cookies it sets like a browser
+type testClient struct {
+	app     *fiber.App
+	cookies map[string]string
+}
+
+func newTestClient(app *fiber.App) *testClient {
+	return &testClient{app: app, cookies: make(map[string]string)}
+}
+
+// do sends the request with the client's cookies, posting form when it isn't nil
+func (tc *testClient) do(t *testing.T, method, path string, form url.Values, headers ...string) *http.Response {
+	t.Helper()
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, path, body)
+	if form != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	for name, value := range tc.cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	resp, err := tc.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			delete(tc.cookies, cookie.Name)
+		} else {
+			tc.cookies[cookie.Name] = cookie.Value
+		}
+	}
+	return resp
+}
+
+// csrf returns a CSRF token for the client's session from a route that
+// renders c.Locals("csrf"), see csrfRoute
+func (tc *testClient) csrf(t *testing.T) string {
+	t.Helper()
+	_, token := tc.text(t, fiber.MethodGet, "/csrf", nil)
+	return token
+}
+
+// csrfRoute adds the /csrf route testClient.csrf reads tokens from
+func csrfRoute(app *fiber.App) {
+	app.Get("/csrf", func(c *fiber.Ctx) error {
+		token, _ := c.Locals("csrf").(string)
+		return c.SendString(token)
+	})
+}
+
+// text sends the request like do and returns the response body
+func (tc *testClient) text(t *testing.T, method, path string, form url.Values, headers ...string) (int, string) {
+	t.Helper()
+	resp := tc.do(t, method, path, form, headers...)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestNewClosesDBOnError(t *testing.T) {
+	openFiles := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("no /proc/self/fd to count open files")
+		}
+		return len(entries)
+	}
+	// New opens the SQLite database itself, then fails on the storage backend
+	t.Setenv("FIBER_USER_URI", t.TempDir()+"/")
+	config := AppConfig{User: "test", IP: "example.com", Port: "8080", AppName: "test", Dialect: testDialect, StorageBackend: "bogus"}
+
+	before := openFiles()
+	for range 3 {
+		if _, _, err := New(context.Background(), WithConfig(config), WithPrefork(false), WithoutAssetPipeline()); err == nil {
+			t.Fatal("New accepted an unknown storage backend")
+		}
+	}
+	if after := openFiles(); after != before {
+		t.Fatalf("%d files open after New failed 3 times; want the %d open before", after, before)
+	}
+}
+
+func TestNewAppMounts(t *testing.T) {
+	t.Setenv("FIBER_USER_URI", t.TempDir()+"/")
+	app, base := NewApp(AppConfig{User: "test", IP: "example.com", Port: "8080", AppName: "test", Dialect: testDialect, StorageBackend: StorageMemory})
+	// the middleware New registers has to see what is mounted on the returned base
+	base.MountLists(app, "/lists")
+	base.MountImpersonation(app, "/impersonate")
+	app.Get("/banner", func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("impersonationPath").(string))
+	})
+
+	// a one-click unsubscribe gets past the CSRF check to the unknown token
+	status, _ := newTestClient(app).text(t, fiber.MethodPost, "/lists/unsubscribe/unknown",
+		url.Values{"List-Unsubscribe": {"One-Click"}}, fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	if status != fiber.StatusNotFound {
+		t.Fatalf("one-click unsubscribe = %d; want %d", status, fiber.StatusNotFound)
+	}
+	// the impersonation banner's form posts to the mounted prefix
+	if _, got := newTestClient(app).text(t, fiber.MethodGet, "/banner", nil); got != "/impersonate" {
+		t.Fatalf("impersonation path = %q; want /impersonate", got)
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// DKIMRecordFile is where the DNS record of the DKIM key is written
+var DKIMRecordFile = "remote/dkim.txt"
+
+// generateDKIMKey creates the DKIM key set by MAIL_DKIM_KEY in config.env when
+// it doesn't exist yet, and writes the TXT record publishing it to remote/
+// along with the nginx and service files
+func generateDKIMKey() error {
+	keyPath := helpers.GetenvDefault("MAIL_DKIM_KEY", "")
+	if helpers.GetenvDefault("MAIL_DKIM_SELECTOR", "") == "" || keyPath == "" {
+		return nil
+	}
+	generated := false
+	if !helpers.FileExists(keyPath) {
+		if err := email.GenerateDKIMKey(keyPath); err != nil {
+			return err
+		}
+		generated = true
+	}
+
+	signer, err := email.DKIMFromEnv()
+	if err != nil {
+		return err
+	}
+	value, err := signer.RecordValue()
+	if err != nil {
+		return err
+	}
+	zone, err := signer.ZoneRecord()
+	if err != nil {
+		return err
+	}
+	record := fmt.Sprintf("Name: %s\nType: TXT\nValue: %s\n\nZone file:\n%s\n", signer.RecordName(), value, zone)
+	if err := helpers.SaveTextToDirectory(record, DKIMRecordFile); err != nil {
+		return err
+	}
+	if generated {
+		log.Infof("generated DKIM key %s, publish this DNS record before sending mail (saved to %s):\n%s",
+			keyPath, DKIMRecordFile, zone)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConfigError reports an AppConfig field that was left empty
+type ConfigError struct {
+	Field   string
+	Example string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config error: %s not specified e.g. %s", e.Field, e.Example)
+}
+
+var (
+	ErrMissingUser    = &ConfigError{Field: "user", Example: "john"}
+	ErrMissingIP      = &ConfigError{Field: "IP", Example: "example.com"}
+	ErrMissingPort    = &ConfigError{Field: "port", Example: "9910"}
+	ErrMissingAppName = &ConfigError{Field: "app name", Example: "myapp"}
+)
+
+// Validate returns every missing field of the config joined into a single error
+func (config AppConfig) Validate() error {
+	var errs []error
+	if config.User == "" {
+		errs = append(errs, ErrMissingUser)
+	}
+	if config.IP == "" {
+		errs = append(errs, ErrMissingIP)
+	}
+	if config.Port == "" {
+		errs = append(errs, ErrMissingPort)
+	}
+	if config.AppName == "" {
+		errs = append(errs, ErrMissingAppName)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// ImpersonationRole is the role allowed to impersonate other users
+var ImpersonationRole = "admin"
+
+var (
+	ErrNotAdmin         = errors.New("core: only admins can impersonate users")
+	ErrNotImpersonating = errors.New("core: not impersonating anyone")
+)
+
+// Impersonate signs the admin in as the user with targetEmail. The admin is kept
+// in the "impersonator" session key until StopImpersonating, and every role check
+// sees the impersonated user in the meantime.
+func (base *Base) Impersonate(c *fiber.Ctx, targetEmail string) error {
+	admin, impersonating := base.Flash.Get(c, "impersonator").(models.User)
+	if !impersonating {
+		admin = helpers.GetUser[models.User](c, base.Flash)
+	}
+	if admin.Email == "" || admin.TwoFactorPending || !admin.HasRole(ImpersonationRole) {
+		return ErrNotAdmin
+	}
+	target, err := base.Users.EmailAuthenticate(targetEmail)
+	if err != nil {
+		return err
+	}
+	// the admin already passed two-factor authentication
+	target.TwoFactorPending = false
+
+	err = base.Flash.SetMany(c, map[string]any{
+		"user":         target,
+		"impersonator": admin,
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("%s started impersonating %s", admin.Email, target.Email)
+	base.audit(c, AuditImpersonationStart, target.Email, nil)
+	return base.Impersonations.Record(admin.ID, target.ID, models.ImpersonationStart, c.IP())
+}
+
+// StopImpersonating signs the admin back in as themselves
+func (base *Base) StopImpersonating(c *fiber.Ctx) error {
+	admin, impersonating := base.Flash.Get(c, "impersonator").(models.User)
+	if !impersonating {
+		return ErrNotImpersonating
+	}
+	target := helpers.GetUser[models.User](c, base.Flash)
+
+	sess, err := base.Store.Get(c)
+	if err != nil {
+		return err
+	}
+	sess.Set("user", admin)
+	sess.Delete("impersonator")
+	if err := sess.Save(); err != nil {
+		return err
+	}
+	log.Infof("%s stopped impersonating %s", admin.Email, target.Email)
+	base.audit(c, AuditImpersonationStop, target.Email, nil)
+	return base.Impersonations.Record(admin.ID, target.ID, models.ImpersonationStop, c.IP())
+}
+
+// MountImpersonation adds the impersonation actions under prefix, e.g. for the prefix "/impersonate":
+// POST /impersonate with an email field starts impersonating and POST /impersonate/stop returns to
+// the admin. The impersonation-banner partial links to the latter while impersonation is active.
+func (base *Base) MountImpersonation(router fiber.Router, prefix string) {
+	base.impersonationPath = prefix
+
+	router.Post(prefix, func(c *fiber.Ctx) error {
+		targetEmail := strings.TrimSpace(c.FormValue("email"))
+		err := base.Impersonate(c, targetEmail)
+		if errors.Is(err, ErrNotAdmin) {
+			return base.Flash.Redirect(c, "/", "You need to be logged in as %s", ImpersonationRole)
+		}
+		if errors.Is(err, models.ErrNoRecord) {
+			return base.Flash.Redirect(c, "/", "There is no user with that email")
+		}
+		if errors.Is(err, models.ErrAccountLocked) {
+			return base.Flash.Redirect(c, "/", "That account is locked")
+		}
+		if err != nil {
+			return err
+		}
+		return base.Flash.Redirect(c, "/", "You are now viewing the site as %s", targetEmail)
+	})
+
+	router.Post(prefix+"/stop", func(c *fiber.Ctx) error {
+		err := base.StopImpersonating(c)
+		if errors.Is(err, ErrNotImpersonating) {
+			return c.Redirect("/")
+		}
+		if err != nil {
+			return err
+		}
+		return base.Flash.Redirect(c, "/", "You are back to your own account")
+	})
+}
+
+// impersonationLocals tells the impersonation-banner partial where to stop impersonating
+func (base *Base) impersonationLocals(c *fiber.Ctx) error {
+	c.Locals("impersonationPath", base.impersonationPath)
+	return c.Next()
+}
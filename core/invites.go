@@ -0,0 +1,76 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/email"
+)
+
+// MountInvites adds the accept invitation page under prefix, e.g. /invite/:token
+// for the prefix "/invite". Users.Invite can send invitations once it is mounted.
+func (base *Base) MountInvites(router fiber.Router, prefix string) {
+	if users, ok := base.Users.(*models.UserModel); ok {
+		users.InviteURL = base.URL() + prefix + "/"
+	}
+
+	router.Get(prefix+"/:token", func(c *fiber.Ctx) error {
+		userEmail, err := base.Mail.CheckMagicLink(c.Params("token"), models.InvitePurpose)
+		if err != nil {
+			return base.inviteFailed(c, err)
+		}
+		invite, err := base.Users.GetInvite(userEmail)
+		if err != nil {
+			return base.inviteFailed(c, err)
+		}
+		return c.Render("views/partials/invite-accept", fiber.Map{
+			"Title":     "Accept invitation",
+			"Action":    prefix + "/" + c.Params("token"),
+			"Email":     invite.Email,
+			"Name":      invite.Name,
+			"MinLength": MinPasswordLength,
+		})
+	})
+
+	router.Post(prefix+"/:token", func(c *fiber.Ctx) error {
+		token := c.Params("token")
+		password := c.FormValue("password")
+		if len(password) < MinPasswordLength {
+			return base.Flash.Redirect(c, prefix+"/"+token, "Your password needs at least %d characters", MinPasswordLength)
+		}
+		if password != c.FormValue("confirm") {
+			return base.Flash.Redirect(c, prefix+"/"+token, "The passwords don't match")
+		}
+		userEmail, err := base.Mail.UseMagicLink(token, models.InvitePurpose)
+		if err != nil {
+			return base.inviteFailed(c, err)
+		}
+		err = base.Users.AcceptInvite(userEmail, strings.TrimSpace(c.FormValue("name")), password)
+		if err != nil {
+			return base.inviteFailed(c, err)
+		}
+		log.Infof("invitation accepted by %s", userEmail)
+
+		user, err := base.Users.EmailAuthenticate(userEmail)
+		if err != nil {
+			return err
+		}
+		base.Flash.Push(c, "Welcome, your account is ready")
+		return base.signIn(c, user)
+	})
+}
+
+func (base *Base) inviteFailed(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, email.ErrInvalidMagicLink), errors.Is(err, models.ErrNoRecord):
+		return base.Flash.Redirect(c, "/login", "That invitation is invalid or has expired, please ask for a new one")
+	case errors.Is(err, models.ErrDuplicateEmail):
+		return base.Flash.Redirect(c, "/login", "You already have an account, please log in")
+	}
+	log.Errorf("invitation error: %v", err)
+	return fmt.Errorf("invitation: %w", err)
+}
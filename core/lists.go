@@ -0,0 +1,110 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/email"
+)
+
+// MountLists adds the mailing list pages under prefix, e.g. for the prefix "/lists":
+//
+//	POST /lists/:list/subscribe       subscribes the form's email, sending it a link to confirm
+//	GET  /lists/:list/confirm/:token  confirms a subscription
+//	GET  /lists/unsubscribe/:token    asks to confirm unsubscribing
+//	POST /lists/unsubscribe/:token    unsubscribes, including RFC 8058 one-click requests
+//
+// Campaigns can only be sent once these pages are mounted, since their emails link to them.
+func (base *Base) MountLists(router fiber.Router, prefix string) {
+	if lists, ok := base.Lists.(*email.ListModel); ok {
+		lists.URL = base.URL() + prefix
+	}
+	base.unsubscribePath = prefix + "/unsubscribe/"
+
+	router.Post(prefix+"/:list/subscribe", func(c *fiber.Ctx) error {
+		next := c.FormValue("next")
+		// only redirect within the site
+		if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+			next = "/"
+		}
+		address := strings.TrimSpace(c.FormValue("email"))
+		if address == "" {
+			return base.Flash.Redirect(c, next, "Enter your email to subscribe")
+		}
+		err := base.Lists.Subscribe(c.Params("list"), address)
+		if errors.Is(err, email.ErrNoList) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			log.Errorf("subscribe error: %v", err)
+		}
+		// same response either way so subscribers can't be discovered
+		return base.Flash.Redirect(c, next, "Check %s for a link to confirm your subscription", address)
+	})
+
+	router.Get(prefix+"/:list/confirm/:token", func(c *fiber.Ctx) error {
+		_, err := base.Lists.ConfirmSubscription(c.Params("list"), c.Params("token"))
+		if errors.Is(err, email.ErrInvalidMagicLink) {
+			return base.Flash.Redirect(c, "/", "That confirmation link is invalid or has expired, please subscribe again")
+		}
+		if errors.Is(err, email.ErrNoList) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("subscription confirmation: %w", err)
+		}
+		return base.Flash.Redirect(c, "/", "Your subscription has been confirmed")
+	})
+
+	router.Get(base.unsubscribePath+":token", func(c *fiber.Ctx) error {
+		subscriber, err := base.Lists.GetSubscriber(c.Params("token"))
+		if errors.Is(err, email.ErrNoSubscriber) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("unsubscribe: %w", err)
+		}
+		title := subscriber.ListTitle
+		if title == "" {
+			title = subscriber.ListName
+		}
+		return c.Render("views/partials/unsubscribe", fiber.Map{
+			"Title":        "Unsubscribe",
+			"Action":       c.OriginalURL(),
+			"Email":        subscriber.Email,
+			"List":         title,
+			"Unsubscribed": subscriber.Status == email.SubscriberUnsubscribed,
+		})
+	})
+
+	router.Post(base.unsubscribePath+":token", func(c *fiber.Ctx) error {
+		_, err := base.Lists.Unsubscribe(c.Params("token"), c.QueryInt("campaign"))
+		if errors.Is(err, email.ErrNoSubscriber) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("unsubscribe: %w", err)
+		}
+		// mail clients unsubscribing in one click don't follow redirects
+		if isOneClickUnsubscribe(c) {
+			return c.SendString("Unsubscribed")
+		}
+		return base.Flash.Redirect(c, "/", "You have been unsubscribed")
+	})
+}
+
+// isOneClickUnsubscribe reports whether the request is an RFC 8058 unsubscribe
+// sent by a mail client
+func isOneClickUnsubscribe(c *fiber.Ctx) bool {
+	return c.FormValue("List-Unsubscribe") == "One-Click"
+}
+
+// skipsCSRF lets one-click unsubscribe requests through the CSRF check, which
+// mail clients can't pass, but only on the unsubscribe pages
+func (base *Base) skipsCSRF(c *fiber.Ctx) bool {
+	return base != nil && base.unsubscribePath != "" && c.Method() == fiber.MethodPost &&
+		strings.HasPrefix(c.Path(), base.unsubscribePath) && isOneClickUnsubscribe(c)
+}
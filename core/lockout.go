@@ -0,0 +1,222 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+var (
+	// LoginFailureLimit is how many failed logins an email gets before it is locked out
+	LoginFailureLimit = 5
+	// LoginIPFailureLimit is the same for an IP address, higher since an IP can be shared
+	LoginIPFailureLimit = 20
+	// LoginLockout is the first lockout, doubled for every further failure up to LoginMaxLockout
+	LoginLockout    = time.Minute
+	LoginMaxLockout = 24 * time.Hour
+	// LoginFailureWindow is how long failed logins are counted for
+	LoginFailureWindow = 24 * time.Hour
+	// LoginNotifyThreshold failures for one email or IP send the admin an email,
+	// once per LoginFailureWindow
+	LoginNotifyThreshold = 20
+)
+
+// loginFailuresMu guards reading and bumping the failure counters in the
+// Bank, so concurrent failures all count
+var loginFailuresMu sync.Mutex
+
+// LoginLockoutMiddleware protects a login POST handler that reads the "email" form field,
+// checks the password with base.Authenticate and signs the user in by setting "user" in
+// the session, e.g. app.Post("/login", base.LoginLockoutMiddleware(), loginHandler).
+// Wrong passwords are counted per email and per IP, and past the limits further
+// attempts are refused for an exponentially growing lockout.
+func (base *Base) LoginLockoutMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodPost {
+			return c.Next()
+		}
+		userEmail := strings.TrimSpace(c.FormValue("email"))
+		ip := c.IP()
+
+		if wait := base.loginLockWait(userEmail, ip); wait > 0 {
+			return base.Flash.Redirect(c, c.Path(), "Too many failed attempts, try again in %s", formatWait(wait))
+		}
+
+		before := helpers.GetUser[models.User](c, base.Flash)
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		if failed, _ := c.Locals("loginFailed").(bool); failed {
+			base.recordLoginFailure(userEmail, ip)
+			base.audit(c, AuditLoginFailure, userEmail, nil)
+			return nil
+		}
+		// the handler signed the user in, rather than them having been signed in already
+		after := helpers.GetUser[models.User](c, base.Flash)
+		if userEmail != "" && after.ID != 0 && after.ID != before.ID && strings.EqualFold(after.Email, userEmail) {
+			base.resetLoginFailures("email", userEmail)
+			base.audit(c, AuditLoginSuccess, userEmail, nil)
+		}
+		return nil
+	}
+}
+
+// Authenticate checks the password like Users.Authenticate and, behind
+// LoginLockoutMiddleware, counts a wrong one as a failed login
+func (base *Base) Authenticate(c *fiber.Ctx, userEmail, password string) (models.User, error) {
+	user, err := base.Users.Authenticate(userEmail, password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		c.Locals("loginFailed", true)
+	}
+	return user, err
+}
+
+// UnlockLogin lifts a lockout on the email and forgets its failed logins and two-factor codes
+func (base *Base) UnlockLogin(userEmail string) error {
+	base.resetLoginFailures("email", userEmail)
+	base.resetLoginFailures("totp", userEmail)
+	base.Bank.Delete(loginLockKey("email", userEmail))
+	return base.Users.Unlock(userEmail)
+}
+
+// loginLockWait returns how long the email or IP is still locked out for
+func (base *Base) loginLockWait(userEmail, ip string) time.Duration {
+	wait := base.bankLockWait("ip", ip)
+	if userEmail == "" {
+		return wait
+	}
+	wait = max(wait, base.bankLockWait("email", userEmail))
+
+	// the column outlives the bank, e.g. after a restart with memory storage
+	lockedUntil, err := base.Users.LockedUntil(userEmail)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		log.Errorf("lockout lookup error: %v", err)
+	}
+	return max(wait, time.Until(lockedUntil))
+}
+
+func (base *Base) bankLockWait(kind, value string) time.Duration {
+	until, err := strconv.ParseInt(base.Bank.GetString(loginLockKey(kind, value)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Until(time.Unix(until, 0))
+}
+
+func (base *Base) recordLoginFailure(userEmail, ip string) {
+	ipFailures := base.countLoginFailure("ip", ip)
+	if lockout := loginBackoff(ipFailures, LoginIPFailureLimit); lockout > 0 {
+		base.Bank.SetString(loginLockKey("ip", ip), strconv.FormatInt(time.Now().Add(lockout).Unix(), 10), lockout)
+		log.Infof("login locked for ip %s for %v after %d failures", ip, lockout, ipFailures)
+	}
+	if base.claimLoginNotification("ip", ip, ipFailures) {
+		base.Mail.NotifyAdmin("Repeated failed logins",
+			"There have been %d failed logins from %s in the last %v.", ipFailures, ip, LoginFailureWindow)
+	}
+	if userEmail == "" {
+		return
+	}
+
+	emailFailures := base.countLoginFailure("email", userEmail)
+	if lockout := loginBackoff(emailFailures, LoginFailureLimit); lockout > 0 {
+		base.lockEmail(userEmail, lockout)
+		log.Infof("login locked for %s for %v after %d failures", userEmail, lockout, emailFailures)
+	}
+	if base.claimLoginNotification("email", userEmail, emailFailures) {
+		base.Mail.NotifyAdmin("Repeated failed logins",
+			"There have been %d failed logins for %s in the last %v, the latest from %s.", emailFailures, userEmail, LoginFailureWindow, ip)
+	}
+}
+
+// lockEmail refuses logins for the email for the lockout
+func (base *Base) lockEmail(userEmail string, lockout time.Duration) {
+	until := time.Now().Add(lockout)
+	if err := base.Users.Lock(userEmail, until); err != nil {
+		log.Errorf("lockout error: %v", err)
+	}
+	base.Bank.SetString(loginLockKey("email", userEmail), strconv.FormatInt(until.Unix(), 10), lockout)
+}
+
+// countLoginFailure increments and returns a failure counter, whose window
+// starts with the first failure and lasts for LoginFailureWindow
+func (base *Base) countLoginFailure(kind, value string) int {
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+
+	key := loginFailureKey(kind, value)
+	// stored as "<failures> <end of the window in unix seconds>"
+	count, end, _ := strings.Cut(base.Bank.GetString(key), " ")
+	failures, _ := strconv.Atoi(count)
+	until, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || time.Now().Unix() >= until {
+		failures, until = 0, time.Now().Add(LoginFailureWindow).Unix()
+	}
+	failures++
+	base.Bank.SetString(key, strconv.Itoa(failures)+" "+strconv.FormatInt(until, 10), time.Until(time.Unix(until, 0)))
+	return failures
+}
+
+// claimLoginNotification reports whether the failures have reached
+// LoginNotifyThreshold and the admin hasn't been told about them this window
+func (base *Base) claimLoginNotification(kind, value string, failures int) bool {
+	if failures < LoginNotifyThreshold {
+		return false
+	}
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+
+	key := loginNotifiedKey(kind, value)
+	if base.Bank.GetString(key) != "" {
+		return false
+	}
+	base.Bank.SetString(key, "1", LoginFailureWindow)
+	return true
+}
+
+// resetLoginFailures forgets the failures counted for the value
+func (base *Base) resetLoginFailures(kind, value string) {
+	loginFailuresMu.Lock()
+	defer loginFailuresMu.Unlock()
+	base.Bank.Delete(loginFailureKey(kind, value))
+	base.Bank.Delete(loginNotifiedKey(kind, value))
+}
+
+// loginBackoff is LoginLockout doubled for every failure past the limit, or 0 below it
+func loginBackoff(failures, limit int) time.Duration {
+	if failures < limit {
+		return 0
+	}
+	lockout := LoginLockout
+	for i := limit; i < failures && lockout < LoginMaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, LoginMaxLockout)
+}
+
+func loginFailureKey(kind, value string) string {
+	return "login-failures-" + kind + "-" + strings.ToLower(value)
+}
+
+func loginNotifiedKey(kind, value string) string {
+	return "login-notified-" + kind + "-" + strings.ToLower(value)
+}
+
+func loginLockKey(kind, value string) string {
+	return "login-lock-" + kind + "-" + strings.ToLower(value)
+}
+
+// formatWait rounds a lockout up to whole seconds or minutes for display
+func formatWait(wait time.Duration) string {
+	if wait < time.Minute {
+		return (wait + time.Second - 1).Truncate(time.Second).String()
+	}
+	return (wait + time.Minute - 1).Truncate(time.Minute).String()
+}
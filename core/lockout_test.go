@@ -0,0 +1,203 @@
+package core
+
+import (
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// newLockoutTest returns a client for an app whose /login signs in whoever
+// gets their password right, and asks for one when it's missing, behind the
+// lockout middleware, and jane@example.com with the password "password"
+func newLockoutTest(t *testing.T) (*testClient, *Base, *email.MailModel) {
+	t.Helper()
+	db := newTestDB(t)
+	mail := &email.MailModel{DB: db, Dialect: testDialect, Transport: &email.MemoryTransport{}}
+	bank := helpers.NewBank(helpers.NewMemoryStorage(), "test")
+	t.Cleanup(bank.Close)
+	base := &Base{
+		Users: &models.UserModel{DB: db, Dialect: testDialect},
+		Audit: &models.AuditModel{DB: db, Dialect: testDialect},
+		Flash: &helpers.FlashModel{Store: session.New()},
+		Bank:  bank,
+		Mail:  mail,
+	}
+	if err := base.Users.Insert("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Post("/login", base.LoginLockoutMiddleware(), func(c *fiber.Ctx) error {
+		if c.FormValue("password") == "" {
+			return c.SendString("missing")
+		}
+		user, err := base.Authenticate(c, c.FormValue("email"), c.FormValue("password"))
+		if err != nil {
+			return c.SendString("failed")
+		}
+		if err := base.Flash.Set(c, "user", user); err != nil {
+			return err
+		}
+		return c.SendString("signed in")
+	})
+	return newTestClient(app), base, mail
+}
+
+// login posts the credentials, returning the handler's response or "locked"
+// when the middleware refused the attempt
+func login(t *testing.T, tc *testClient, userEmail, password string) string {
+	t.Helper()
+	status, body := tc.text(t, fiber.MethodPost, "/login", url.Values{"email": {userEmail}, "password": {password}})
+	if status == fiber.StatusFound {
+		return "locked"
+	}
+	return body
+}
+
+// setLoginLimits changes the lockout limits for the test
+func setLoginLimits(t *testing.T, emailLimit, ipLimit, notify int) {
+	t.Helper()
+	saved := []int{LoginFailureLimit, LoginIPFailureLimit, LoginNotifyThreshold}
+	LoginFailureLimit, LoginIPFailureLimit, LoginNotifyThreshold = emailLimit, ipLimit, notify
+	t.Cleanup(func() {
+		LoginFailureLimit, LoginIPFailureLimit, LoginNotifyThreshold = saved[0], saved[1], saved[2]
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	setLoginLimits(t, 3, 100, 100)
+	tests := []struct {
+		name string
+		// attempts are passwords tried in order by one browser
+		attempts []string
+		want     []string
+	}{
+		{
+			name:     "locked after the limit",
+			attempts: []string{"wrong", "wrong", "wrong", "password"},
+			want:     []string{"failed", "failed", "failed", "locked"},
+		},
+		{
+			name:     "signing in resets the count",
+			attempts: []string{"wrong", "wrong", "password", "wrong", "wrong", "password"},
+			want:     []string{"failed", "failed", "signed in", "failed", "failed", "signed in"},
+		},
+		{
+			// someone already signed in as the user doesn't reset the count
+			name:     "signed in already",
+			attempts: []string{"password", "wrong", "wrong", "password", "wrong", "password"},
+			want:     []string{"signed in", "failed", "failed", "signed in", "failed", "locked"},
+		},
+		{
+			name:     "only wrong passwords count",
+			attempts: []string{"", "", "", "wrong", "password"},
+			want:     []string{"missing", "missing", "missing", "failed", "signed in"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, _, _ := newLockoutTest(t)
+			var got []string
+			for _, password := range tt.attempts {
+				got = append(got, login(t, tc, "jane@example.com", password))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("logins = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnlockLogin(t *testing.T) {
+	setLoginLimits(t, 2, 100, 100)
+	tc, base, _ := newLockoutTest(t)
+	login(t, tc, "jane@example.com", "wrong")
+	login(t, tc, "jane@example.com", "wrong")
+	if got := login(t, tc, "jane@example.com", "password"); got != "locked" {
+		t.Fatalf("login after the limit = %q; want locked", got)
+	}
+	if err := base.UnlockLogin("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := login(t, tc, "jane@example.com", "password"); got != "signed in" {
+		t.Fatalf("login after unlocking = %q; want signed in", got)
+	}
+}
+
+func TestLoginNotifiesAdminOnce(t *testing.T) {
+	setLoginLimits(t, 100, 100, 3)
+	tc, _, mail := newLockoutTest(t)
+	for range 6 {
+		login(t, tc, "jane@example.com", "wrong")
+	}
+	queued, err := mail.ListOutbox(email.OutboxPending, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notified := 0
+	for _, message := range queued {
+		if slices.Contains(message.Recipients, "admin@example.com") {
+			notified++
+		}
+	}
+	// one for the email and one for the IP
+	if notified != 2 {
+		t.Fatalf("admin was notified %d times; want 2", notified)
+	}
+}
+
+func TestCountLoginFailureConcurrent(t *testing.T) {
+	bank := helpers.NewBank(helpers.NewMemoryStorage(), "test")
+	t.Cleanup(bank.Close)
+	base := &Base{Bank: bank}
+	const n = 20
+	counts := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[i] = base.countLoginFailure("email", "Jane@example.com")
+		}()
+	}
+	wg.Wait()
+
+	// every failure counts, so the last one sees all of them
+	if got := slices.Max(counts); got != n {
+		t.Fatalf("highest count = %d; want %d", got, n)
+	}
+	if got := base.countLoginFailure("email", "jane@example.com"); got != n+1 {
+		t.Fatalf("count after the concurrent failures = %d; want %d", got, n+1)
+	}
+	base.resetLoginFailures("email", "jane@example.com")
+	if got := base.countLoginFailure("email", "jane@example.com"); got != 1 {
+		t.Fatalf("count after a reset = %d; want 1", got)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures, limit int
+		want            time.Duration
+	}{
+		{0, 5, 0},
+		{4, 5, 0},
+		{5, 5, LoginLockout},
+		{6, 5, 2 * LoginLockout},
+		{8, 5, 8 * LoginLockout},
+		{1000, 5, LoginMaxLockout},
+	}
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures, tt.limit); got != tt.want {
+			t.Errorf("loginBackoff(%d, %d) = %v; want %v", tt.failures, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"bytes"
+	"embed"
+	ht "html/template"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+//go:embed mailbox/*.html
+var mailboxFS embed.FS
+
+// MailboxDir is where emails are written during development when MAIL_HOST isn't set
+var MailboxDir = "mail"
+
+// mailTransport is the transport given to New, otherwise SMTP, or files in
+// MailboxDir during development without a mail server
+func mailTransport(o options, isProd bool) email.Transport {
+	if o.mailTransport != nil {
+		return o.mailTransport
+	}
+	if !isProd && helpers.GetenvDefault("MAIL_HOST", "") == "" {
+		return &email.FileTransport{Dir: MailboxDir}
+	}
+	return email.NewSMTPTransport()
+}
+
+// mountMailbox lists the emails kept by a capturing mail transport under /_mail,
+// outside production only
+func (base *Base) mountMailbox(router fiber.Router) {
+	capturer, ok := base.mailTransport.(email.Capturer)
+	if base.isProd || !ok {
+		return
+	}
+	pages := ht.Must(ht.New("").Funcs(ht.FuncMap{
+		"humanTime": func(t time.Time) string {
+			return t.UTC().Format("Jan 02, 2006 @ 15:04:05 hrs")
+		},
+		"join": strings.Join,
+	}).ParseFS(mailboxFS, "mailbox/*.html"))
+	render := func(c *fiber.Ctx, page, title string, data fiber.Map) error {
+		data["Title"] = title
+		data["csrf"] = c.Locals("csrf")
+		data["flash"] = c.Locals("flash")
+		var buf bytes.Buffer
+		if err := pages.ExecuteTemplate(&buf, page, data); err != nil {
+			return err
+		}
+		c.Type("html")
+		return c.Send(buf.Bytes())
+	}
+	find := func(id string) (email.CapturedMessage, bool) {
+		for _, message := range capturer.Messages() {
+			if message.ID == id {
+				return message, true
+			}
+		}
+		return email.CapturedMessage{}, false
+	}
+
+	router.Get("/_mail", func(c *fiber.Ctx) error {
+		return render(c, "list", "Mail", fiber.Map{
+			"Messages": capturer.Messages(),
+		})
+	})
+
+	router.Post("/_mail/clear", func(c *fiber.Ctx) error {
+		if err := capturer.Reset(); err != nil {
+			return err
+		}
+		return base.Flash.Redirect(c, "/_mail", "Deleted all emails")
+	})
+
+	router.Get("/_mail/:id", func(c *fiber.Ctx) error {
+		message, ok := find(c.Params("id"))
+		if !ok {
+			return fiber.ErrNotFound
+		}
+		return render(c, "message", message.Subject(), fiber.Map{
+			"Message": message,
+		})
+	})
+
+	router.Get("/_mail/:id/raw", func(c *fiber.Ctx) error {
+		message, ok := find(c.Params("id"))
+		if !ok {
+			return fiber.ErrNotFound
+		}
+		c.Type("txt", "utf-8")
+		return c.Send(message.Raw)
+	})
+}
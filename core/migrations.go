@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io"
+	"os"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/helpers"
+	"github.com/joashgobin/boiler/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migration scopes recorded in schema_migrations
+const (
+	boilerScope = "boiler"
+	appScope    = "app"
+)
+
+// Migrate runs an up, down or status migration command without starting the app,
+// e.g. from a -migrate flag. It accepts the same options as New.
+func Migrate(ctx context.Context, command string, w io.Writer, opts ...Option) error {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.config.Validate(); err != nil {
+		return err
+	}
+	db := o.db
+	if db == nil {
+		var err error
+		db, err = openDB(ctx, o.config.Dialect, o.config.AppName)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+	runners, err := migrationRunners(db, o.config)
+	if err != nil {
+		return err
+	}
+	return migrate.Run(ctx, command, w, runners...)
+}
+
+// Migrate runs an up, down or status migration command against the app database
+func (base *Base) Migrate(ctx context.Context, command string, w io.Writer) error {
+	return migrate.Run(ctx, command, w, base.migrations...)
+}
+
+// migrationRunners returns the boiler migrations followed by the app's own
+func migrationRunners(db *sql.DB, config AppConfig) ([]*migrate.Runner, error) {
+	boiler, err := migrate.New(db, config.Dialect, boilerScope, migrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	// moves the pipe-delimited users.roles column into user_roles
+	if err := boiler.Register(12, "backfill_user_roles", models.BackfillUserRoles, models.RestoreRolesColumn); err != nil {
+		return nil, err
+	}
+	runners := []*migrate.Runner{boiler}
+	if config.Migrations != nil {
+		app, err := migrate.New(db, config.Dialect, appScope, *config.Migrations, "migrations")
+		if err != nil {
+			return nil, err
+		}
+		runners = append(runners, app)
+	}
+	return runners, nil
+}
+
+func applyMigrations(ctx context.Context, runners []*migrate.Runner) error {
+	for _, runner := range runners {
+		applied, err := runner.Up(ctx)
+		for _, migration := range applied {
+			log.Infof("applied %s migration %04d_%s", runner.Scope(), migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// open database corresponding to app name
+func openDB(ctx context.Context, d dialect.Dialect, appName string) (*sql.DB, error) {
+	d = dialect.Or(d)
+	return helpers.OpenDBDriver(ctx, d.DriverName(), d.DSN(os.Getenv("FIBER_USER_URI"), appName))
+}
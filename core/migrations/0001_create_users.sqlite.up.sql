@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id <autoincrement>,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    roles VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    created <datetime> NOT NULL,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS shelf (
+    name VARCHAR(100) NOT NULL UNIQUE,
+    value VARCHAR(100) NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS magiclinks (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    email VARCHAR(100) NOT NULL,
+    purpose VARCHAR(100) NOT NULL,
+    value VARCHAR(200) NOT NULL UNIQUE,
+    result VARCHAR(200) NOT NULL,
+    used BOOLEAN
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS merchants;
+DROP TABLE IF EXISTS transactions;
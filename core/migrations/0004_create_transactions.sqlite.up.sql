@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS transactions (
+    id <autoincrement>,
+    timestamp <datetime> NOT NULL,
+    reference VARCHAR(20) NOT NULL UNIQUE,
+    source VARCHAR(20) NOT NULL,
+    destination VARCHAR(20) NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    currency VARCHAR(5) NOT NULL,
+    category VARCHAR(30) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    metadata VARCHAR(100),
+    "user" VARCHAR(100),
+    productcode VARCHAR(200),
+    internalid VARCHAR(40),
+    expiration_date <datetime>
+);
+
+CREATE TABLE IF NOT EXISTS merchants (
+    id <autoincrement>,
+    name VARCHAR(100) NOT NULL,
+    number INTEGER NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS products (
+    id <autoincrement>,
+    code VARCHAR(100) NOT NULL UNIQUE,
+    description VARCHAR(300) NOT NULL
+);
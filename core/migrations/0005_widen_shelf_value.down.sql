@@ -0,0 +1 @@
+ALTER TABLE shelf MODIFY value VARCHAR(100) NOT NULL;
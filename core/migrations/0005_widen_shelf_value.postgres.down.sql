@@ -0,0 +1 @@
+ALTER TABLE shelf ALTER COLUMN value TYPE VARCHAR(100);
@@ -0,0 +1,2 @@
+-- SQLite does not enforce VARCHAR lengths, so there is nothing to widen
+SELECT 1;
@@ -0,0 +1 @@
+ALTER TABLE shelf MODIFY value TEXT NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE magiclinks DROP COLUMN expires_at;
+ALTER TABLE magiclinks DROP COLUMN created;
@@ -0,0 +1,2 @@
+ALTER TABLE magiclinks ADD COLUMN created <datetime> NULL;
+ALTER TABLE magiclinks ADD COLUMN expires_at <datetime> NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN verified_at <datetime> NULL;
+-- accounts created before verification was required count as verified
+UPDATE users SET verified_at = created;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id <autoincrement>,
+    user_id INTEGER NOT NULL,
+    credential_id VARCHAR(512) NOT NULL,
+    public_key <blob> NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    name VARCHAR(100) NOT NULL,
+    created <datetime> NOT NULL,
+    last_used_at <datetime> NULL,
+    CONSTRAINT passkeys_uc_credential UNIQUE (credential_id)
+);
+CREATE INDEX passkeys_user_id ON passkeys (user_id);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id <autoincrement>,
+    name VARCHAR(100) NOT NULL,
+    CONSTRAINT roles_uc_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id <autoincrement>,
+    name VARCHAR(100) NOT NULL,
+    CONSTRAINT permissions_uc_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL,
+    role_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INTEGER NOT NULL,
+    permission_id INTEGER NOT NULL,
+    PRIMARY KEY (role_id, permission_id)
+);
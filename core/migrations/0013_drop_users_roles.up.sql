@@ -0,0 +1,2 @@
+-- roles live in user_roles since 0012
+ALTER TABLE users DROP COLUMN roles;
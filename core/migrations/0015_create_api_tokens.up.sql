@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id <autoincrement>,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(1000) NOT NULL DEFAULT '',
+    created <datetime> NOT NULL,
+    expires_at <datetime> NULL,
+    last_used_at <datetime> NULL,
+    CONSTRAINT api_tokens_uc_hash UNIQUE (token_hash)
+);
+CREATE INDEX api_tokens_user_id ON api_tokens (user_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_identities (
+    id <autoincrement>,
+    user_id INTEGER NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created <datetime> NOT NULL,
+    CONSTRAINT user_identities_uc_subject UNIQUE (provider, subject)
+);
+CREATE INDEX user_identities_user_id ON user_identities (user_id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS impersonations (
+    id <autoincrement>,
+    admin_id INTEGER NOT NULL,
+    target_id INTEGER NOT NULL,
+    action VARCHAR(10) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    created <datetime> NOT NULL
+);
+CREATE INDEX impersonations_admin_id ON impersonations (admin_id);
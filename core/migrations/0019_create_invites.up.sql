@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS invites (
+    id <autoincrement>,
+    email VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    roles VARCHAR(1000) NOT NULL DEFAULT '',
+    invited_by INTEGER NULL,
+    created <datetime> NOT NULL,
+    expires_at <datetime> NOT NULL,
+    accepted_at <datetime> NULL,
+    CONSTRAINT invites_uc_email UNIQUE (email)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id <autoincrement>,
+    created <datetime> NOT NULL,
+    actor_id INTEGER NULL,
+    actor_email VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(100) NOT NULL,
+    target VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    metadata TEXT NULL
+);
+CREATE INDEX audit_events_created ON audit_events (created);
+CREATE INDEX audit_events_action ON audit_events (action);
+CREATE INDEX audit_events_actor_id ON audit_events (actor_id);
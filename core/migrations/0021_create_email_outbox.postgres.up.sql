@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id <autoincrement>,
+    sender VARCHAR(255) NOT NULL,
+    recipients VARCHAR(2000) NOT NULL,
+    subject VARCHAR(255) NOT NULL DEFAULT '',
+    message <blob> NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error VARCHAR(1000) NOT NULL DEFAULT '',
+    created <datetime> NOT NULL,
+    next_attempt_at <datetime> NOT NULL,
+    claimed_at <datetime> NULL,
+    sent_at <datetime> NULL
+);
+CREATE INDEX email_outbox_status ON email_outbox (status, next_attempt_at);
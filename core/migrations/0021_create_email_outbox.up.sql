@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    sender VARCHAR(255) NOT NULL,
+    recipients VARCHAR(2000) NOT NULL,
+    subject VARCHAR(255) NOT NULL DEFAULT '',
+    message LONGBLOB NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error VARCHAR(1000) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL,
+    next_attempt_at DATETIME NOT NULL,
+    claimed_at DATETIME NULL,
+    sent_at DATETIME NULL
+);
+CREATE INDEX email_outbox_status ON email_outbox (status, next_attempt_at);
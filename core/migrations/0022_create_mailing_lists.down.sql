@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS campaign_recipients;
+DROP TABLE IF EXISTS campaigns;
+DROP TABLE IF EXISTS subscribers;
+DROP TABLE IF EXISTS lists;
@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS lists (
+    id <autoincrement>,
+    name VARCHAR(100) NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    created <datetime> NOT NULL,
+    CONSTRAINT lists_uc_name UNIQUE (name)
+);
+CREATE TABLE IF NOT EXISTS subscribers (
+    id <autoincrement>,
+    list_id INTEGER NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    token CHAR(43) NOT NULL,
+    created <datetime> NOT NULL,
+    confirmed_at <datetime> NULL,
+    unsubscribed_at <datetime> NULL,
+    unsubscribed_campaign_id INTEGER NULL,
+    CONSTRAINT subscribers_uc_list_email UNIQUE (list_id, email),
+    CONSTRAINT subscribers_uc_token UNIQUE (token)
+);
+CREATE TABLE IF NOT EXISTS campaigns (
+    id <autoincrement>,
+    list_id INTEGER NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    template VARCHAR(100) NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    created <datetime> NOT NULL,
+    started_at <datetime> NULL,
+    finished_at <datetime> NULL,
+    next_batch_at <datetime> NULL
+);
+CREATE INDEX campaigns_status ON campaigns (status, next_batch_at);
+CREATE TABLE IF NOT EXISTS campaign_recipients (
+    campaign_id INTEGER NOT NULL,
+    subscriber_id INTEGER NOT NULL,
+    outbox_id INTEGER NULL,
+    error VARCHAR(1000) NOT NULL DEFAULT '',
+    created <datetime> NOT NULL,
+    PRIMARY KEY (campaign_id, subscriber_id)
+);
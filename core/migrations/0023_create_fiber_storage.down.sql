@@ -0,0 +1 @@
+DROP TABLE IF EXISTS fiber_storage;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS fiber_storage (
+    k VARCHAR(255) NOT NULL PRIMARY KEY,
+    v <blob> NOT NULL,
+    e BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// audit actions emitted by boiler's own models
+const (
+	AuditRoleAssign     = "role.assign"
+	AuditRoleRemove     = "role.remove"
+	AuditPasswordChange = "password.change"
+	AuditAccountLock    = "account.lock"
+	AuditAccountUnlock  = "account.unlock"
+	AuditAccountDelete  = "account.delete"
+	AuditTOTPEnable     = "totp.enable"
+	AuditTOTPDisable    = "totp.disable"
+	AuditTokenMint      = "token.mint"
+	AuditTokenRevoke    = "token.revoke"
+	AuditInviteSend     = "invite.send"
+)
+
+// AuditRetention is how long audit events are kept by Purge, 0 keeps them forever
+var AuditRetention = 365 * 24 * time.Hour
+
+type AuditModelInterface interface {
+	Record(c *fiber.Ctx, action, target string, metadata map[string]any) error
+	Emit(actor, action, target string, metadata map[string]any)
+	Insert(event AuditEvent) error
+	List(ctx context.Context, query AuditQuery) ([]AuditEvent, error)
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+type AuditEvent struct {
+	ID         int
+	Created    time.Time
+	ActorID    int
+	ActorEmail string
+	Action     string
+	Target     string
+	IP         string
+	UserAgent  string
+	RequestID  string
+	Metadata   map[string]any
+}
+
+// AuditQuery filters AuditModel.List, zero values leave a filter out
+type AuditQuery struct {
+	ActorID    int
+	ActorEmail string
+	// Action matches exactly, or every action under it when it ends with a dot, e.g. "role."
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// BeforeID continues a listing from the last event of the previous page
+	BeforeID int
+	// Limit is the page size, DefaultPageSize when 0 and at most MaxPageSize
+	Limit int
+}
+
+type AuditModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+var _ AuditModelInterface = (*AuditModel)(nil)
+
+// Record stores an event for the request with the signed in user as the actor,
+// along with the IP, user agent and request ID. An admin impersonating the user
+// is added to the metadata as "impersonator".
+func (m *AuditModel) Record(c *fiber.Ctx, action, target string, metadata map[string]any) error {
+	event := AuditEvent{
+		Action:    action,
+		Target:    target,
+		IP:        c.IP(),
+		UserAgent: truncate(c.Get(fiber.HeaderUserAgent), 255),
+		Metadata:  metadata,
+	}
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		event.RequestID = requestID
+	}
+	if user, ok := c.Locals("user").(User); ok {
+		event.ActorID = user.ID
+		event.ActorEmail = user.Email
+	}
+	if admin, ok := c.Locals("impersonator").(User); ok && admin.Email != "" {
+		event.Metadata = make(map[string]any, len(metadata)+1)
+		for key, value := range metadata {
+			event.Metadata[key] = value
+		}
+		event.Metadata["impersonator"] = admin.Email
+	}
+	return m.Insert(event)
+}
+
+// Emit stores an event raised outside a request, logging rather than returning
+// errors so models can call it as a helpers.AuditFunc
+func (m *AuditModel) Emit(actor, action, target string, metadata map[string]any) {
+	err := m.Insert(AuditEvent{ActorEmail: actor, Action: action, Target: target, Metadata: metadata})
+	if err != nil {
+		log.Errorf("audit %s %s error: %v", action, target, err)
+	}
+}
+
+func (m *AuditModel) Insert(event AuditEvent) error {
+	var metadata sql.NullString
+	if len(event.Metadata) > 0 {
+		b, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = sql.NullString{String: string(b), Valid: true}
+	}
+	var actorID sql.NullInt64
+	if event.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: int64(event.ActorID), Valid: true}
+	}
+	d := dialect.Or(m.Dialect)
+	stmt := `INSERT INTO audit_events (created, actor_id, actor_email, action, target, ip, user_agent, request_id, metadata)
+	VALUES (` + d.Now() + `, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := m.DB.Exec(d.Rebind(stmt), actorID, event.ActorEmail, event.Action, truncate(event.Target, 255),
+		event.IP, event.UserAgent, event.RequestID, metadata)
+	return err
+}
+
+// List returns matching events, newest first
+func (m *AuditModel) List(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	var where []string
+	var args []any
+	if query.ActorID != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, query.ActorID)
+	}
+	if query.ActorEmail != "" {
+		where = append(where, "actor_email = ?")
+		args = append(args, query.ActorEmail)
+	}
+	if strings.HasSuffix(query.Action, ".") {
+		where = append(where, "action LIKE ?")
+		args = append(args, query.Action+"%")
+	} else if query.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, query.Action)
+	}
+	if query.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, query.Target)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, dialect.Time(m.Dialect, query.Since))
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, dialect.Time(m.Dialect, query.Until))
+	}
+	if query.BeforeID != 0 {
+		where = append(where, "id < ?")
+		args = append(args, query.BeforeID)
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	args = append(args, min(limit, MaxPageSize))
+
+	stmt := `SELECT id, created, actor_id, actor_email, action, target, ip, user_agent, request_id, metadata
+	FROM audit_events`
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	stmt += ` ORDER BY id DESC LIMIT ?`
+	rows, err := m.DB.QueryContext(ctx, dialect.Or(m.Dialect).Rebind(stmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		var actorID sql.NullInt64
+		var metadata sql.NullString
+		err := rows.Scan(&event.ID, &event.Created, &actorID, &event.ActorEmail, &event.Action, &event.Target,
+			&event.IP, &event.UserAgent, &event.RequestID, &metadata)
+		if err != nil {
+			return nil, err
+		}
+		event.ActorID = int(actorID.Int64)
+		if metadata.Valid {
+			if err := json.Unmarshal([]byte(metadata.String), &event.Metadata); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Purge deletes events older than olderThan and returns how many were deleted
+func (m *AuditModel) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	stmt := `DELETE FROM audit_events WHERE created < ?`
+	result, err := m.DB.ExecContext(ctx, dialect.Or(m.Dialect).Rebind(stmt),
+		dialect.Time(m.Dialect, time.Now().Add(-olderThan)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
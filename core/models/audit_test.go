@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestAuditTimeFilters compares bound times with the created column, which
+// SQLite keeps as CURRENT_TIMESTAMP text
+func TestAuditTimeFilters(t *testing.T) {
+	ctx := context.Background()
+	m := &AuditModel{DB: newTestDB(t), Dialect: testDialect}
+	if err := m.Insert(AuditEvent{ActorEmail: "admin@example.com", Action: "user.lock", Target: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := m.List(ctx, AuditQuery{})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("List = %v, %v; want the event", events, err)
+	}
+	created := events[0].Created
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  int
+	}{
+		{"since the second it was created", AuditQuery{Since: created}, 1},
+		{"since a zone other than UTC", AuditQuery{Since: created.In(time.FixedZone("GYT", -4*60*60))}, 1},
+		{"since the next second", AuditQuery{Since: created.Add(time.Second)}, 0},
+		{"until the second it was created", AuditQuery{Until: created}, 0},
+		{"until the next second", AuditQuery{Until: created.Add(time.Second)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := m.List(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != tt.want {
+				t.Fatalf("listed %d events; want %d", len(events), tt.want)
+			}
+		})
+	}
+
+	if purged, err := m.Purge(ctx, time.Hour); err != nil || purged != 0 {
+		t.Fatalf("Purge of events older than an hour = %d, %v; want 0", purged, err)
+	}
+	if purged, err := m.Purge(ctx, -time.Second); err != nil || purged != 1 {
+		t.Fatalf("Purge of every event = %d, %v; want 1", purged, err)
+	}
+}
@@ -8,4 +8,15 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrNoMail             = errors.New("models: no mail model to send verification")
+	ErrNoVerifyURL        = errors.New("models: no verify url, mount the verification pages with MountEmailVerification")
+	ErrInvalidCode        = errors.New("models: invalid two-factor code")
+	ErrTOTPEnabled        = errors.New("models: two-factor authentication already enabled")
+	ErrTOTPNotStarted     = errors.New("models: two-factor setup not started")
+	ErrDuplicatePasskey   = errors.New("models: duplicate passkey")
+	ErrAccountLocked      = errors.New("models: account temporarily locked")
+	ErrInvalidToken       = errors.New("models: invalid or expired api token")
+	ErrDuplicateIdentity  = errors.New("models: identity already linked")
+	ErrInvalidCursor      = errors.New("models: invalid page cursor")
+	ErrInvalidSort        = errors.New("models: unknown sort column")
 )
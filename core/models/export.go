@@ -0,0 +1,279 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// DeleteMode chooses how UserModel.Delete erases an account
+type DeleteMode int
+
+const (
+	// DeleteSoft marks the user deleted, which can be undone in the database
+	DeleteSoft DeleteMode = iota
+	// DeleteAnonymise removes everything that identifies the user and keeps
+	// anonymous rows, e.g. transactions, for the books
+	DeleteAnonymise
+)
+
+// DataHook adds an app's own tables to user exports and deletions
+type DataHook struct {
+	// Export returns the user's data, written as <name>.json in the export
+	Export func(user User) (any, error)
+	// Delete removes or anonymises the user's data, it runs before boiler's own tables
+	Delete func(user User, mode DeleteMode) error
+}
+
+type namedHook struct {
+	name string
+	hook DataHook
+}
+
+var dataHooks []namedHook
+
+// RegisterDataHook includes an app's tables in Export and Delete under name
+func RegisterDataHook(name string, hook DataHook) {
+	dataHooks = append(dataHooks, namedHook{name: name, hook: hook})
+}
+
+// exportQuery is a table of the export and the columns that are safe to hand out
+type exportQuery struct {
+	name  string
+	query string
+	by    exportArg
+}
+
+// exportArg is what an export query selects the user's rows by
+type exportArg int
+
+const (
+	byEmail exportArg = iota
+	byID
+	// byRecipient matches the email in a comma separated list, see recipientArgs
+	byRecipient
+)
+
+// matchRecipient is a condition on email_outbox for the messages to an email,
+// taking the arguments from recipientArgs
+const matchRecipient = `(recipients = ? OR recipients LIKE ? ESCAPE '!' OR recipients LIKE ? ESCAPE '!'
+OR recipients LIKE ? ESCAPE '!')`
+
+// recipientArgs are the arguments of matchRecipient for the email as the only,
+// first, last or a middle recipient
+func recipientArgs(email string) []any {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(email)
+	return []any{email, escaped + ",%", "%," + escaped, "%," + escaped + ",%"}
+}
+
+func exportQueries(d dialect.Dialect) []exportQuery {
+	return []exportQuery{
+		{"user", `SELECT id, name, email, created, verified_at, totp_enabled_at, locked_until, deleted_at FROM users WHERE id = ?`, byID},
+		{"roles", `SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = ?`, byID},
+		{"passkeys", `SELECT name, created, last_used_at FROM passkeys WHERE user_id = ?`, byID},
+		{"api_tokens", `SELECT name, scopes, created, expires_at, last_used_at FROM api_tokens WHERE user_id = ?`, byID},
+		{"identities", `SELECT provider, subject, email, created FROM user_identities WHERE user_id = ?`, byID},
+		{"impersonations", `SELECT action, created FROM impersonations WHERE target_id = ?`, byID},
+		{"audit_events", `SELECT action, target, ip, user_agent, created FROM audit_events WHERE actor_id = ?`, byID},
+		{"invite", `SELECT name, roles, created, expires_at, accepted_at FROM invites WHERE email = ?`, byEmail},
+		{"invites_sent", `SELECT email, name, roles, created, expires_at, accepted_at FROM invites WHERE invited_by = ?`, byID},
+		{"magiclinks", `SELECT purpose, used, created, expires_at FROM magiclinks WHERE email = ?`, byEmail},
+		{"subscriptions", `SELECT l.name, s.status, s.created, s.confirmed_at, s.unsubscribed_at
+		FROM subscribers s JOIN lists l ON l.id = s.list_id WHERE s.email = ?`, byEmail},
+		{"emails", `SELECT subject, status, attempts, created, sent_at FROM email_outbox WHERE ` + matchRecipient, byRecipient},
+		{"transactions", `SELECT timestamp, reference, amount, currency, category, status, productcode
+		FROM transactions WHERE ` + d.Quote("user") + ` = ?`, byEmail},
+	}
+}
+
+// Export returns a ZIP with a JSON file for every table that references the
+// user, plus those added with RegisterDataHook. Secrets such as password and
+// token hashes are left out.
+func (m *UserModel) Export(email string) ([]byte, error) {
+	d := dialect.Or(m.Dialect)
+	user, err := m.lookup(email)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	writeJSON := func(name string, v any) error {
+		w, err := archive.Create(name + ".json")
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	for _, q := range exportQueries(d) {
+		args := []any{email}
+		switch q.by {
+		case byID:
+			args = []any{user.ID}
+		case byRecipient:
+			args = recipientArgs(email)
+		}
+		rows, err := queryMaps(m.DB, d.Rebind(q.query), args...)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", q.name, err)
+		}
+		if err := writeJSON(q.name, rows); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range dataHooks {
+		if h.hook.Export == nil {
+			continue
+		}
+		data, err := h.hook.Export(user)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", h.name, err)
+		}
+		if err := writeJSON(h.name, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Delete erases the user, signing them out everywhere. A soft deleted user
+// can't sign in and is left out of GetAll but keeps their email reserved.
+func (m *UserModel) Delete(email string, mode DeleteMode) error {
+	user, err := m.lookup(email)
+	if err != nil {
+		return err
+	}
+	for _, h := range dataHooks {
+		if h.hook.Delete == nil {
+			continue
+		}
+		if err := h.hook.Delete(user, mode); err != nil {
+			return fmt.Errorf("delete %s: %w", h.name, err)
+		}
+	}
+
+	d := dialect.Or(m.Dialect)
+	if mode == DeleteSoft {
+		stmt := `UPDATE users SET deleted_at = ` + d.Now() + `, session_version = session_version + 1
+		WHERE id = ? AND deleted_at IS NULL`
+		if _, err := m.DB.Exec(d.Rebind(stmt), user.ID); err != nil {
+			return err
+		}
+		m.Audit.Emit("", AuditAccountDelete, email, map[string]any{"user_id": user.ID, "mode": "soft"})
+		return nil
+	}
+
+	// a hash no password matches
+	b := make([]byte, 30)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	unusable := "!" + hex.EncodeToString(b)[:59]
+	anonymous := "deleted-" + strconv.Itoa(user.ID) + "@invalid"
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`UPDATE users SET name = ?, email = ?, hashed_password = ?, totp_secret = NULL, totp_enabled_at = NULL,
+		locked_until = NULL, session_version = session_version + 1, deleted_at = ` + d.Now() + ` WHERE id = ?`,
+			[]any{"Deleted user", anonymous, unusable, user.ID}},
+		{`DELETE FROM user_roles WHERE user_id = ?`, []any{user.ID}},
+		{`DELETE FROM recovery_codes WHERE user_id = ?`, []any{user.ID}},
+		{`DELETE FROM passkeys WHERE user_id = ?`, []any{user.ID}},
+		{`DELETE FROM api_tokens WHERE user_id = ?`, []any{user.ID}},
+		{`DELETE FROM user_identities WHERE user_id = ?`, []any{user.ID}},
+		{`DELETE FROM magiclinks WHERE email = ?`, []any{email}},
+		{`DELETE FROM invites WHERE email = ?`, []any{email}},
+		{`UPDATE invites SET invited_by = NULL WHERE invited_by = ?`, []any{user.ID}},
+		// the messages hold the address, and links meant for the user
+		{`DELETE FROM email_outbox WHERE ` + matchRecipient, recipientArgs(email)},
+		{`UPDATE transactions SET ` + d.Quote("user") + ` = ? WHERE ` + d.Quote("user") + ` = ?`, []any{anonymous, email}},
+		{`UPDATE audit_events SET actor_email = ?, ip = '', user_agent = '' WHERE actor_id = ? OR actor_email = ?`, []any{anonymous, user.ID, email}},
+		{`UPDATE audit_events SET target = ? WHERE target = ?`, []any{anonymous, email}},
+		// kept unsubscribed so campaign stats still add up
+		{`UPDATE subscribers SET email = ?, status = 'unsubscribed', unsubscribed_at = COALESCE(unsubscribed_at, ` + d.Now() + `)
+		WHERE email = ?`, []any{anonymous, email}},
+	}
+	for _, s := range statements {
+		if _, err := tx.Exec(d.Rebind(s.query), s.args...); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// the email is gone, only the id links the event to the anonymous row
+	m.Audit.Emit("", AuditAccountDelete, anonymous, map[string]any{"user_id": user.ID, "mode": "anonymise"})
+	return nil
+}
+
+// lookup returns the user with the email, deleted or not
+func (m *UserModel) lookup(email string) (User, error) {
+	var user User
+	stmt := "SELECT id, name, email, created FROM users WHERE email = ?"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNoRecord
+	}
+	return user, err
+}
+
+// queryMaps returns rows as column to value maps for JSON
+func queryMaps(db *sql.DB, query string, args ...any) ([]map[string]any, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[string]any{}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			switch value := values[i].(type) {
+			case []byte:
+				row[column] = string(value)
+			case time.Time:
+				row[column] = value.UTC()
+			default:
+				row[column] = value
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
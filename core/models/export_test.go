@@ -0,0 +1,74 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/joashgobin/boiler/email"
+)
+
+func TestOutboxExportAndDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		recipients []string
+		mine       bool
+	}{
+		{"only recipient", []string{"jane_doe@example.com"}, true},
+		{"first recipient", []string{"jane_doe@example.com", "bob@example.com"}, true},
+		{"last recipient", []string{"bob@example.com", "jane_doe@example.com"}, true},
+		{"middle recipient", []string{"bob@example.com", "jane_doe@example.com", "ann@example.com"}, true},
+		{"longer address", []string{"mary.jane_doe@example.com"}, false},
+		{"underscore matching any character", []string{"jane-doe@example.com", "bob@example.com"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestUsers(t)
+			mail, _ := newTestMail(m.DB)
+			if err := m.Insert("Jane", "jane_doe@example.com", "password"); err != nil {
+				t.Fatal(err)
+			}
+			message := email.NewMessage().To(tt.recipients...).Subject("Hello").Text("Hello")
+			if _, err := mail.QueueMessage(message); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := m.Export("jane_doe@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := archive.Open("emails.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exported []map[string]any
+			if err := json.NewDecoder(file).Decode(&exported); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(exported) == 1; got != tt.mine {
+				t.Fatalf("exported %d emails; want the message exported %v", len(exported), tt.mine)
+			}
+			if len(exported) == 1 {
+				if _, ok := exported[0]["message"]; ok {
+					t.Fatal("the export includes the message, which may hold secret links")
+				}
+			}
+
+			if err := m.Delete("jane_doe@example.com", DeleteAnonymise); err != nil {
+				t.Fatal(err)
+			}
+			queued, err := mail.ListOutbox("", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := len(queued) == 1; kept == tt.mine {
+				t.Fatalf("%d emails left after the delete; want the message kept %v", len(queued), !tt.mine)
+			}
+		})
+	}
+}
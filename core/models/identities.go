@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+)
+
+type IdentityModelInterface interface {
+	Insert(userID int, provider, subject, email string) error
+	Get(provider, subject string) (Identity, error)
+	ListForUser(userID int) ([]Identity, error)
+	Delete(userID int, provider string) error
+}
+
+// Identity links an account at a sign in provider to a user
+type Identity struct {
+	ID       int
+	UserID   int
+	Provider string
+	Subject  string
+	// Email is the address the provider reported when the identity was linked
+	Email string
+	// UserEmail is the user's own address
+	UserEmail string
+	Created   time.Time
+}
+
+type IdentityModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+var _ IdentityModelInterface = (*IdentityModel)(nil)
+
+func (m *IdentityModel) rebind(query string) string {
+	return dialect.Or(m.Dialect).Rebind(query)
+}
+
+func (m *IdentityModel) Insert(userID int, provider, subject, email string) error {
+	d := dialect.Or(m.Dialect)
+	stmt := `INSERT INTO user_identities (user_id, provider, subject, email, created)
+	VALUES (?, ?, ?, ?, ` + d.Now() + `)`
+	_, err := m.DB.Exec(d.Rebind(stmt), userID, provider, subject, email)
+	if err != nil && d.IsDuplicate(err) {
+		return ErrDuplicateIdentity
+	}
+	return err
+}
+
+// Get returns the identity with its user's email
+func (m *IdentityModel) Get(provider, subject string) (Identity, error) {
+	var identity Identity
+	stmt := `SELECT i.id, i.user_id, i.provider, i.subject, i.email, u.email, i.created
+	FROM user_identities i JOIN users u ON u.id = i.user_id
+	WHERE i.provider = ? AND i.subject = ?`
+	err := m.DB.QueryRow(m.rebind(stmt), provider, subject).Scan(&identity.ID, &identity.UserID,
+		&identity.Provider, &identity.Subject, &identity.Email, &identity.UserEmail, &identity.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Identity{}, ErrNoRecord
+	}
+	return identity, err
+}
+
+func (m *IdentityModel) ListForUser(userID int) ([]Identity, error) {
+	stmt := `SELECT id, user_id, provider, subject, email, created
+	FROM user_identities WHERE user_id = ? ORDER BY provider`
+	rows, err := m.DB.Query(m.rebind(stmt), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject,
+			&identity.Email, &identity.Created)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Delete unlinks the user's identity at the provider
+func (m *IdentityModel) Delete(userID int, provider string) error {
+	stmt := `DELETE FROM user_identities WHERE user_id = ? AND provider = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), userID, provider)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// impersonation actions recorded in the audit table
+const (
+	ImpersonationStart = "start"
+	ImpersonationStop  = "stop"
+)
+
+type ImpersonationModelInterface interface {
+	Record(adminID, targetID int, action, ip string) error
+	List(limit int) ([]Impersonation, error)
+}
+
+// Impersonation is one entry of the impersonation audit trail
+type Impersonation struct {
+	ID          int
+	AdminID     int
+	AdminEmail  string
+	TargetID    int
+	TargetEmail string
+	Action      string
+	IP          string
+	Created     time.Time
+}
+
+type ImpersonationModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+var _ ImpersonationModelInterface = (*ImpersonationModel)(nil)
+
+func (m *ImpersonationModel) Record(adminID, targetID int, action, ip string) error {
+	d := dialect.Or(m.Dialect)
+	stmt := `INSERT INTO impersonations (admin_id, target_id, action, ip, created)
+	VALUES (?, ?, ?, ?, ` + d.Now() + `)`
+	_, err := m.DB.Exec(d.Rebind(stmt), adminID, targetID, action, ip)
+	return err
+}
+
+// List returns the latest entries with the emails of both users, newest first
+func (m *ImpersonationModel) List(limit int) ([]Impersonation, error) {
+	stmt := `SELECT i.id, i.admin_id, COALESCE(a.email, ''), i.target_id, COALESCE(t.email, ''), i.action, i.ip, i.created
+	FROM impersonations i
+	LEFT JOIN users a ON a.id = i.admin_id
+	LEFT JOIN users t ON t.id = i.target_id
+	ORDER BY i.id DESC LIMIT ?`
+	rows, err := m.DB.Query(dialect.Or(m.Dialect).Rebind(stmt), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var impersonations []Impersonation
+	for rows.Next() {
+		var i Impersonation
+		err := rows.Scan(&i.ID, &i.AdminID, &i.AdminEmail, &i.TargetID, &i.TargetEmail, &i.Action, &i.IP, &i.Created)
+		if err != nil {
+			return nil, err
+		}
+		impersonations = append(impersonations, i)
+	}
+	return impersonations, rows.Err()
+}
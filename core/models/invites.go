@@ -0,0 +1,186 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// purpose recorded against invitation magic links
+const InvitePurpose = "invite"
+
+// InviteTTL is how long an invitation can be accepted for
+var InviteTTL = 7 * 24 * time.Hour
+
+type Invite struct {
+	ID         int
+	Email      string
+	Name       string
+	Roles      []string
+	InvitedBy  int
+	Created    time.Time
+	ExpiresAt  time.Time
+	AcceptedAt time.Time
+}
+
+// Invite emails a link to join with the given roles, replacing any earlier
+// invitation for the email. invitedBy is the inviting user's id, 0 for none.
+func (m *UserModel) Invite(email, name string, roles []string, invitedBy int) error {
+	if m.Mail == nil || m.InviteURL == "" {
+		return ErrNoMail
+	}
+	exists, err := m.Exists(email)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicateEmail
+	}
+
+	d := dialect.Or(m.Dialect)
+	var inviter sql.NullInt64
+	if invitedBy != 0 {
+		inviter = sql.NullInt64{Int64: int64(invitedBy), Valid: true}
+	}
+	now := time.Now()
+	columns := []string{"email", "name", "roles", "invited_by", "created", "expires_at", "accepted_at"}
+	_, err = m.DB.Exec(d.Rebind(d.Upsert("invites", []string{"email"}, columns, 1)),
+		email, name, strings.Join(roles, " "), inviter, dialect.Time(d, now), dialect.Time(d, now.Add(InviteTTL)), nil)
+	if err != nil {
+		return err
+	}
+
+	link := m.Mail.GetExpiringMagicLink(email, InvitePurpose, m.InviteURL, InviteTTL)
+	m.Mail.Send(email, "", "You're invited",
+		"You have been invited to create an account. Use the link below to choose a password, it expires in %d days.<br><a href=\"%s\">%s</a>",
+		int(InviteTTL.Hours()/24), link, link)
+	log.Infof("invited %s", email)
+	m.Audit.Emit("", AuditInviteSend, email, map[string]any{"roles": roles, "invited_by": invitedBy})
+	return nil
+}
+
+// GetInvite returns the open invitation for the email
+func (m *UserModel) GetInvite(email string) (Invite, error) {
+	var invite Invite
+	var roles string
+	var invitedBy sql.NullInt64
+	var acceptedAt sql.NullTime
+	stmt := `SELECT id, email, name, roles, invited_by, created, expires_at, accepted_at
+	FROM invites WHERE email = ?`
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&invite.ID, &invite.Email, &invite.Name, &roles,
+		&invitedBy, &invite.Created, &invite.ExpiresAt, &acceptedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Invite{}, ErrNoRecord
+	}
+	if err != nil {
+		return Invite{}, err
+	}
+	if acceptedAt.Valid || time.Now().After(invite.ExpiresAt) {
+		return Invite{}, ErrNoRecord
+	}
+	invite.Roles = strings.Fields(roles)
+	invite.InvitedBy = int(invitedBy.Int64)
+	return invite, nil
+}
+
+// AcceptInvite creates the invited user with their chosen password and roles,
+// all or nothing. The email counts as verified since the invitation link was
+// sent to it, so no verification email is sent.
+func (m *UserModel) AcceptInvite(email, name, password string) error {
+	invite, err := m.GetInvite(email)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = invite.Name
+	}
+
+	d := dialect.Or(m.Dialect)
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// claimed first so only one of two concurrent acceptances creates the user
+	stmt := `UPDATE invites SET accepted_at = ` + d.Now() + ` WHERE id = ? AND accepted_at IS NULL`
+	result, err := tx.Exec(d.Rebind(stmt), invite.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoRecord
+	}
+	if err := insertUser(tx, d, name, email, password, true); err != nil {
+		return err
+	}
+	for _, role := range invite.Roles {
+		if err := assignRole(tx, d, email, role); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, role := range invite.Roles {
+		m.Audit.Emit("", AuditRoleAssign, email, map[string]any{"role": role})
+	}
+	return nil
+}
+
+// InviteFromCSV invites every name,email,roles row of the file, with roles
+// separated by semicolons as in ParseFromCSV, and writes an email,status,error
+// row to report for each of them
+func (m *UserModel) InviteFromCSV(path string, invitedBy int, report io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	csvReader := csv.NewReader(file)
+	csvReader.FieldsPerRecord = -1
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(report)
+	w.Write([]string{"email", "status", "error"})
+	for i, record := range records {
+		if len(record) < 2 {
+			w.Write([]string{"", "failed", fmt.Sprintf("row %d needs at least a name and an email", i+1)})
+			continue
+		}
+		var roles []string
+		if len(record) >= 3 {
+			for _, role := range strings.Split(record[2], ";") {
+				if role = strings.TrimSpace(role); role != "" {
+					roles = append(roles, role)
+				}
+			}
+		}
+		userEmail := strings.TrimSpace(record[1])
+		err := m.Invite(userEmail, strings.TrimSpace(record[0]), roles, invitedBy)
+		switch {
+		case errors.Is(err, ErrDuplicateEmail):
+			w.Write([]string{userEmail, "skipped", "already has an account"})
+		case err != nil:
+			w.Write([]string{userEmail, "failed", err.Error()})
+		default:
+			w.Write([]string{userEmail, "invited", ""})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
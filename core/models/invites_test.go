@@ -0,0 +1,136 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/joashgobin/boiler/email"
+)
+
+// newInviteTest returns a UserModel that can send invitations, with the inviter
+// admin@example.com and an invitation for jane@example.com to be an editor
+func newInviteTest(t *testing.T) (*UserModel, *email.MailModel) {
+	t.Helper()
+	m := newTestUsers(t)
+	mail, _ := newTestMail(m.DB)
+	m.Mail, m.InviteURL, m.VerifyURL = mail, "http://localhost:8080/invite/", "http://localhost:8080/verify/"
+	if err := m.Insert("Admin", "admin@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := m.lookup("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Invite("jane@example.com", "Jane", []string{"editor"}, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	return m, mail
+}
+
+// subjects lists the subjects of the emails queued for the recipient
+func subjects(t *testing.T, mail *email.MailModel, recipient string) []string {
+	t.Helper()
+	queued, err := mail.ListOutbox("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, message := range queued {
+		if strings.Join(message.Recipients, ",") == recipient {
+			subjects = append(subjects, message.Subject)
+		}
+	}
+	return subjects
+}
+
+func TestAcceptInvite(t *testing.T) {
+	m, mail := newInviteTest(t)
+	if err := m.AcceptInvite("jane@example.com", "", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := m.Authenticate("jane@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Jane" || user.VerifiedAt.IsZero() || !user.HasRole("user") || !user.HasRole("editor") {
+		t.Fatalf("accepted user = %+v; want Jane, verified, with the user and editor roles", user)
+	}
+	// the invitation proved the address, so no verification email follows it
+	if got := subjects(t, mail, "jane@example.com"); len(got) != 1 || got[0] != "You're invited" {
+		t.Fatalf("emails to the invitee = %q; want only the invitation", got)
+	}
+	if err := m.AcceptInvite("jane@example.com", "", "secret"); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("accepting twice = %v; want ErrNoRecord", err)
+	}
+}
+
+func TestAcceptInviteRollsBack(t *testing.T) {
+	m, _ := newInviteTest(t)
+	// the address got an account another way after it was invited
+	if err := m.Insert("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AcceptInvite("jane@example.com", "", "secret"); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("AcceptInvite = %v; want ErrDuplicateEmail", err)
+	}
+	if _, err := m.GetInvite("jane@example.com"); err != nil {
+		t.Fatalf("the failed acceptance used up the invitation: %v", err)
+	}
+}
+
+func TestInviteExportAndDelete(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		file  string
+		// where is the condition on invites that must match nothing after the delete
+		where string
+	}{
+		{"invitee", "jane@example.com", "invite.json", "email = 'jane@example.com'"},
+		{"inviter", "admin@example.com", "invites_sent.json", "invited_by IS NOT NULL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newInviteTest(t)
+			if err := m.AcceptInvite("jane@example.com", "", "secret"); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := m.Export(tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := archive.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), `"editor"`) {
+				t.Fatalf("%s = %s; want the invitation", tt.file, content)
+			}
+
+			if err := m.Delete(tt.email, DeleteAnonymise); err != nil {
+				t.Fatal(err)
+			}
+			var left int
+			if err := m.DB.QueryRow("SELECT COUNT(*) FROM invites WHERE " + tt.where).Scan(&left); err != nil {
+				t.Fatal(err)
+			}
+			if left != 0 {
+				t.Fatalf("%d invites still point at the deleted user", left)
+			}
+		})
+	}
+}
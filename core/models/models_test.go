@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/migrate"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+)
+
+var testDialect = dialect.SQLite{Driver: "sqlite3"}
+
+func TestMain(m *testing.M) {
+	// the sender of queued emails, normally read from config.env
+	viper.Set("MAIL_USERNAME", "Boiler")
+	viper.Set("MAIL_USER_EMAIL", "boiler@example.com")
+	os.Exit(m.Run())
+}
+
+// newTestDB opens a SQLite database in a temporary directory with the boiler
+// migrations applied
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(testDialect.DriverName(), testDialect.DSN(t.TempDir(), "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	runner, err := migrate.New(db, testDialect, "boiler", os.DirFS(".."), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Register(12, "backfill_user_roles", BackfillUserRoles, RestoreRolesColumn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestUsers returns a UserModel without mail on a new test database
+func newTestUsers(t *testing.T) *UserModel {
+	t.Helper()
+	return &UserModel{DB: newTestDB(t), Dialect: testDialect}
+}
+
+// newTestMail returns a MailModel queueing to the outbox of db, delivering
+// through the returned transport once its worker is started
+func newTestMail(db *sql.DB) (*email.MailModel, *email.MemoryTransport) {
+	transport := &email.MemoryTransport{}
+	return &email.MailModel{DB: db, Dialect: testDialect, Transport: transport}, transport
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/webauthn"
+)
+
+// longest credential id that fits the passkeys table, base64url encoded
+const maxCredentialIDLength = 512
+
+type PasskeyModelInterface interface {
+	Insert(userID int, credential webauthn.Credential, name string) error
+	ListForUser(userID int) ([]Passkey, error)
+	Get(credentialID string) (Passkey, error)
+	Touch(id int, signCount uint32) error
+	Revoke(userID, id int) error
+}
+
+type Passkey struct {
+	ID           int
+	UserID       int
+	Email        string
+	CredentialID string
+	PublicKey    []byte
+	SignCount    uint32
+	Name         string
+	Created      time.Time
+	LastUsedAt   time.Time
+}
+
+// Credential returns the stored public key for verifying a sign-in
+func (p Passkey) Credential() webauthn.Credential {
+	return webauthn.Credential{ID: p.CredentialID, PublicKey: p.PublicKey, SignCount: p.SignCount}
+}
+
+type PasskeyModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+var _ PasskeyModelInterface = (*PasskeyModel)(nil)
+
+func (m *PasskeyModel) rebind(query string) string {
+	return dialect.Or(m.Dialect).Rebind(query)
+}
+
+func (m *PasskeyModel) Insert(userID int, credential webauthn.Credential, name string) error {
+	if len(credential.ID) > maxCredentialIDLength {
+		return fmt.Errorf("%w: credential id too long", webauthn.ErrUnsupported)
+	}
+	d := dialect.Or(m.Dialect)
+	stmt := `INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, name, created)
+	VALUES (?, ?, ?, ?, ?, ` + d.Now() + `)`
+	_, err := m.DB.Exec(d.Rebind(stmt), userID, credential.ID, credential.PublicKey, credential.SignCount, name)
+	if err != nil && d.IsDuplicate(err) {
+		return ErrDuplicatePasskey
+	}
+	return err
+}
+
+func (m *PasskeyModel) ListForUser(userID int) ([]Passkey, error) {
+	stmt := `SELECT id, user_id, credential_id, sign_count, name, created, last_used_at
+	FROM passkeys WHERE user_id = ? ORDER BY created`
+	rows, err := m.DB.Query(m.rebind(stmt), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var passkey Passkey
+		var lastUsedAt sql.NullTime
+		err := rows.Scan(&passkey.ID, &passkey.UserID, &passkey.CredentialID, &passkey.SignCount,
+			&passkey.Name, &passkey.Created, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		passkey.LastUsedAt = lastUsedAt.Time
+		passkeys = append(passkeys, passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+// Get returns the passkey with its owner's email
+func (m *PasskeyModel) Get(credentialID string) (Passkey, error) {
+	var passkey Passkey
+	var lastUsedAt sql.NullTime
+	stmt := `SELECT p.id, p.user_id, u.email, p.credential_id, p.public_key, p.sign_count, p.name, p.created, p.last_used_at
+	FROM passkeys p JOIN users u ON u.id = p.user_id
+	WHERE p.credential_id = ?`
+	err := m.DB.QueryRow(m.rebind(stmt), credentialID).Scan(&passkey.ID, &passkey.UserID, &passkey.Email,
+		&passkey.CredentialID, &passkey.PublicKey, &passkey.SignCount, &passkey.Name, &passkey.Created, &lastUsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Passkey{}, ErrNoRecord
+	}
+	passkey.LastUsedAt = lastUsedAt.Time
+	return passkey, err
+}
+
+// Touch records a sign-in with the authenticator's new signature counter
+func (m *PasskeyModel) Touch(id int, signCount uint32) error {
+	d := dialect.Or(m.Dialect)
+	stmt := `UPDATE passkeys SET sign_count = ?, last_used_at = ` + d.Now() + ` WHERE id = ?`
+	_, err := m.DB.Exec(d.Rebind(stmt), signCount, id)
+	return err
+}
+
+// Revoke deletes one of the user's passkeys
+func (m *PasskeyModel) Revoke(userID, id int) error {
+	stmt := `DELETE FROM passkeys WHERE id = ? AND user_id = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// RoleSet holds the names of a user's roles
+type RoleSet map[string]bool
+
+// NewRoleSet returns a set of the given role names
+func NewRoleSet(names ...string) RoleSet {
+	set := make(RoleSet, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+func (s RoleSet) Has(role string) bool {
+	return s[role]
+}
+
+// HasAny reports whether the set holds at least one of the roles
+func (s RoleSet) HasAny(roles ...string) bool {
+	for _, role := range roles {
+		if s[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the role names in alphabetical order
+func (s RoleSet) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s RoleSet) String() string {
+	return strings.Join(s.Names(), ", ")
+}
+
+// PermissionSet holds the permissions granted by a user's roles
+type PermissionSet map[string]bool
+
+func (s PermissionSet) Has(permission string) bool {
+	return s[permission]
+}
+
+type Role struct {
+	ID          int
+	Name        string
+	Permissions []string
+}
+
+type RoleModelInterface interface {
+	List() ([]Role, error)
+	Grant(role, permission string) error
+	Revoke(role, permission string) error
+}
+
+type RoleModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+var _ RoleModelInterface = (*RoleModel)(nil)
+
+func (m *RoleModel) rebind(query string) string {
+	return dialect.Or(m.Dialect).Rebind(query)
+}
+
+// List returns every role with the permissions it grants
+func (m *RoleModel) List() ([]Role, error) {
+	stmt := `SELECT r.id, r.name, p.name
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	ORDER BY r.name, p.name`
+	rows, err := m.DB.Query(m.rebind(stmt))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var id int
+		var name string
+		var permission sql.NullString
+		if err := rows.Scan(&id, &name, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].ID != id {
+			roles = append(roles, Role{ID: id, Name: name})
+		}
+		if permission.Valid {
+			roles[len(roles)-1].Permissions = append(roles[len(roles)-1].Permissions, permission.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// Grant gives every user with the role the permission, creating either if needed.
+// The role's users are signed out, since sessions keep the permissions loaded on sign-in.
+func (m *RoleModel) Grant(role, permission string) error {
+	d := dialect.Or(m.Dialect)
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	roleID, err := ensureName(tx, d, "roles", role)
+	if err != nil {
+		return err
+	}
+	permissionID, err := ensureName(tx, d, "permissions", permission)
+	if err != nil {
+		return err
+	}
+	columns := []string{"role_id", "permission_id"}
+	if _, err := tx.Exec(d.Rebind(d.Upsert("role_permissions", columns, columns, 1)), roleID, permissionID); err != nil {
+		return err
+	}
+	if err := bumpRoleSessions(tx, d, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Revoke takes the permission away from the role, signing out the role's users
+// so their sessions lose it
+func (m *RoleModel) Revoke(role, permission string) error {
+	d := dialect.Or(m.Dialect)
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt := `DELETE FROM role_permissions
+	WHERE role_id IN (SELECT id FROM roles WHERE name = ?)
+	AND permission_id IN (SELECT id FROM permissions WHERE name = ?)`
+	if _, err := tx.Exec(d.Rebind(stmt), role, permission); err != nil {
+		return err
+	}
+	if err := bumpRoleSessions(tx, d, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// bumpRoleSessions bumps the session version of every user with the role
+func bumpRoleSessions(q dbtx, d dialect.Dialect, role string) error {
+	stmt := `UPDATE users SET session_version = session_version + 1
+	WHERE id IN (SELECT ur.user_id FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE r.name = ?)`
+	_, err := q.Exec(d.Rebind(stmt), role)
+	return err
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// ensureName returns the id of the named row in roles or permissions, inserting it if missing
+func ensureName(q dbtx, d dialect.Dialect, table, name string) (int64, error) {
+	if name == "" {
+		return 0, errors.New("models: empty " + strings.TrimSuffix(table, "s") + " name")
+	}
+	if _, err := q.Exec(d.Rebind(d.Upsert(table, []string{"name"}, []string{"name"}, 1)), name); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := q.QueryRow(d.Rebind("SELECT id FROM "+table+" WHERE name = ?"), name).Scan(&id)
+	return id, err
+}
+
+// loadAccess fills in the user's roles and the permissions they grant
+func loadAccess(q dbtx, d dialect.Dialect, user *User) error {
+	user.Roles = RoleSet{}
+	user.Permissions = PermissionSet{}
+	rows, err := q.Query(d.Rebind(`SELECT r.name, p.name
+	FROM user_roles ur
+	JOIN roles r ON r.id = ur.role_id
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	WHERE ur.user_id = ?`), user.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		var permission sql.NullString
+		if err := rows.Scan(&role, &permission); err != nil {
+			return err
+		}
+		user.Roles[role] = true
+		if permission.Valid {
+			user.Permissions[permission.String] = true
+		}
+	}
+	return rows.Err()
+}
+
+// BackfillUserRoles is a migration step copying the old pipe-delimited
+// users.roles column, e.g. |user||admin|, into user_roles
+func BackfillUserRoles(ctx context.Context, tx *sql.Tx, d dialect.Dialect) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, roles FROM users")
+	if err != nil {
+		return err
+	}
+	userRoles := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var roles string
+		if err := rows.Scan(&id, &roles); err != nil {
+			rows.Close()
+			return err
+		}
+		for _, role := range strings.Split(roles, "|") {
+			if role != "" {
+				userRoles[id] = append(userRoles[id], role)
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	columns := []string{"user_id", "role_id"}
+	insert := d.Rebind(d.Upsert("user_roles", columns, columns, 1))
+	roleIDs := make(map[string]int64)
+	for userID, roles := range userRoles {
+		for _, role := range roles {
+			roleID, exists := roleIDs[role]
+			if !exists {
+				roleID, err = ensureName(tx, d, "roles", role)
+				if err != nil {
+					return err
+				}
+				roleIDs[role] = roleID
+			}
+			if _, err := tx.ExecContext(ctx, insert, userID, roleID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestoreRolesColumn is the reverse of BackfillUserRoles, writing user_roles
+// back into the pipe-delimited users.roles column
+func RestoreRolesColumn(ctx context.Context, tx *sql.Tx, d dialect.Dialect) error {
+	rows, err := tx.QueryContext(ctx, `SELECT ur.user_id, r.name
+	FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+	ORDER BY ur.user_id, r.name`)
+	if err != nil {
+		return err
+	}
+	userRoles := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			rows.Close()
+			return err
+		}
+		userRoles[id] += "|" + role + "|"
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	update := d.Rebind("UPDATE users SET roles = ? WHERE id = ?")
+	for id, roles := range userRoles {
+		if _, err := tx.ExecContext(ctx, update, roles, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+var (
+	// SessionIndexTTL is how long a user's session index is kept after their last request
+	SessionIndexTTL = 30 * 24 * time.Hour
+	// SessionTouchInterval is how often the last seen time of a session is updated
+	SessionTouchInterval = time.Minute
+)
+
+type SessionModelInterface interface {
+	List(userID int) ([]SessionInfo, error)
+	Track(c *fiber.Ctx, userID int, sessionID string)
+	Forget(sessionID string)
+	Rename(sessionID, name string) error
+	Revoke(sessionID string) error
+	RevokeAllExcept(current string) error
+}
+
+// SessionInfo describes one of a user's signed in sessions
+type SessionInfo struct {
+	// ID is the session cookie value and must not be rendered, use Handle in pages
+	ID        string
+	Handle    string `json:"-"`
+	Name      string
+	UserAgent string
+	IP        string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// SessionModel keeps an index of each user's session IDs in the Bank, next to
+// the sessions themselves in the store's storage
+type SessionModel struct {
+	Store *session.Store
+	Bank  helpers.BankInterface
+}
+
+var _ SessionModelInterface = (*SessionModel)(nil)
+
+// SessionHandle identifies a session in pages without giving away its ID
+func SessionHandle(sessionID string) string {
+	hash := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(hash[:8])
+}
+
+func sessionIndexKey(userID int) string {
+	return "sessions-user-" + strconv.Itoa(userID)
+}
+
+func sessionOwnerKey(sessionID string) string {
+	return "sessions-owner-" + sessionID
+}
+
+func (m *SessionModel) load(userID int) []SessionInfo {
+	return *helpers.BytesToSlice[SessionInfo](m.Bank.GetBytes(sessionIndexKey(userID)))
+}
+
+func (m *SessionModel) save(userID int, sessions []SessionInfo) {
+	if len(sessions) == 0 {
+		m.Bank.Delete(sessionIndexKey(userID))
+		return
+	}
+	m.Bank.SetBytes(sessionIndexKey(userID), helpers.SliceToBytes(&sessions), SessionIndexTTL)
+}
+
+func (m *SessionModel) owner(sessionID string) (int, error) {
+	userID, err := strconv.Atoi(m.Bank.GetString(sessionOwnerKey(sessionID)))
+	if err != nil {
+		return 0, ErrNoRecord
+	}
+	return userID, nil
+}
+
+// List returns the user's sessions that haven't expired, most recently seen first
+func (m *SessionModel) List(userID int) ([]SessionInfo, error) {
+	sessions := m.load(userID)
+	active := sessions[:0]
+	for _, info := range sessions {
+		data, err := m.Store.Storage.Get(info.ID)
+		if err != nil {
+			return nil, err
+		}
+		// the session expired or was destroyed
+		if data == nil {
+			m.Bank.Delete(sessionOwnerKey(info.ID))
+			continue
+		}
+		info.Handle = SessionHandle(info.ID)
+		active = append(active, info)
+	}
+	if len(active) != len(sessions) {
+		m.save(userID, active)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastSeen.After(active[j].LastSeen)
+	})
+	return active, nil
+}
+
+// Track adds the session to the user's index or updates when it was last seen
+func (m *SessionModel) Track(c *fiber.Ctx, userID int, sessionID string) {
+	touchKey := "sessions-seen-" + sessionID
+	if m.Bank.GetString(touchKey) != "" {
+		return
+	}
+	m.Bank.SetString(touchKey, "1", SessionTouchInterval)
+
+	now := time.Now().UTC()
+	sessions := m.load(userID)
+	found := false
+	for i := range sessions {
+		if sessions[i].ID == sessionID {
+			sessions[i].LastSeen = now
+			sessions[i].IP = c.IP()
+			found = true
+		}
+	}
+	if !found {
+		sessions = append(sessions, SessionInfo{
+			ID:        sessionID,
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			IP:        c.IP(),
+			Created:   now,
+			LastSeen:  now,
+		})
+	}
+	m.save(userID, sessions)
+	m.Bank.SetString(sessionOwnerKey(sessionID), strconv.Itoa(userID), SessionIndexTTL)
+}
+
+// Forget removes a session from its user's index without touching the session
+func (m *SessionModel) Forget(sessionID string) {
+	userID, err := m.owner(sessionID)
+	if err != nil {
+		return
+	}
+	m.Bank.Delete(sessionOwnerKey(sessionID))
+	m.Bank.Delete("sessions-seen-" + sessionID)
+	sessions := m.load(userID)
+	for i, info := range sessions {
+		if info.ID == sessionID {
+			m.save(userID, append(sessions[:i], sessions[i+1:]...))
+			return
+		}
+	}
+}
+
+// Rename labels a session, e.g. "Work laptop"
+func (m *SessionModel) Rename(sessionID, name string) error {
+	userID, err := m.owner(sessionID)
+	if err != nil {
+		return err
+	}
+	sessions := m.load(userID)
+	for i := range sessions {
+		if sessions[i].ID == sessionID {
+			sessions[i].Name = name
+			m.save(userID, sessions)
+			return nil
+		}
+	}
+	return ErrNoRecord
+}
+
+// Revoke deletes the session, signing out whoever holds it
+func (m *SessionModel) Revoke(sessionID string) error {
+	if _, err := m.owner(sessionID); err != nil {
+		return err
+	}
+	if err := m.Store.Delete(sessionID); err != nil {
+		return err
+	}
+	m.Forget(sessionID)
+	return nil
+}
+
+// RevokeAllExcept signs the owner of the current session out everywhere else
+func (m *SessionModel) RevokeAllExcept(current string) error {
+	userID, err := m.owner(current)
+	if err != nil {
+		return err
+	}
+	for _, info := range m.load(userID) {
+		if info.ID == current {
+			continue
+		}
+		if err := m.Revoke(info.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SessionIndexMiddleware keeps the session index up to date. When a handler
+// signs a user in it also regenerates the session ID, so an ID planted
+// before the sign-in can't be used to take over the session.
+func SessionIndexMiddleware(store *session.Store, sessions SessionModelInterface) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sess, err := store.Get(c)
+		if err != nil {
+			return c.Next()
+		}
+		before, _ := sess.Get("user").(User)
+		beforeID := sess.ID()
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		sess, err = store.Get(c)
+		if err != nil {
+			log.Errorf("session index error: %v", err)
+			return nil
+		}
+		// an admin's session stays theirs while impersonating
+		if _, impersonating := sess.Get("impersonator").(User); impersonating {
+			return nil
+		}
+		after, signedIn := sess.Get("user").(User)
+		switch {
+		case !signedIn:
+			if before.ID != 0 {
+				sessions.Forget(beforeID)
+			}
+		// signed in, or finished signing in with a two-factor code
+		case after.ID != before.ID || before.TwoFactorPending && !after.TwoFactorPending:
+			if before.ID != 0 {
+				sessions.Forget(beforeID)
+			}
+			if err := sess.Regenerate(); err != nil {
+				log.Errorf("session regenerate error: %v", err)
+				return nil
+			}
+			id := sess.ID()
+			if err := sess.Save(); err != nil {
+				log.Errorf("session save error: %v", err)
+				return nil
+			}
+			sessions.Track(c, after.ID, id)
+		default:
+			sessions.Track(c, after.ID, sess.ID())
+		}
+		return nil
+	}
+}
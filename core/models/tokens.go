@@ -0,0 +1,160 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// prefix of every API token, which makes leaked tokens easy to search for
+const apiTokenPrefix = "bt_"
+
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Scopes     []string
+	Created    time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// HasScopes reports whether the token was granted every one of the scopes
+func (t APIToken) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		found := false
+		for _, granted := range t.Scopes {
+			if granted == scope {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// MintToken creates an API token for the user and returns it, only its hash
+// is stored so it can't be shown again. A ttl of 0 never expires.
+func (m *UserModel) MintToken(userID int, name string, scopes []string, ttl time.Duration) (string, error) {
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return "", errors.New("models: invalid token scope " + scope)
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	d := dialect.Or(m.Dialect)
+	var expiresAt any
+	if ttl > 0 {
+		expiresAt = dialect.Time(d, time.Now().Add(ttl))
+	}
+	stmt := `INSERT INTO api_tokens (user_id, name, token_hash, scopes, created, expires_at)
+	VALUES (?, ?, ?, ?, ` + d.Now() + `, ?)`
+	_, err := m.DB.Exec(d.Rebind(stmt), userID, name, hashAPIToken(token), strings.Join(scopes, " "), expiresAt)
+	if err != nil {
+		return "", err
+	}
+	m.Audit.Emit("", AuditTokenMint, name, map[string]any{"user_id": userID, "scopes": scopes})
+	return token, nil
+}
+
+// RevokeToken deletes one of the user's API tokens
+func (m *UserModel) RevokeToken(userID, id int) error {
+	stmt := `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	m.Audit.Emit("", AuditTokenRevoke, strconv.Itoa(id), map[string]any{"user_id": userID})
+	return nil
+}
+
+func (m *UserModel) ListTokens(userID int) ([]APIToken, error) {
+	stmt := `SELECT id, user_id, name, scopes, created, expires_at, last_used_at
+	FROM api_tokens WHERE user_id = ? ORDER BY created`
+	rows, err := m.DB.Query(m.rebind(stmt), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var token APIToken
+		var scopes string
+		var expiresAt, lastUsedAt sql.NullTime
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.Created, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Fields(scopes)
+		token.ExpiresAt = expiresAt.Time
+		token.LastUsedAt = lastUsedAt.Time
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// TokenAuthenticate returns the owner of an unexpired API token and records its use
+func (m *UserModel) TokenAuthenticate(token string) (User, APIToken, error) {
+	var user User
+	var apiToken APIToken
+	var scopes string
+	var verifiedAt, expiresAt sql.NullTime
+	stmt := `SELECT t.id, t.name, t.scopes, t.created, t.expires_at,
+	u.id, u.name, u.email, u.session_version, u.verified_at
+	FROM api_tokens t JOIN users u ON u.id = t.user_id
+	WHERE t.token_hash = ? AND u.deleted_at IS NULL`
+	err := m.DB.QueryRow(m.rebind(stmt), hashAPIToken(token)).Scan(&apiToken.ID, &apiToken.Name, &scopes,
+		&apiToken.Created, &expiresAt, &user.ID, &user.Name, &user.Email, &user.SessionVersion, &verifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, APIToken{}, ErrInvalidToken
+	}
+	if err != nil {
+		return User{}, APIToken{}, err
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return User{}, APIToken{}, ErrInvalidToken
+	}
+	apiToken.UserID = user.ID
+	apiToken.Scopes = strings.Fields(scopes)
+	apiToken.ExpiresAt = expiresAt.Time
+	user.VerifiedAt = verifiedAt.Time
+
+	d := dialect.Or(m.Dialect)
+	if err := loadAccess(m.DB, d, &user); err != nil {
+		return User{}, APIToken{}, err
+	}
+	apiToken.LastUsedAt = time.Now().UTC()
+	touch := `UPDATE api_tokens SET last_used_at = ` + d.Now() + ` WHERE id = ?`
+	if _, err := m.DB.Exec(d.Rebind(touch), apiToken.ID); err != nil {
+		return User{}, APIToken{}, err
+	}
+	return user, apiToken, nil
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// number of recovery codes issued when two-factor authentication is enabled
+const recoveryCodeCount = 10
+
+// TwoFactorPath is where RequireRoleMiddleware sends users who still have to
+// enter a two-factor code, set when the two-factor pages are mounted
+var TwoFactorPath = "/login"
+
+// BeginTOTP stores a new secret for the user, which is only enabled once a
+// code from it is confirmed with EnableTOTP
+func (m *UserModel) BeginTOTP(email string) (string, error) {
+	enabled, err := m.HasTOTP(email)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return "", ErrTOTPEnabled
+	}
+	secret, err := helpers.NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	stmt := `UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE email = ?`
+	if _, err := m.DB.Exec(m.rebind(stmt), secret, email); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// EnableTOTP confirms enrollment with a code from the authenticator app and
+// returns the recovery codes, which are only stored hashed
+func (m *UserModel) EnableTOTP(email, code string) ([]string, error) {
+	var id int
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	stmt := "SELECT id, totp_secret, totp_enabled_at FROM users WHERE email = ?"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&id, &secret, &enabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		return nil, ErrTOTPEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTOTPNotStarted
+	}
+	step, ok := helpers.ValidateTOTP(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d := dialect.Or(m.Dialect)
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	update := `UPDATE users SET totp_enabled_at = ` + d.Now() + `, totp_last_step = ? WHERE id = ?`
+	if _, err := tx.Exec(d.Rebind(update), step, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(d.Rebind("DELETE FROM recovery_codes WHERE user_id = ?"), id); err != nil {
+		return nil, err
+	}
+	insert := d.Rebind("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)")
+	for _, recoveryCode := range codes {
+		if _, err := tx.Exec(insert, id, hashRecoveryCode(recoveryCode)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	// the code proves the user is the one enabling it
+	m.Audit.Emit(email, AuditTOTPEnable, email, nil)
+	return codes, nil
+}
+
+// DisableTOTP removes the user's secret and recovery codes
+func (m *UserModel) DisableTOTP(email string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `DELETE FROM recovery_codes WHERE user_id IN (SELECT id FROM users WHERE email = ?)`
+	if _, err := tx.Exec(m.rebind(stmt), email); err != nil {
+		return err
+	}
+	stmt = `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE email = ?`
+	if _, err := tx.Exec(m.rebind(stmt), email); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.Audit.Emit("", AuditTOTPDisable, email, nil)
+	return nil
+}
+
+// HasTOTP reports whether the user has two-factor authentication enabled
+func (m *UserModel) HasTOTP(email string) (bool, error) {
+	var enabledAt sql.NullTime
+	stmt := "SELECT totp_enabled_at FROM users WHERE email = ?"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&enabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNoRecord
+	}
+	return enabledAt.Valid, err
+}
+
+// CheckTOTP accepts a current code from the authenticator app, which can't be
+// replayed, or an unused recovery code, which is then used up
+func (m *UserModel) CheckTOTP(email, code string) error {
+	var id int
+	var secret string
+	var lastStep int64
+	stmt := "SELECT id, totp_secret, totp_last_step FROM users WHERE email = ? AND totp_enabled_at IS NOT NULL"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&id, &secret, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	d := dialect.Or(m.Dialect)
+	var result sql.Result
+	if step, ok := helpers.ValidateTOTP(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return ErrInvalidCode
+		}
+		stmt = `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+		result, err = m.DB.Exec(d.Rebind(stmt), step, id, step)
+	} else {
+		stmt = `UPDATE recovery_codes SET used_at = ` + d.Now() + `
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+		result, err = m.DB.Exec(d.Rebind(stmt), id, hashRecoveryCode(code))
+	}
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// newRecoveryCode returns a random code formatted as xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed loosely
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return helpers.GetHash(code)
+}
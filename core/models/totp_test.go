@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// newTOTPTest returns users with jane@example.com enrolled in two-factor
+// authentication, her secret and recovery codes
+func newTOTPTest(t *testing.T) (*UserModel, string, []string) {
+	t.Helper()
+	m := newTestUsers(t)
+	if err := m.Insert("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := m.BeginTOTP("jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.EnableTOTP("jane@example.com", "000000x"); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("EnableTOTP with a wrong code = %v; want ErrInvalidCode", err)
+	}
+	// the previous step, so the current code is still unused after enrolling
+	codes, err := m.EnableTOTP("jane@example.com", totpCode(t, secret, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, secret, codes
+}
+
+// totpCode returns the code offset steps from now
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := helpers.TOTPCode(secret, helpers.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPEnroll(t *testing.T) {
+	m, _, codes := newTOTPTest(t)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(codes), recoveryCodeCount)
+	}
+	if enabled, err := m.HasTOTP("jane@example.com"); err != nil || !enabled {
+		t.Fatalf("HasTOTP = %v, %v; want true", enabled, err)
+	}
+	if _, err := m.BeginTOTP("jane@example.com"); !errors.Is(err, ErrTOTPEnabled) {
+		t.Fatalf("BeginTOTP when enabled = %v; want ErrTOTPEnabled", err)
+	}
+	user, err := m.Authenticate("jane@example.com", "password")
+	if err != nil || !user.TwoFactorPending {
+		t.Fatalf("Authenticate = %+v, %v; want two-factor pending", user, err)
+	}
+
+	if err := m.DisableTOTP("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := m.HasTOTP("jane@example.com"); err != nil || enabled {
+		t.Fatalf("HasTOTP after disabling = %v, %v; want false", enabled, err)
+	}
+	if err := m.CheckTOTP("jane@example.com", codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("recovery code after disabling = %v; want ErrInvalidCode", err)
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	tests := []struct {
+		name string
+		// codes returns the codes entered in order
+		codes func(secret string, recovery []string) []string
+		want  []error
+	}{
+		{
+			name: "current code once",
+			codes: func(secret string, _ []string) []string {
+				return []string{totpCode(t, secret, 0), totpCode(t, secret, 0)}
+			},
+			want: []error{nil, ErrInvalidCode},
+		},
+		{
+			name:  "code used to enroll",
+			codes: func(secret string, _ []string) []string { return []string{totpCode(t, secret, -1)} },
+			want:  []error{ErrInvalidCode},
+		},
+		{
+			name: "older code after a newer one",
+			codes: func(secret string, _ []string) []string {
+				return []string{totpCode(t, secret, 1), totpCode(t, secret, 0)}
+			},
+			want: []error{nil, ErrInvalidCode},
+		},
+		{
+			name:  "recovery code once",
+			codes: func(_ string, recovery []string) []string { return []string{recovery[0], recovery[0], recovery[1]} },
+			want:  []error{nil, ErrInvalidCode, nil},
+		},
+		{
+			name: "recovery code typed loosely",
+			codes: func(_ string, recovery []string) []string {
+				return []string{strings.ToUpper(strings.ReplaceAll(recovery[0], "-", " "))}
+			},
+			want: []error{nil},
+		},
+		{
+			name:  "wrong code",
+			codes: func(string, []string) []string { return []string{"abcde-fghij"} },
+			want:  []error{ErrInvalidCode},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, secret, recovery := newTOTPTest(t)
+			for i, code := range tt.codes(secret, recovery) {
+				if err := m.CheckTOTP("jane@example.com", code); !errors.Is(err, tt.want[i]) {
+					t.Fatalf("code %d = %v; want %v", i+1, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
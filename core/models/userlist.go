@@ -0,0 +1,290 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// DefaultPageSize and MaxPageSize bound UserQuery.Limit
+var (
+	DefaultPageSize = 25
+	MaxPageSize     = 200
+)
+
+// columns UserQuery.Sort can order by
+var userSortColumns = map[string]string{
+	"id":      "id",
+	"name":    "name",
+	"email":   "email",
+	"created": "created",
+}
+
+// UserQuery filters, sorts and pages UserModel.List. Zero values leave a filter out.
+type UserQuery struct {
+	// Search matches part of the name or email, ignoring case
+	Search string
+	// Role keeps users with the role
+	Role string
+	// CreatedAfter and CreatedBefore keep users created in the range
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Sort is id, name, email or created, prefixed with - for descending.
+	// Ties are broken by id. The default is -created, newest first.
+	Sort string
+	// After and Before are cursors from UserPage.Next and UserPage.Prev,
+	// at most one of them is used
+	After  string
+	Before string
+	// Limit is the page size, DefaultPageSize when 0 and at most MaxPageSize
+	Limit int
+}
+
+// UserPage is a page of UserModel.List
+type UserPage struct {
+	Users []User
+	// Total counts the users matching the filters across every page
+	Total int
+	// Prev and Next are cursors for the neighbouring pages, empty when there are none
+	Prev string
+	Next string
+}
+
+// Pagination links the page to its neighbours for the pagination partial
+func (p UserPage) Pagination(c *fiber.Ctx) helpers.Pagination {
+	return helpers.Paginate(c, p.Total, p.Prev, p.Next)
+}
+
+// ParseUserQuery reads a UserQuery from the q, role, from, to, sort, after,
+// before and limit query parameters, with from and to as YYYY-MM-DD dates
+func ParseUserQuery(c *fiber.Ctx) UserQuery {
+	query := UserQuery{
+		Search: strings.TrimSpace(c.Query("q")),
+		Role:   strings.TrimSpace(c.Query("role")),
+		Sort:   c.Query("sort"),
+		After:  c.Query("after"),
+		Before: c.Query("before"),
+		Limit:  c.QueryInt("limit"),
+	}
+	if from, err := time.Parse(time.DateOnly, c.Query("from")); err == nil {
+		query.CreatedAfter = from
+	}
+	if to, err := time.Parse(time.DateOnly, c.Query("to")); err == nil {
+		// include the whole day
+		query.CreatedBefore = to.AddDate(0, 0, 1)
+	}
+	return query
+}
+
+// userCursor is the sort value and id of the row a page starts after
+type userCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeUserCursor(column string, user User) string {
+	cursor := userCursor{ID: user.ID}
+	switch column {
+	case "name":
+		cursor.Value = user.Name
+	case "email":
+		cursor.Value = user.Email
+	case "created":
+		cursor.Value = user.Created.UTC().Format(time.RFC3339Nano)
+	}
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeUserCursor returns the cursor's sort value as the type of column, with
+// created bound as the dialect stores it
+func decodeUserCursor(d dialect.Dialect, column, encoded string) (any, int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	var cursor userCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	switch column {
+	case "id":
+		return cursor.ID, cursor.ID, nil
+	case "created":
+		created, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, 0, ErrInvalidCursor
+		}
+		return dialect.Time(d, created), cursor.ID, nil
+	}
+	return cursor.Value, cursor.ID, nil
+}
+
+// List returns a page of users that aren't deleted, with their roles. Pages are
+// fetched by keyset on the sort column and id, so they stay stable while users
+// sign up and don't slow down further into the table.
+func (m *UserModel) List(ctx context.Context, query UserQuery) (UserPage, error) {
+	d := dialect.Or(m.Dialect)
+	sort, desc := strings.CutPrefix(query.Sort, "-")
+	if query.Sort == "" {
+		sort, desc = "created", true
+	}
+	column, ok := userSortColumns[sort]
+	if !ok {
+		return UserPage{}, ErrInvalidSort
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	where := []string{"deleted_at IS NULL"}
+	var args []any
+	if query.Search != "" {
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(query.Search))
+		where = append(where, `(LOWER(name) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!')`)
+		args = append(args, "%"+escaped+"%", "%"+escaped+"%")
+	}
+	if query.Role != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = users.id AND r.name = ?)`)
+		args = append(args, query.Role)
+	}
+	if !query.CreatedAfter.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, dialect.Time(d, query.CreatedAfter))
+	}
+	if !query.CreatedBefore.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, dialect.Time(d, query.CreatedBefore))
+	}
+
+	var page UserPage
+	count := `SELECT COUNT(*) FROM users WHERE ` + strings.Join(where, " AND ")
+	if err := m.DB.QueryRowContext(ctx, d.Rebind(count), args...).Scan(&page.Total); err != nil {
+		return UserPage{}, err
+	}
+
+	// walking backwards from Before reads the rows in reverse and flips them afterwards
+	backwards := query.After == "" && query.Before != ""
+	cursor := query.After
+	if backwards {
+		cursor = query.Before
+	}
+	if cursor != "" {
+		value, id, err := decodeUserCursor(d, column, cursor)
+		if err != nil {
+			return UserPage{}, err
+		}
+		op := ">"
+		if desc != backwards {
+			op = "<"
+		}
+		if column == "id" {
+			where = append(where, "id "+op+" ?")
+			args = append(args, id)
+		} else {
+			where = append(where, "("+column+" "+op+" ? OR ("+column+" = ? AND id "+op+" ?))")
+			args = append(args, value, value, id)
+		}
+	}
+	direction := "ASC"
+	if desc != backwards {
+		direction = "DESC"
+	}
+	order := column + " " + direction
+	if column != "id" {
+		order += ", id " + direction
+	}
+
+	// one extra row tells whether there is another page
+	stmt := `SELECT id, name, email, created, locked_until FROM users WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY ` + order + ` LIMIT ` + strconv.Itoa(limit+1)
+	rows, err := m.DB.QueryContext(ctx, d.Rebind(stmt), args...)
+	if err != nil {
+		return UserPage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user := User{Roles: RoleSet{}}
+		var lockedUntil sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Created, &lockedUntil); err != nil {
+			return UserPage{}, err
+		}
+		if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+			user.LockedUntil = lockedUntil.Time
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return UserPage{}, err
+	}
+	rows.Close()
+
+	more := len(page.Users) > limit
+	if more {
+		page.Users = page.Users[:limit]
+	}
+	if backwards {
+		for i, j := 0, len(page.Users)-1; i < j; i, j = i+1, j-1 {
+			page.Users[i], page.Users[j] = page.Users[j], page.Users[i]
+		}
+	}
+	if len(page.Users) > 0 {
+		first, last := page.Users[0], page.Users[len(page.Users)-1]
+		if (backwards && more) || (!backwards && cursor != "") {
+			page.Prev = encodeUserCursor(column, first)
+		}
+		if (!backwards && more) || backwards {
+			page.Next = encodeUserCursor(column, last)
+		}
+	}
+
+	if err := m.loadRoleSets(ctx, page.Users); err != nil {
+		return UserPage{}, err
+	}
+	return page, nil
+}
+
+// loadRoleSets fills in the roles of the users with one query
+func (m *UserModel) loadRoleSets(ctx context.Context, users []User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	index := make(map[int]int, len(users))
+	ids := make([]any, len(users))
+	for i, user := range users {
+		index[user.ID] = i
+		ids[i] = user.ID
+	}
+	stmt := `SELECT ur.user_id, r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+	WHERE ur.user_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+	rows, err := m.DB.QueryContext(ctx, m.rebind(stmt), ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var role string
+		if err := rows.Scan(&id, &role); err != nil {
+			return err
+		}
+		if i, exists := index[id]; exists {
+			if users[i].Roles == nil {
+				users[i].Roles = RoleSet{}
+			}
+			users[i].Roles[role] = true
+		}
+	}
+	return rows.Err()
+}
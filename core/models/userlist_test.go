@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUserListKeyset(t *testing.T) {
+	m := newTestUsers(t)
+	for i := range 5 {
+		if err := m.Insert(fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i), "password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one statement gives every user the same CURRENT_TIMESTAMP, leaving the
+	// created cursor to the id tie-break
+	if _, err := m.DB.Exec(`UPDATE users SET created = CURRENT_TIMESTAMP`); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{"created", []int{1, 2, 3, 4, 5}},
+		{"-created", []int{5, 4, 3, 2, 1}},
+		{"id", []int{1, 2, 3, 4, 5}},
+		{"-id", []int{5, 4, 3, 2, 1}},
+		{"name", []int{1, 2, 3, 4, 5}},
+		{"-email", []int{5, 4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			ctx := context.Background()
+			var got []int
+			var pages []UserPage
+			query := UserQuery{Sort: tt.sort, Limit: 2}
+			for {
+				page, err := m.List(ctx, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Total != 5 {
+					t.Fatalf("Total = %d; want 5", page.Total)
+				}
+				for _, user := range page.Users {
+					got = append(got, user.ID)
+				}
+				pages = append(pages, page)
+				if page.Next == "" {
+					break
+				}
+				if len(pages) > 5 {
+					t.Fatalf("pages don't end, read %v", got)
+				}
+				query.After = page.Next
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("walking forward read %v; want %v", got, tt.want)
+			}
+
+			// walking back from the last page reads the same pages
+			for i := len(pages) - 1; i > 0; i-- {
+				prev, err := m.List(ctx, UserQuery{Sort: tt.sort, Limit: 2, Before: pages[i].Prev})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(userIDs(prev.Users), userIDs(pages[i-1].Users)) {
+					t.Fatalf("page before %v = %v; want %v", userIDs(pages[i].Users), userIDs(prev.Users), userIDs(pages[i-1].Users))
+				}
+			}
+		})
+	}
+}
+
+func userIDs(users []User) []int {
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func TestUserListLockedUntil(t *testing.T) {
+	tests := []struct {
+		name   string
+		until  time.Time
+		locked bool
+	}{
+		{"never locked", time.Time{}, false},
+		{"locked", time.Now().Add(24 * time.Hour), true},
+		{"lock expired", time.Now().Add(-time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestUsers(t)
+			if err := m.Insert("Jane", "jane@example.com", "password"); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.until.IsZero() {
+				if err := m.Lock("jane@example.com", tt.until); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err := m.List(context.Background(), UserQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Users) != 1 {
+				t.Fatalf("listed %d users; want 1", len(page.Users))
+			}
+			got := page.Users[0].LockedUntil
+			if locked := !got.IsZero(); locked != tt.locked || (locked && got.Sub(tt.until).Abs() > time.Second) {
+				t.Fatalf("LockedUntil = %v; want locked %v until %v", got, tt.locked, tt.until)
+			}
+		})
+	}
+}
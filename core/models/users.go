@@ -1,68 +1,155 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/email"
 	"github.com/joashgobin/boiler/helpers"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserModelInterface interface {
 	Insert(name, email, password string) error
+	InsertVerified(name, email, password string) error
 	Authenticate(email, password string) (User, error)
 	EmailAuthenticate(email string) (User, error)
 	Exists(email string) (bool, error)
+	UpdatePassword(email, password string) error
+	SessionVersion(id int) (int, error)
+	SendVerification(email string) error
+	Verify(email string) error
+	IsVerified(email string) (bool, error)
+	BeginTOTP(email string) (string, error)
+	EnableTOTP(email, code string) ([]string, error)
+	DisableTOTP(email string) error
+	HasTOTP(email string) (bool, error)
+	CheckTOTP(email, code string) error
+	Lock(email string, until time.Time) error
+	Unlock(email string) error
+	LockedUntil(email string) (time.Time, error)
+	MintToken(userID int, name string, scopes []string, ttl time.Duration) (string, error)
+	RevokeToken(userID, id int) error
+	ListTokens(userID int) ([]APIToken, error)
+	TokenAuthenticate(token string) (User, APIToken, error)
+	Export(email string) ([]byte, error)
+	Delete(email string, mode DeleteMode) error
+	Invite(email, name string, roles []string, invitedBy int) error
+	GetInvite(email string) (Invite, error)
+	AcceptInvite(email, name, password string) error
+	InviteFromCSV(path string, invitedBy int, report io.Writer) error
 	AssignRole(email, role string) error
 	RemoveRole(email, role string) error
 	ParseFromCSV(path string) error
 	GetAll(limit int) []User
+	List(ctx context.Context, query UserQuery) (UserPage, error)
 }
 
 type User struct {
 	ID             int
 	Name           string
 	Email          string
-	Roles          string
 	HashedPassword []byte
 	Created        time.Time
+	SessionVersion int
+	VerifiedAt     time.Time
+
+	// Roles and Permissions are loaded on sign-in, permissions come from the user's roles
+	Roles       RoleSet
+	Permissions PermissionSet
+
+	// LockedUntil is set by List for accounts locked by an admin
+	LockedUntil time.Time
+
+	// TwoFactorPending is set by Authenticate and EmailAuthenticate for users with
+	// two-factor authentication until a code has been checked
+	TwoFactorPending bool
 }
 
+// HasRole reports whether the user has been assigned the role
+func (u User) HasRole(role string) bool {
+	return u.Roles.Has(role)
+}
+
+// Can reports whether one of the user's roles grants the permission
+func (u User) Can(permission string) bool {
+	return u.Permissions.Has(permission)
+}
+
+// Verified reports whether the user has confirmed their email
+func (u User) Verified() bool {
+	return !u.VerifiedAt.IsZero()
+}
+
+// purpose recorded against email verification magic links
+const VerifyEmailPurpose = "verify-email"
+
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect dialect.Dialect
+
+	// Mail sends a verification link to VerifyURL when a user is inserted,
+	// VerifyURL is set when the verification pages are mounted and an error
+	// is logged instead while it's empty
+	Mail      email.MailInterface
+	VerifyURL string
+	// InviteURL is where invitation links point, set when the invitation pages are mounted
+	InviteURL string
+	// Audit records role, password, lockout and other account changes when set
+	Audit helpers.AuditFunc
+}
+
+// rebind adapts a query to the model's dialect, MySQL by default
+func (m *UserModel) rebind(query string) string {
+	return dialect.Or(m.Dialect).Rebind(query)
 }
 
+// GetAll returns up to limit users, logging any error. List adds search,
+// filters, sorting and pages.
 func (m *UserModel) GetAll(limit int) []User {
 	var users []User
 	query := `
-	SELECT id,name,email,roles,created FROM users
+	SELECT id,name,email,created FROM users
+	WHERE deleted_at IS NULL
 	LIMIT ?
 	`
-	rows, err := m.DB.Query(query, limit)
+	rows, err := m.DB.Query(m.rebind(query), limit)
 	if err != nil {
 		log.Errorf("get all users exec error: %v", err)
 		return users
 	}
+	defer rows.Close()
 	for rows.Next() {
-		var user User
-		err = rows.Scan(&user.ID, &user.Name, &user.Email, &user.Roles, &user.Created)
+		user := User{Roles: RoleSet{}}
+		err = rows.Scan(&user.ID, &user.Name, &user.Email, &user.Created)
 		if err != nil {
 			log.Errorf("get all users scan error: %v", err)
 		}
 		users = append(users, user)
 	}
+	if len(users) == 0 {
+		return users
+	}
+
+	if err := m.loadRoleSets(context.Background(), users); err != nil {
+		log.Errorf("get all users roles error: %v", err)
+	}
 	return users
 }
 
+// ParseFromCSV imports name,email,roles rows without a usable password or any
+// notification, InviteFromCSV lets the users choose their own password instead
 func (m *UserModel) ParseFromCSV(path string) error {
 	file, err := os.Open(path)
 	if err != nil {
@@ -86,6 +173,7 @@ func (m *UserModel) ParseFromCSV(path string) error {
 	return nil
 }
 
+// Deprecated: the users table is created by the core migrations.
 func InitUsers(db *sql.DB, appName string) {
 	helpers.RunMigration(strings.ReplaceAll(`
 	USE <appName>;
@@ -104,115 +192,198 @@ CREATE TABLE IF NOT EXISTS users (
 }
 
 func (m *UserModel) Insert(name, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	d := dialect.Or(m.Dialect)
+	tx, err := m.DB.Begin()
 	if err != nil {
 		return err
 	}
-	stmt := `INSERT INTO users (name, email, roles, hashed_password, created)
-    VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
-	_, err = m.DB.Exec(stmt, name, email, "|user|", string(hashedPassword))
-	if err != nil {
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
-			}
-		}
+	defer tx.Rollback()
+	if err := insertUser(tx, d, name, email, password, false); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	if m.Mail != nil {
+		if err := m.SendVerification(email); err != nil {
+			log.Errorf("verification email to %s not sent: %v", email, err)
+		}
+	}
 	return nil
 }
 
-func (m *UserModel) AssignRole(email, role string) error {
-	selectStmt := `SELECT id, roles FROM users WHERE email = ?`
-
-	var roles string
-	var id int
-
-	err := m.DB.QueryRow(selectStmt, email).Scan(&id, &roles)
-
+// InsertVerified adds a user whose email has already been confirmed, e.g. by an
+// identity provider, so no verification email is sent
+func (m *UserModel) InsertVerified(name, email, password string) error {
+	d := dialect.Or(m.Dialect)
+	tx, err := m.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+	if err := insertUser(tx, d, name, email, password, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	newRoles := roles
-	if !strings.Contains(roles, "|"+role+"|") {
-		newRoles += "|" + role + "|"
-
-		log.Infof("setting new roles to: %s", newRoles)
-
-		updateStmt := `UPDATE users
-		SET roles = ?
-		WHERE email = ?
-		`
-		result, err := m.DB.Exec(updateStmt, newRoles, email)
-		if err != nil {
-			return err
-		}
-		_, err = result.RowsAffected()
-		if err != nil {
-			return err
+// insertUser adds the user with the user role every user starts with,
+// marking the email as confirmed when verified is set
+func insertUser(q dbtx, d dialect.Dialect, name, email, password string, verified bool) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	verifiedAt := "NULL"
+	if verified {
+		verifiedAt = d.Now()
+	}
+	stmt := `INSERT INTO users (name, email, hashed_password, created, verified_at)
+    VALUES(?, ?, ?, ` + d.Now() + `, ` + verifiedAt + `)`
+	_, err = q.Exec(d.Rebind(stmt), name, email, string(hashedPassword))
+	if err != nil {
+		// email is the only unique column besides the id
+		if d.IsDuplicate(err) {
+			return ErrDuplicateEmail
 		}
-		log.Infof("updated roles to: %s", newRoles)
+		return err
 	}
+	return assignRole(q, d, email, "user")
+}
 
+// SendVerification emails a link that confirms the user owns the address
+func (m *UserModel) SendVerification(email string) error {
+	if m.Mail == nil {
+		return ErrNoMail
+	}
+	if m.VerifyURL == "" {
+		return ErrNoVerifyURL
+	}
+	link := m.Mail.GetMagicLink(email, VerifyEmailPurpose, m.VerifyURL)
+	m.Mail.Send(email, "", "Verify your email",
+		"Use the link below to confirm your email address.<br><a href=\"%s\">%s</a>", link, link)
 	return nil
 }
 
-func (m *UserModel) RemoveRole(email, role string) error {
-	selectStmt := `SELECT id, roles FROM users WHERE email = ?`
-
-	var roles string
-	var id int
+// Verify marks the user's email as confirmed
+func (m *UserModel) Verify(email string) error {
+	d := dialect.Or(m.Dialect)
+	stmt := `UPDATE users SET verified_at = ` + d.Now() + `
+	WHERE email = ? AND verified_at IS NULL`
+	_, err := m.DB.Exec(d.Rebind(stmt), email)
+	return err
+}
 
-	err := m.DB.QueryRow(selectStmt, id).Scan(&id, &roles)
+func (m *UserModel) IsVerified(email string) (bool, error) {
+	var verifiedAt sql.NullTime
+	stmt := "SELECT verified_at FROM users WHERE email = ?"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&verifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNoRecord
+	}
+	return verifiedAt.Valid, err
+}
 
+// AssignRole gives the user the role and bumps the session version, since
+// sessions keep the roles loaded on sign-in and would otherwise go without it
+func (m *UserModel) AssignRole(email, role string) error {
+	tx, err := m.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+	err = assignRole(tx, dialect.Or(m.Dialect), email, role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoRecord
+	}
+	if err != nil {
+		return err
+	}
+	stmt := `UPDATE users SET session_version = session_version + 1 WHERE email = ?`
+	if _, err := tx.Exec(m.rebind(stmt), email); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Infof("assigned role %s to %s", role, email)
+	m.Audit.Emit("", AuditRoleAssign, email, map[string]any{"role": role})
+	return nil
+}
 
-	log.Infof("%s has roles: %s", email, roles)
-
-	newRoles := roles
-	if strings.Contains(roles, "|"+role+"|") {
-		newRoles = strings.ReplaceAll(newRoles, "|"+role+"|", "")
-
-		log.Infof("setting new roles to: %s", newRoles)
-
-		updateStmt := `UPDATE users
-		SET roles = ?
-		WHERE email = ?
-		`
-		result, err := m.DB.Exec(updateStmt, newRoles, email)
-		if err != nil {
-			return err
-		}
-		_, err = result.RowsAffected()
-		if err != nil {
-			return err
-		}
-		log.Infof("updated roles to: %s", newRoles)
+// assignRole gives the user the role, creating the role if it doesn't exist yet
+func assignRole(q dbtx, d dialect.Dialect, email, role string) error {
+	var userID int64
+	err := q.QueryRow(d.Rebind("SELECT id FROM users WHERE email = ?"), email).Scan(&userID)
+	if err != nil {
+		return err
 	}
+	roleID, err := ensureName(q, d, "roles", role)
+	if err != nil {
+		return err
+	}
+	columns := []string{"user_id", "role_id"}
+	_, err = q.Exec(d.Rebind(d.Upsert("user_roles", columns, columns, 1)), userID, roleID)
+	return err
+}
 
+// RemoveRole takes the role away and bumps the session version, since
+// sessions keep the roles loaded on sign-in and would otherwise keep the role
+func (m *UserModel) RemoveRole(email, role string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt := `DELETE FROM user_roles
+	WHERE user_id IN (SELECT id FROM users WHERE email = ?)
+	AND role_id IN (SELECT id FROM roles WHERE name = ?)`
+	if _, err := tx.Exec(m.rebind(stmt), email, role); err != nil {
+		return err
+	}
+	stmt = `UPDATE users SET session_version = session_version + 1 WHERE email = ?`
+	if _, err := tx.Exec(m.rebind(stmt), email); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Infof("removed role %s from %s", role, email)
+	m.Audit.Emit("", AuditRoleRemove, email, map[string]any{"role": role})
 	return nil
 }
 
+// EmailAuthenticate signs in a user whose email was confirmed some other way than
+// a password, e.g. a passkey or an identity provider, unless they are locked out
 func (m *UserModel) EmailAuthenticate(email string) (User, error) {
 	var user User
-	stmt := "SELECT id, name, roles FROM users WHERE email = ?"
-	err := m.DB.QueryRow(stmt, email).Scan(&user.ID, &user.Name, &user.Roles)
+	var verifiedAt, totpEnabledAt, lockedUntil sql.NullTime
+	stmt := "SELECT id, name, session_version, verified_at, totp_enabled_at, locked_until FROM users WHERE email = ? AND deleted_at IS NULL"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&user.ID, &user.Name, &user.SessionVersion, &verifiedAt, &totpEnabledAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNoRecord
+	}
 	if err != nil {
 		return User{}, err
 	}
+	if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+		return User{}, ErrAccountLocked
+	}
+	if err := loadAccess(m.DB, dialect.Or(m.Dialect), &user); err != nil {
+		return User{}, err
+	}
 	user.Email = email
+	user.VerifiedAt = verifiedAt.Time
+	user.TwoFactorPending = totpEnabledAt.Valid
 	log.Info("user sign-in: ", user.Email)
 	return user, nil
 }
 
 func (m *UserModel) Authenticate(email, password string) (User, error) {
 	var user User
-	stmt := "SELECT id, name, roles, hashed_password FROM users WHERE email = ?"
-	err := m.DB.QueryRow(stmt, email).Scan(&user.ID, &user.Name, &user.Roles, &user.HashedPassword)
+	var verifiedAt, totpEnabledAt, lockedUntil sql.NullTime
+	stmt := "SELECT id, name, hashed_password, session_version, verified_at, totp_enabled_at, locked_until FROM users WHERE email = ? AND deleted_at IS NULL"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&user.ID, &user.Name, &user.HashedPassword, &user.SessionVersion, &verifiedAt, &totpEnabledAt, &lockedUntil)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return user, ErrInvalidCredentials
@@ -220,6 +391,9 @@ func (m *UserModel) Authenticate(email, password string) (User, error) {
 			return user, err
 		}
 	}
+	if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+		return User{}, ErrAccountLocked
+	}
 	err = bcrypt.CompareHashAndPassword(user.HashedPassword, []byte(password))
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
@@ -228,43 +402,205 @@ func (m *UserModel) Authenticate(email, password string) (User, error) {
 			return user, err
 		}
 	}
+	if err := loadAccess(m.DB, dialect.Or(m.Dialect), &user); err != nil {
+		return user, err
+	}
 	user.Email = email
+	user.VerifiedAt = verifiedAt.Time
+	user.TwoFactorPending = totpEnabledAt.Valid
 	return user, nil
 }
 
 func (m *UserModel) Exists(email string) (bool, error) {
 	var exists bool
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE email = ?)"
-	err := m.DB.QueryRow(stmt, email).Scan(&exists)
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&exists)
 	return exists, err
 }
 
-func RequireRoleMiddleware(store *session.Store, flash helpers.FlashInterface, role string) fiber.Handler {
+// UpdatePassword sets a new password and bumps the session version,
+// which signs the user out of every existing session
+func (m *UserModel) UpdatePassword(email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	stmt := `UPDATE users
+	SET hashed_password = ?, session_version = session_version + 1
+	WHERE email = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), string(hashedPassword), email)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	m.Audit.Emit("", AuditPasswordChange, email, nil)
+	return nil
+}
+
+// Lock refuses sign-ins for the user until the given time and bumps the
+// session version, which signs them out of every existing session
+func (m *UserModel) Lock(email string, until time.Time) error {
+	stmt := "UPDATE users SET locked_until = ?, session_version = session_version + 1 WHERE email = ?"
+	_, err := m.DB.Exec(m.rebind(stmt), dialect.Time(m.Dialect, until), email)
+	if err != nil {
+		return err
+	}
+	m.Audit.Emit("", AuditAccountLock, email, map[string]any{"until": until.UTC()})
+	return nil
+}
+
+func (m *UserModel) Unlock(email string) error {
+	stmt := "UPDATE users SET locked_until = NULL WHERE email = ?"
+	_, err := m.DB.Exec(m.rebind(stmt), email)
+	if err != nil {
+		return err
+	}
+	m.Audit.Emit("", AuditAccountUnlock, email, nil)
+	return nil
+}
+
+// LockedUntil returns when the user's lockout ends, the zero time if they aren't locked out
+func (m *UserModel) LockedUntil(email string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	stmt := "SELECT locked_until FROM users WHERE email = ?"
+	err := m.DB.QueryRow(m.rebind(stmt), email).Scan(&lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNoRecord
+	}
+	if err != nil || !lockedUntil.Valid || time.Now().After(lockedUntil.Time) {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// SessionVersion returns the user's current session version
+func (m *UserModel) SessionVersion(id int) (int, error) {
+	var version int
+	stmt := "SELECT session_version FROM users WHERE id = ?"
+	err := m.DB.QueryRow(m.rebind(stmt), id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoRecord
+	}
+	return version, err
+}
+
+// SessionVersionMiddleware signs out sessions created before the user's
+// password was last changed
+func SessionVersionMiddleware(store *session.Store, users UserModelInterface) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		sess, err := store.Get(c)
 		if err != nil {
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 		user, ok := sess.Get("user").(User)
+		if !ok {
+			return c.Next()
+		}
+		version, err := users.SessionVersion(user.ID)
+		if err != nil && !errors.Is(err, ErrNoRecord) {
+			log.Errorf("session version error: %v", err)
+			return c.Next()
+		}
+		// the user was deleted or changed their password since signing in
+		if errors.Is(err, ErrNoRecord) || version != user.SessionVersion {
+			if err := sess.Destroy(); err != nil {
+				log.Errorf("session destroy error: %v", err)
+			}
+		}
+		return c.Next()
+	}
+}
+
+// signedInUser returns the session user, or redirects and returns false
+// when nobody has finished signing in
+func signedInUser(c *fiber.Ctx, store *session.Store, flash helpers.FlashInterface) (User, *session.Session, bool, error) {
+	sess, err := store.Get(c)
+	if err != nil {
+		return User{}, nil, false, c.SendStatus(fiber.StatusInternalServerError)
+	}
+	user, ok := sess.Get("user").(User)
+
+	// redirect if user value is not set in session
+	if !ok {
+		flash.Push(c, "You need to be logged in")
+		return User{}, sess, false, c.Redirect("/login")
+	}
+
+	// redirect if the two-factor code has not been entered yet
+	if user.TwoFactorPending {
+		flash.Push(c, "Enter your authentication code to finish logging in")
+		return User{}, sess, false, c.Redirect(TwoFactorPath)
+	}
+	return user, sess, true, nil
+}
 
-		// redirect if user value is not set in session
+func RequireRoleMiddleware(store *session.Store, flash helpers.FlashInterface, role string) fiber.Handler {
+	return RequireAnyRole(store, flash, role)
+}
+
+// RequireAnyRole only lets through users with at least one of the roles
+func RequireAnyRole(store *session.Store, flash helpers.FlashInterface, roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, _, ok, err := signedInUser(c, store, flash)
 		if !ok {
-			flash.Push(c, "You need to be logged in")
-			return c.Redirect("/login")
+			return err
 		}
 
-		// redirect if user roles are not defined in session
-		roles := user.Roles
-		if roles == "" {
-			flash.Push(c, "You need to be logged in")
-			return c.Redirect("/login")
+		// redirect if user session does not specify a required role
+		if !user.Roles.HasAny(roles...) {
+			flash.Push(c, fmt.Sprintf("You need to be logged in as %s", strings.Join(roles, " or ")))
+			return c.Redirect("/")
 		}
+		return c.Next()
+	}
+}
 
-		// redirect if user session does not specify the required role
-		if !strings.Contains(roles, "|"+role+"|") {
-			flash.Push(c, fmt.Sprintf("You need to be logged in as %s", role))
+// RequirePermission only lets through users whose roles grant the permission
+func RequirePermission(store *session.Store, flash helpers.FlashInterface, permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, _, ok, err := signedInUser(c, store, flash)
+		if !ok {
+			return err
+		}
+		if !user.Can(permission) {
+			flash.Push(c, "You don't have permission to do that")
 			return c.Redirect("/")
 		}
 		return c.Next()
 	}
 }
+
+// RequireVerifiedMiddleware only lets through users who have confirmed their email
+func RequireVerifiedMiddleware(store *session.Store, flash helpers.FlashInterface, users UserModelInterface) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, sess, ok, err := signedInUser(c, store, flash)
+		if !ok {
+			return err
+		}
+		if user.Verified() {
+			return c.Next()
+		}
+
+		// the email may have been verified since the session started
+		verified, err := users.IsVerified(user.Email)
+		if err != nil {
+			log.Errorf("verification lookup error: %v", err)
+		}
+		if !verified {
+			flash.Push(c, "You need to verify your email first")
+			return c.Redirect("/")
+		}
+		user.VerifiedAt = time.Now().UTC()
+		sess.Set("user", user)
+		if err := sess.Save(); err != nil {
+			log.Errorf("session save error: %v", err)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joashgobin/boiler/email"
+)
+
+func TestInsertSendsVerification(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifyURL string
+		want      int
+	}{
+		{"verification pages mounted", "http://localhost:8080/verify/", 1},
+		{"verification pages not mounted", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestUsers(t)
+			mail, _ := newTestMail(m.DB)
+			m.Mail, m.VerifyURL = mail, tt.verifyURL
+			if err := m.Insert("Jane", "jane@example.com", "password"); err != nil {
+				t.Fatal(err)
+			}
+			queued, err := mail.ListOutbox(email.OutboxPending, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(queued) != tt.want {
+				t.Fatalf("queued %d emails; want %d", len(queued), tt.want)
+			}
+			if err := m.SendVerification("jane@example.com"); tt.verifyURL == "" && err != ErrNoVerifyURL {
+				t.Fatalf("SendVerification without a verify url = %v; want ErrNoVerifyURL", err)
+			}
+		})
+	}
+}
+
+func TestInsertVerified(t *testing.T) {
+	m := newTestUsers(t)
+	mail, _ := newTestMail(m.DB)
+	m.Mail, m.VerifyURL = mail, "http://localhost:8080/verify/"
+	if err := m.InsertVerified("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := m.IsVerified("jane@example.com"); err != nil || !verified {
+		t.Fatalf("IsVerified = %v, %v; want true", verified, err)
+	}
+	queued, err := mail.ListOutbox(email.OutboxPending, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Fatalf("queued %d emails; want none", len(queued))
+	}
+}
+
+func TestEmailAuthenticateDeleted(t *testing.T) {
+	m := newTestUsers(t)
+	if err := m.Insert("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete("jane@example.com", DeleteSoft); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.EmailAuthenticate("jane@example.com"); !errors.Is(err, ErrNoRecord) {
+		t.Fatalf("EmailAuthenticate of a deleted user = %v; want ErrNoRecord", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	m := newTestUsers(t)
+	if err := m.Insert("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	before, err := m.EmailAuthenticate("jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Lock("jane@example.com", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := m.SessionVersion(before.ID); err != nil || version == before.SessionVersion {
+		t.Fatalf("session version after Lock = %d, %v; want other than %d", version, err, before.SessionVersion)
+	}
+	if _, err := m.Authenticate("jane@example.com", "password"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("Authenticate when locked = %v; want ErrAccountLocked", err)
+	}
+	if _, err := m.EmailAuthenticate("jane@example.com"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("EmailAuthenticate when locked = %v; want ErrAccountLocked", err)
+	}
+
+	if err := m.Unlock("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.EmailAuthenticate("jane@example.com"); err != nil {
+		t.Fatalf("EmailAuthenticate after Unlock = %v", err)
+	}
+}
+
+func TestRoleChangesSignOut(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *UserModel, roles *RoleModel) error
+	}{
+		{"assign a role", func(m *UserModel, _ *RoleModel) error {
+			return m.AssignRole("jane@example.com", "admin")
+		}},
+		{"remove a role", func(m *UserModel, _ *RoleModel) error {
+			return m.RemoveRole("jane@example.com", "editor")
+		}},
+		{"grant a permission", func(_ *UserModel, roles *RoleModel) error {
+			return roles.Grant("editor", "posts.delete")
+		}},
+		{"revoke a permission", func(_ *UserModel, roles *RoleModel) error {
+			return roles.Revoke("editor", "posts.publish")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestUsers(t)
+			roles := &RoleModel{DB: m.DB, Dialect: testDialect}
+			for _, name := range []string{"Jane", "John"} {
+				if err := m.Insert(name, strings.ToLower(name)+"@example.com", "password"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := m.AssignRole("jane@example.com", "editor"); err != nil {
+				t.Fatal(err)
+			}
+			if err := roles.Grant("editor", "posts.publish"); err != nil {
+				t.Fatal(err)
+			}
+			jane, err := m.Authenticate("jane@example.com", "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			john, err := m.Authenticate("john@example.com", "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.change(m, roles); err != nil {
+				t.Fatal(err)
+			}
+			// jane's sessions end and john, who doesn't have the role, stays signed in
+			for _, user := range []User{jane, john} {
+				version, err := m.SessionVersion(user.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := version != user.SessionVersion, user.ID == jane.ID; got != want {
+					t.Errorf("%s signed out = %v; want %v", user.Email, got, want)
+				}
+			}
+		})
+	}
+}
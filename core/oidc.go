@@ -0,0 +1,229 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+	"github.com/joashgobin/boiler/oidc"
+)
+
+// SignInLink is a "Sign in with ..." button for the oidc-buttons partial
+type SignInLink struct {
+	Name  string
+	Label string
+	URL   string
+}
+
+// MountOIDC adds sign in with the OpenID Connect providers named in OIDC_PROVIDERS
+// in config.env, e.g. "google microsoft". Each provider is configured with
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and optionally OIDC_<NAME>_CLIENT_SECRET,
+// OIDC_<NAME>_LABEL and OIDC_<NAME>_SCOPES. For the prefix "/auth", /auth/google
+// starts signing in, /auth/google/callback is the redirect URL to register with
+// the provider and /auth/google/unlink removes the link to the signed in user.
+func (base *Base) MountOIDC(router fiber.Router, prefix string) {
+	for _, name := range strings.Fields(strings.ReplaceAll(helpers.Getenv("OIDC_PROVIDERS"), ",", " ")) {
+		name = strings.ToLower(name)
+		key := "OIDC_" + strings.ToUpper(name) + "_"
+		base.AddOIDCProvider(prefix, &oidc.Provider{
+			Name:         name,
+			Label:        helpers.GetenvDefault(key+"LABEL", name),
+			Issuer:       helpers.Getenv(key + "ISSUER"),
+			ClientID:     helpers.Getenv(key + "CLIENT_ID"),
+			ClientSecret: helpers.GetenvDefault(key+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(helpers.GetenvDefault(key+"SCOPES", "")),
+		})
+	}
+
+	router.Get(prefix+"/:provider", func(c *fiber.Ctx) error {
+		provider, ok := base.oidcProviders[c.Params("provider")]
+		if !ok {
+			return fiber.ErrNotFound
+		}
+		flow, err := oidc.NewFlow()
+		if err != nil {
+			return err
+		}
+		authURL, err := provider.AuthCodeURL(c.Context(), flow)
+		if err != nil {
+			log.Errorf("oidc %s error: %v", provider.Name, err)
+			return base.Flash.Redirect(c, "/login", "Sign in with %s isn't available right now", provider.Label)
+		}
+		err = base.Flash.Set(c, "oidcFlow", map[string]string{
+			"provider": provider.Name,
+			"state":    flow.State,
+			"nonce":    flow.Nonce,
+			"verifier": flow.Verifier,
+		})
+		if err != nil {
+			return err
+		}
+		return c.Redirect(authURL)
+	})
+
+	router.Get(prefix+"/:provider/callback", func(c *fiber.Ctx) error {
+		provider, ok := base.oidcProviders[c.Params("provider")]
+		if !ok {
+			return fiber.ErrNotFound
+		}
+		saved, _ := base.Flash.Get(c, "oidcFlow").(map[string]string)
+		if err := base.Flash.Set(c, "oidcFlow", map[string]string{}); err != nil {
+			return err
+		}
+		if c.Query("error") != "" {
+			return base.Flash.Redirect(c, "/login", "Sign in with %s was cancelled", provider.Label)
+		}
+		if saved["provider"] != provider.Name || saved["state"] == "" ||
+			subtle.ConstantTimeCompare([]byte(saved["state"]), []byte(c.Query("state"))) != 1 {
+			return base.Flash.Redirect(c, "/login", "That sign in link has expired, please try again")
+		}
+		flow := oidc.Flow{State: saved["state"], Nonce: saved["nonce"], Verifier: saved["verifier"]}
+		claims, err := provider.Exchange(c.Context(), c.Query("code"), flow)
+		if err != nil {
+			log.Errorf("oidc %s error: %v", provider.Name, err)
+			return base.Flash.Redirect(c, "/login", "Sign in with %s failed, please try again", provider.Label)
+		}
+		return base.oidcSignIn(c, provider, claims)
+	})
+
+	router.Post(prefix+"/:provider/unlink", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		provider, ok := base.oidcProviders[c.Params("provider")]
+		if !ok {
+			return fiber.ErrNotFound
+		}
+		err := base.Identities.Delete(user.ID, provider.Name)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+		log.Infof("%s unlinked from %s", provider.Name, user.Email)
+		return base.Flash.Redirect(c, "/", "Your %s account has been unlinked", provider.Label)
+	})
+}
+
+// AddOIDCProvider adds a provider configured in code, e.g. a local stub server
+// during tests, with its redirect URL under prefix
+func (base *Base) AddOIDCProvider(prefix string, provider *oidc.Provider) {
+	if base.oidcProviders == nil {
+		base.oidcProviders = make(map[string]*oidc.Provider)
+	}
+	if provider.RedirectURL == "" {
+		provider.RedirectURL = base.URL() + prefix + "/" + provider.Name + "/callback"
+	}
+	base.oidcProviders[provider.Name] = provider
+	base.signInLinks = append(base.signInLinks, SignInLink{
+		Name:  provider.Name,
+		Label: provider.Label,
+		URL:   prefix + "/" + provider.Name,
+	})
+}
+
+// SignInLinks returns a link for each mounted provider, to pass to the oidc-buttons partial
+func (base *Base) SignInLinks() []SignInLink {
+	return base.signInLinks
+}
+
+// oidcSignIn finds or creates the user for a provider identity and signs them in.
+// An identity is linked to an existing account when that user is signed in, or
+// when the provider has verified that the user owns the account's email.
+func (base *Base) oidcSignIn(c *fiber.Ctx, provider *oidc.Provider, claims oidc.Claims) error {
+	current := helpers.GetUser[models.User](c, base.Flash)
+	signedIn := current.Email != "" && !current.TwoFactorPending
+
+	identity, err := base.Identities.Get(provider.Name, claims.Subject)
+	switch {
+	case err == nil:
+		if signedIn && current.ID != identity.UserID {
+			return base.Flash.Redirect(c, "/", "That %s account is linked to another user", provider.Label)
+		}
+		user, err := base.Users.EmailAuthenticate(identity.UserEmail)
+		if err != nil {
+			return base.oidcSignInFailed(c, provider, err)
+		}
+		return base.signIn(c, user)
+	case !errors.Is(err, models.ErrNoRecord):
+		return err
+	}
+
+	// link the identity to the signed in user
+	if signedIn {
+		if err := base.Identities.Insert(current.ID, provider.Name, claims.Subject, claims.Email); err != nil {
+			return err
+		}
+		log.Infof("%s linked to %s", provider.Name, current.Email)
+		return base.Flash.Redirect(c, "/", "Your %s account has been linked", provider.Label)
+	}
+
+	if claims.Email == "" {
+		return base.Flash.Redirect(c, "/login", "%s didn't share an email address", provider.Label)
+	}
+	exists, err := base.Users.Exists(claims.Email)
+	if err != nil {
+		return err
+	}
+	if exists && !claims.EmailVerified {
+		return base.Flash.Redirect(c, "/login",
+			"An account with this email already exists, log in to link your %s account", provider.Label)
+	}
+	if !exists {
+		if err := base.insertOIDCUser(claims); err != nil {
+			return err
+		}
+	}
+
+	user, err := base.Users.EmailAuthenticate(claims.Email)
+	if err != nil {
+		return base.oidcSignInFailed(c, provider, err)
+	}
+	if err := base.Identities.Insert(user.ID, provider.Name, claims.Subject, claims.Email); err != nil {
+		return err
+	}
+	log.Infof("%s linked to %s", provider.Name, claims.Email)
+	return base.signIn(c, user)
+}
+
+// insertOIDCUser creates an account for a provider identity, with a random
+// password the user can replace through a password reset
+func (base *Base) insertOIDCUser(claims oidc.Claims) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if claims.EmailVerified {
+		return base.Users.InsertVerified(name, claims.Email, hex.EncodeToString(b))
+	}
+	return base.Users.Insert(name, claims.Email, hex.EncodeToString(b))
+}
+
+// oidcSignInFailed sends the user back to /login when their account can't be
+// signed in to, because it was deleted or is locked
+func (base *Base) oidcSignInFailed(c *fiber.Ctx, provider *oidc.Provider, err error) error {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		return base.Flash.Redirect(c, "/login", "Sign in with %s failed, the account no longer exists", provider.Label)
+	case errors.Is(err, models.ErrAccountLocked):
+		return base.Flash.Redirect(c, "/login", "Sign in with %s failed, the account is locked, please try again later", provider.Label)
+	}
+	return err
+}
+
+// signIn puts the user in the session like the other sign in handlers
+func (base *Base) signIn(c *fiber.Ctx, user models.User) error {
+	if err := base.Flash.Set(c, "user", user); err != nil {
+		return err
+	}
+	if user.TwoFactorPending {
+		return c.Redirect(models.TwoFactorPath)
+	}
+	return c.Redirect("/")
+}
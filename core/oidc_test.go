@@ -0,0 +1,147 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+	"github.com/joashgobin/boiler/oidc/oidctest"
+)
+
+func TestOIDCSignIn(t *testing.T) {
+	tests := []struct {
+		name string
+		stub func(s *oidctest.Server)
+		// state replaces the state the provider sends back
+		state string
+		// stolen exchanges the code of a sign in started by another browser
+		stolen   bool
+		want     string
+		wantUser string
+	}{
+		{name: "signs up", want: "/", wantUser: "jane@example.com"},
+		{name: "wrong state", state: "guessed", want: "/login?show=retained"},
+		{name: "code from another browser", stolen: true, want: "/login?show=retained"},
+		{
+			name: "nonce mismatch",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["nonce"] = "replayed" }
+			},
+			want: "/login?show=retained",
+		},
+		{
+			name: "expired token",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }
+			},
+			want: "/login?show=retained",
+		},
+		{name: "forged token", stub: func(s *oidctest.Server) { s.Forge = true }, want: "/login?show=retained"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, base, _ := newTestApp(t)
+			s := oidctest.NewServer(t)
+			s.Claims["email"] = "jane@example.com"
+			s.Claims["email_verified"] = true
+			base.AddOIDCProvider("/auth", s.Provider("stub", ""))
+			base.MountOIDC(app, "/auth")
+			app.Get("/whoami", func(c *fiber.Ctx) error {
+				return c.SendString(helpers.GetUser[models.User](c, base.Flash).Email)
+			})
+			if tt.stub != nil {
+				tt.stub(s)
+			}
+
+			tc := newTestClient(app)
+			resp := tc.do(t, fiber.MethodGet, "/auth/stub", nil)
+			if resp.StatusCode != fiber.StatusFound {
+				t.Fatalf("starting to sign in returned %d", resp.StatusCode)
+			}
+			callback := s.Authorize(t, resp.Header.Get("Location"))
+			query := callback.Query()
+			if tt.stolen {
+				other := newTestClient(app).do(t, fiber.MethodGet, "/auth/stub", nil)
+				query.Set("code", s.Authorize(t, other.Header.Get("Location")).Query().Get("code"))
+			}
+			if tt.state != "" {
+				query.Set("state", tt.state)
+			}
+			callback.RawQuery = query.Encode()
+
+			resp = tc.do(t, fiber.MethodGet, callback.RequestURI(), nil)
+			if got := resp.Header.Get("Location"); got != tt.want {
+				t.Fatalf("callback redirected to %q; want %q", got, tt.want)
+			}
+			if _, got := tc.text(t, fiber.MethodGet, "/whoami", nil); got != tt.wantUser {
+				t.Fatalf("signed in as %q; want %q", got, tt.wantUser)
+			}
+			exists, err := base.Users.Exists("jane@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != (tt.wantUser != "") {
+				t.Fatalf("user exists = %v", exists)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackOnce(t *testing.T) {
+	app, base, _ := newTestApp(t)
+	s := oidctest.NewServer(t)
+	s.Claims["email"] = "jane@example.com"
+	base.AddOIDCProvider("/auth", s.Provider("stub", ""))
+	base.MountOIDC(app, "/auth")
+
+	tc := newTestClient(app)
+	resp := tc.do(t, fiber.MethodGet, "/auth/stub", nil)
+	callback := s.Authorize(t, resp.Header.Get("Location"))
+	if got := tc.do(t, fiber.MethodGet, callback.RequestURI(), nil).Header.Get("Location"); got != "/" {
+		t.Fatalf("callback redirected to %q; want /", got)
+	}
+	// the flow is spent, so replaying the callback is refused before the provider sees it
+	if got := tc.do(t, fiber.MethodGet, callback.RequestURI(), nil).Header.Get("Location"); got != "/login?show=retained" {
+		t.Fatalf("replayed callback redirected to %q; want /login?show=retained", got)
+	}
+}
+
+func TestOIDCSignInRefused(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(users models.UserModelInterface) error
+	}{
+		{"deleted account", func(users models.UserModelInterface) error {
+			return users.Delete("jane@example.com", models.DeleteSoft)
+		}},
+		{"locked account", func(users models.UserModelInterface) error {
+			return users.Lock("jane@example.com", time.Now().Add(time.Hour))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, base, _ := newTestApp(t)
+			s := oidctest.NewServer(t)
+			s.Claims["email"] = "jane@example.com"
+			s.Claims["email_verified"] = true
+			base.AddOIDCProvider("/auth", s.Provider("stub", ""))
+			base.MountOIDC(app, "/auth")
+			if err := base.Users.Insert("Jane", "jane@example.com", "password"); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.setup(base.Users); err != nil {
+				t.Fatal(err)
+			}
+
+			tc := newTestClient(app)
+			callback := s.Authorize(t, tc.do(t, fiber.MethodGet, "/auth/stub", nil).Header.Get("Location"))
+			resp := tc.do(t, fiber.MethodGet, callback.RequestURI(), nil)
+			if resp.StatusCode != fiber.StatusFound || resp.Header.Get("Location") != "/login?show=retained" {
+				t.Fatalf("callback = %d to %q; want a redirect to /login",
+					resp.StatusCode, resp.Header.Get("Location"))
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"database/sql"
+	"embed"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/email"
+)
+
+// Option configures the app built by New
+type Option func(*options)
+
+type options struct {
+	config        AppConfig
+	templates     *embed.FS
+	db            *sql.DB
+	storage       fiber.Storage
+	prefork       bool
+	assetPipeline bool
+	autoMigrate   bool
+	mailTransport email.Transport
+}
+
+func defaultOptions() options {
+	return options{
+		prefork:       true,
+		assetPipeline: true,
+		autoMigrate:   true,
+	}
+}
+
+// WithConfig sets the app config, which is required
+func WithConfig(config AppConfig) Option {
+	return func(o *options) {
+		o.config = config
+	}
+}
+
+// WithDB uses an existing connection pool instead of opening one from FIBER_USER_URI
+func WithDB(db *sql.DB) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WithStorage uses an existing storage for sessions, csrf, idempotency and the bank
+func WithStorage(storage fiber.Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithTemplates overrides the embedded templates given in the config
+func WithTemplates(templates *embed.FS) Option {
+	return func(o *options) {
+		o.templates = templates
+	}
+}
+
+// WithPrefork enables or disables prefork (enabled by default)
+func WithPrefork(prefork bool) Option {
+	return func(o *options) {
+		o.prefork = prefork
+	}
+}
+
+// WithoutAssetPipeline skips css fingerprinting, image conversion and favicon generation
+func WithoutAssetPipeline() Option {
+	return func(o *options) {
+		o.assetPipeline = false
+	}
+}
+
+// WithoutAutoMigrate skips applying pending migrations at startup; run them with Migrate instead
+func WithoutAutoMigrate() Option {
+	return func(o *options) {
+		o.autoMigrate = false
+	}
+}
+
+// WithMailTransport delivers email through the transport, e.g. an email.MemoryTransport in tests
+func WithMailTransport(transport email.Transport) Option {
+	return func(o *options) {
+		o.mailTransport = transport
+	}
+}
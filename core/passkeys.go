@@ -0,0 +1,185 @@
+package core
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+	"github.com/joashgobin/boiler/webauthn"
+)
+
+// MountPasskeys adds passkey sign-in and management under prefix, e.g. for the prefix "/passkeys":
+// /passkeys lists the signed in user's passkeys, /passkeys/register/options and /passkeys/register
+// add one, /passkeys/login/options and /passkeys/login sign in with one and /passkeys/:id/revoke
+// removes one. The passkey-script partial calls these from the browser.
+func (base *Base) MountPasskeys(router fiber.Router, prefix string) {
+	router.Get(prefix, base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		passkeys, err := base.Passkeys.ListForUser(user.ID)
+		if err != nil {
+			return err
+		}
+		return c.Render("views/partials/passkeys", fiber.Map{
+			"Title":    "Passkeys",
+			"Prefix":   prefix,
+			"Passkeys": passkeys,
+		})
+	})
+
+	router.Get(prefix+"/register/options", base.requireSignedInJSON, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		passkeys, err := base.Passkeys.ListForUser(user.ID)
+		if err != nil {
+			return err
+		}
+		exclude := make([]string, len(passkeys))
+		for i, passkey := range passkeys {
+			exclude[i] = passkey.CredentialID
+		}
+		challenge, err := base.newPasskeyChallenge(c)
+		if err != nil {
+			return err
+		}
+		userHandle := []byte(strconv.Itoa(user.ID))
+		return c.JSON(base.relyingParty().CreationOptions(challenge, userHandle, user.Email, user.Name, exclude))
+	})
+
+	router.Post(prefix+"/register", base.requireSignedInJSON, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		response, err := webauthn.ParseRegistration([]byte(c.FormValue("credential")))
+		if err != nil {
+			return passkeyError(c, fiber.StatusBadRequest, "The passkey could not be read")
+		}
+		credential, err := base.relyingParty().VerifyRegistration(base.takePasskeyChallenge(c), response)
+		if err != nil {
+			log.Infof("passkey registration failed for %s: %v", user.Email, err)
+			return passkeyError(c, fiber.StatusBadRequest, "The passkey could not be verified")
+		}
+		name := truncateName(c.FormValue("name"))
+		if name == "" {
+			name = "Passkey"
+		}
+		err = base.Passkeys.Insert(user.ID, credential, name)
+		if errors.Is(err, models.ErrDuplicatePasskey) {
+			return passkeyError(c, fiber.StatusConflict, "This passkey is already registered")
+		}
+		if err != nil {
+			return err
+		}
+		log.Infof("passkey added for %s", user.Email)
+		base.Flash.Push(c, "Your passkey has been added")
+		return c.JSON(fiber.Map{"redirect": prefix + "?show=retained"})
+	})
+
+	router.Get(prefix+"/login/options", func(c *fiber.Ctx) error {
+		challenge, err := base.newPasskeyChallenge(c)
+		if err != nil {
+			return err
+		}
+		return c.JSON(base.relyingParty().RequestOptions(challenge))
+	})
+
+	router.Post(prefix+"/login", func(c *fiber.Ctx) error {
+		response, err := webauthn.ParseAssertion([]byte(c.FormValue("credential")))
+		if err != nil {
+			return passkeyError(c, fiber.StatusBadRequest, "The passkey could not be read")
+		}
+		challenge := base.takePasskeyChallenge(c)
+		passkey, err := base.Passkeys.Get(response.ID)
+		if errors.Is(err, models.ErrNoRecord) {
+			return passkeyError(c, fiber.StatusUnauthorized, "This passkey isn't registered")
+		}
+		if err != nil {
+			return err
+		}
+		assertion, err := base.relyingParty().VerifyAssertion(challenge, response, passkey.Credential())
+		if err != nil {
+			log.Infof("passkey sign-in failed for %s: %v", passkey.Email, err)
+			return passkeyError(c, fiber.StatusUnauthorized, "The passkey could not be verified")
+		}
+		if err := base.Passkeys.Touch(passkey.ID, assertion.SignCount); err != nil {
+			log.Errorf("passkey touch error: %v", err)
+		}
+
+		user, err := base.Users.EmailAuthenticate(passkey.Email)
+		if errors.Is(err, models.ErrNoRecord) {
+			return passkeyError(c, fiber.StatusUnauthorized, "This passkey's account no longer exists")
+		}
+		if errors.Is(err, models.ErrAccountLocked) {
+			return passkeyError(c, fiber.StatusUnauthorized, "This account is locked, please try again later")
+		}
+		if err != nil {
+			return err
+		}
+		// a passkey that verified the user already counts as two factors
+		if assertion.UserVerified {
+			user.TwoFactorPending = false
+		}
+		if err := base.Flash.Set(c, "user", user); err != nil {
+			return err
+		}
+		if user.TwoFactorPending {
+			return c.JSON(fiber.Map{"redirect": models.TwoFactorPath})
+		}
+		return c.JSON(fiber.Map{"redirect": "/"})
+	})
+
+	router.Post(prefix+"/:id/revoke", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		id, err := c.ParamsInt("id")
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		err = base.Passkeys.Revoke(user.ID, id)
+		if errors.Is(err, models.ErrNoRecord) {
+			return base.Flash.Redirect(c, prefix, "That passkey was already removed")
+		}
+		if err != nil {
+			return err
+		}
+		log.Infof("passkey %d revoked for %s", id, user.Email)
+		return base.Flash.Redirect(c, prefix, "Your passkey has been removed")
+	})
+}
+
+// relyingParty identifies the app to authenticators, localhost during development
+func (base *Base) relyingParty() webauthn.RelyingParty {
+	id := "localhost"
+	if base.isProd {
+		id = base.domain
+	}
+	return webauthn.RelyingParty{ID: id, Name: base.appName, Origin: base.URL()}
+}
+
+// newPasskeyChallenge keeps a fresh challenge in the session for the ceremony being started
+func (base *Base) newPasskeyChallenge(c *fiber.Ctx) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	return challenge, base.Flash.Set(c, "passkeyChallenge", challenge)
+}
+
+// takePasskeyChallenge returns the pending challenge and clears it so it can't be replayed
+func (base *Base) takePasskeyChallenge(c *fiber.Ctx) string {
+	challenge, _ := base.Flash.Get(c, "passkeyChallenge").(string)
+	if err := base.Flash.Set(c, "passkeyChallenge", ""); err != nil {
+		log.Errorf("passkey challenge error: %v", err)
+	}
+	return challenge
+}
+
+// requireSignedInJSON is requireSignedIn for the passkey script, which reads errors as JSON
+func (base *Base) requireSignedInJSON(c *fiber.Ctx) error {
+	if !base.signedIn(c) {
+		return passkeyError(c, fiber.StatusUnauthorized, "You need to be logged in")
+	}
+	return c.Next()
+}
+
+func passkeyError(c *fiber.Ctx, status int, message string) error {
+	return c.Status(status).JSON(fiber.Map{"error": message})
+}
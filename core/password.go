@@ -0,0 +1,92 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/email"
+)
+
+// purpose recorded against password reset magic links
+const passwordResetPurpose = "password-reset"
+
+// MinPasswordLength is the shortest password accepted by the built-in handlers
+const MinPasswordLength = 8
+
+// PasswordResetTTL is how long a password reset link remains usable
+var PasswordResetTTL = time.Hour
+
+// MountPasswordReset adds the forgot and reset password pages under prefix,
+// e.g. /password/forgot and /password/reset/:token for the prefix "/password"
+func (base *Base) MountPasswordReset(router fiber.Router, prefix string) {
+	router.Get(prefix+"/forgot", func(c *fiber.Ctx) error {
+		return c.Render("views/partials/password-forgot", fiber.Map{
+			"Title":  "Forgot password",
+			"Action": prefix + "/forgot",
+		})
+	})
+
+	router.Post(prefix+"/forgot", func(c *fiber.Ctx) error {
+		userEmail := c.FormValue("email")
+		exists, err := base.Users.Exists(userEmail)
+		if err != nil {
+			log.Errorf("password reset lookup error: %v", err)
+		}
+		if exists {
+			link := base.Mail.GetExpiringMagicLink(userEmail, passwordResetPurpose, base.URL()+prefix+"/reset/", PasswordResetTTL)
+			base.Mail.Send(userEmail, "", "Reset your password",
+				"Use the link below to choose a new password. It expires in %d minutes and can only be used once.<br><a href=\"%s\">%s</a><br>If you didn't ask for this you can ignore this email.",
+				int(PasswordResetTTL.Minutes()), link, link)
+		}
+		// same response either way so accounts can't be discovered
+		return base.Flash.Redirect(c, prefix+"/forgot", "If an account exists for %s, a reset link is on its way", userEmail)
+	})
+
+	router.Get(prefix+"/reset/:token", func(c *fiber.Ctx) error {
+		userEmail, err := base.Mail.CheckMagicLink(c.Params("token"), passwordResetPurpose)
+		if err != nil {
+			return base.passwordResetFailed(c, prefix, err)
+		}
+		return c.Render("views/partials/password-reset", fiber.Map{
+			"Title":     "Reset password",
+			"Action":    prefix + "/reset/" + c.Params("token"),
+			"Email":     userEmail,
+			"MinLength": MinPasswordLength,
+		})
+	})
+
+	router.Post(prefix+"/reset/:token", func(c *fiber.Ctx) error {
+		token := c.Params("token")
+		password := c.FormValue("password")
+		if len(password) < MinPasswordLength {
+			return base.Flash.Redirect(c, prefix+"/reset/"+token, "Your password needs at least %d characters", MinPasswordLength)
+		}
+		if password != c.FormValue("confirm") {
+			return base.Flash.Redirect(c, prefix+"/reset/"+token, "The passwords don't match")
+		}
+		userEmail, err := base.Mail.UseMagicLink(token, passwordResetPurpose)
+		if err != nil {
+			return base.passwordResetFailed(c, prefix, err)
+		}
+		if err := base.Users.UpdatePassword(userEmail, password); err != nil {
+			log.Errorf("password update error: %v", err)
+			return base.Flash.Redirect(c, prefix+"/forgot", "Your password could not be changed, please request a new link")
+		}
+		log.Infof("password reset for %s", userEmail)
+
+		// the current session predates the new password as well
+		base.Flash.DeleteSession(c)
+		return base.Flash.Redirect(c, "/login", "Your password has been changed, please log in")
+	})
+}
+
+func (base *Base) passwordResetFailed(c *fiber.Ctx, prefix string, err error) error {
+	if errors.Is(err, email.ErrInvalidMagicLink) {
+		return base.Flash.Redirect(c, prefix+"/forgot", "That reset link is invalid or has expired, please request a new one")
+	}
+	log.Errorf("password reset link error: %v", err)
+	return fmt.Errorf("password reset: %w", err)
+}
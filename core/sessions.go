@@ -0,0 +1,124 @@
+package core
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// MountSessions adds session management under prefix, e.g. for the prefix "/sessions":
+// /sessions lists the signed in user's sessions, /sessions/:handle/rename labels one,
+// /sessions/:handle/revoke signs it out and /sessions/revoke-others signs out all but the current one.
+func (base *Base) MountSessions(router fiber.Router, prefix string) {
+	router.Get(prefix, base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		sessions, err := base.Sessions.List(user.ID)
+		if err != nil {
+			return err
+		}
+		current, err := base.currentSessionID(c)
+		if err != nil {
+			return err
+		}
+		return c.Render("views/partials/sessions", fiber.Map{
+			"Title":    "Sessions",
+			"Prefix":   prefix,
+			"Sessions": sessions,
+			"Current":  models.SessionHandle(current),
+		})
+	})
+
+	router.Post(prefix+"/revoke-others", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		current, err := base.currentSessionID(c)
+		if err != nil {
+			return err
+		}
+		if err := base.Sessions.RevokeAllExcept(current); err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return err
+		}
+		log.Infof("other sessions revoked for %s", user.Email)
+		return base.Flash.Redirect(c, prefix, "You have been logged out everywhere else")
+	})
+
+	router.Post(prefix+"/:handle/rename", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		info, err := base.findSession(user.ID, c.Params("handle"))
+		if errors.Is(err, models.ErrNoRecord) {
+			return base.Flash.Redirect(c, prefix, "That session has already ended")
+		}
+		if err != nil {
+			return err
+		}
+		name := truncateName(c.FormValue("name"))
+		if err := base.Sessions.Rename(info.ID, name); err != nil {
+			return err
+		}
+		return base.Flash.Redirect(c, prefix, "The session has been renamed")
+	})
+
+	router.Post(prefix+"/:handle/revoke", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		info, err := base.findSession(user.ID, c.Params("handle"))
+		if errors.Is(err, models.ErrNoRecord) {
+			return base.Flash.Redirect(c, prefix, "That session has already ended")
+		}
+		if err != nil {
+			return err
+		}
+		if err := base.Sessions.Revoke(info.ID); err != nil {
+			return err
+		}
+		log.Infof("session revoked for %s", user.Email)
+		return base.Flash.Redirect(c, prefix, "The session has been logged out")
+	})
+}
+
+// requireSignedIn lets only fully signed in users through, sending anyone else to /login
+func (base *Base) requireSignedIn(c *fiber.Ctx) error {
+	if !base.signedIn(c) {
+		return base.Flash.Redirect(c, "/login", "You need to be logged in")
+	}
+	return c.Next()
+}
+
+// signedIn reports whether the user is signed in and past any two-factor check
+func (base *Base) signedIn(c *fiber.Ctx) bool {
+	user := helpers.GetUser[models.User](c, base.Flash)
+	return user.Email != "" && !user.TwoFactorPending
+}
+
+// truncateName trims a name users give to a session or passkey, keeping at most 100 characters
+func truncateName(name string) string {
+	name = strings.TrimSpace(name)
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	return name
+}
+
+func (base *Base) currentSessionID(c *fiber.Ctx) (string, error) {
+	sess, err := base.Store.Get(c)
+	if err != nil {
+		return "", err
+	}
+	return sess.ID(), nil
+}
+
+// findSession looks up one of the user's sessions by the handle shown in pages
+func (base *Base) findSession(userID int, handle string) (models.SessionInfo, error) {
+	sessions, err := base.Sessions.List(userID)
+	if err != nil {
+		return models.SessionInfo{}, err
+	}
+	for _, info := range sessions {
+		if info.Handle == handle {
+			return info, nil
+		}
+	}
+	return models.SessionInfo{}, models.ErrNoRecord
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRequireSignedIn(t *testing.T) {
+	tc, base, _ := newLockoutTest(t)
+	tc.app.Get("/private", base.requireSignedIn, func(c *fiber.Ctx) error {
+		return c.SendString("private")
+	})
+
+	if status, _ := tc.text(t, fiber.MethodGet, "/private", nil); status != fiber.StatusFound {
+		t.Fatalf("signed out status = %d; want a redirect to /login", status)
+	}
+	if got := login(t, tc, "jane@example.com", "password"); got != "signed in" {
+		t.Fatalf("login = %q; want signed in", got)
+	}
+	if status, body := tc.text(t, fiber.MethodGet, "/private", nil); status != fiber.StatusOK || body != "private" {
+		t.Fatalf("signed in = %d %q; want 200 private", status, body)
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"  Work laptop ", "Work laptop"},
+		{strings.Repeat("é", 120), strings.Repeat("é", 100)},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := truncateName(tt.name); got != tt.want {
+			t.Errorf("truncateName(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
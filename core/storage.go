@@ -0,0 +1,38 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/storage/valkey"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// built-in storage backends selectable via AppConfig.StorageBackend
+const (
+	StorageValkey = "valkey"
+	StorageMemory = "memory"
+	StorageSQL    = "sql"
+)
+
+func newStorage(backend string, db *sql.DB, d dialect.Dialect) (fiber.Storage, error) {
+	switch backend {
+	case "", StorageValkey:
+		return valkey.New(valkey.Config{
+			InitAddress: []string{"localhost:6379"},
+			Username:    "",
+			Password:    "",
+			SelectDB:    0,
+			Reset:       false,
+			TLSConfig:   nil,
+		}), nil
+	case StorageMemory:
+		return helpers.NewMemoryStorage(), nil
+	case StorageSQL:
+		return helpers.NewSQLStorage(db, d, "fiber_storage")
+	default:
+		return nil, fmt.Errorf("config error: unknown storage backend %q", backend)
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/core/models"
+)
+
+func TestTokenCSRF(t *testing.T) {
+	app, base, _ := newTestApp(t)
+	csrfRoute(app)
+	// a form signed in users post from the site, and an API route
+	app.Post("/settings", func(c *fiber.Ctx) error {
+		return c.SendString("saved")
+	})
+	app.Post("/api/posts", base.RequireToken("posts:write"), func(c *fiber.Ctx) error {
+		return c.SendString("created by " + c.Locals("user").(models.User).Email)
+	})
+
+	if err := base.Users.Insert("Jane", "jane@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	user, err := base.Users.EmailAuthenticate("jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := base.Users.MintToken(user.ID, "test", []string{"posts:write"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		withCSRF      bool
+		want          int
+	}{
+		{"form without a CSRF token", "/settings", "", false, fiber.StatusForbidden},
+		{"form with a CSRF token", "/settings", "", true, fiber.StatusOK},
+		{"form with an invalid bearer token", "/settings", "Bearer forged", false, fiber.StatusUnauthorized},
+		{"form with a valid bearer token", "/settings", "Bearer " + token, false, fiber.StatusOK},
+		{"api without a token", "/api/posts", "", false, fiber.StatusForbidden},
+		{"api with an invalid token", "/api/posts", "Bearer forged", false, fiber.StatusUnauthorized},
+		{"api with a valid token", "/api/posts", "Bearer " + token, false, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestClient(app)
+			form := url.Values{}
+			if tt.withCSRF {
+				form.Set("csrf", tc.csrf(t))
+			}
+			// the test app has no templates for the HTML error page
+			headers := []string{fiber.HeaderAccept, fiber.MIMEApplicationJSON}
+			if tt.authorization != "" {
+				headers = append(headers, fiber.HeaderAuthorization, tt.authorization)
+			}
+			status, body := tc.text(t, fiber.MethodPost, tt.path, form, headers...)
+			if status != tt.want {
+				t.Fatalf("status = %d; want %d (%s)", status, tt.want, body)
+			}
+		})
+	}
+}
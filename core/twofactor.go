@@ -0,0 +1,123 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	ht "html/template"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// MaxTwoFactorAttempts is how many wrong codes a user gets in a LoginFailureWindow
+// before their session is ended and they are locked out like after failed logins
+const MaxTwoFactorAttempts = 5
+
+// MountTwoFactor adds the two-factor pages under prefix, e.g. /2fa to enter a
+// code after logging in and /2fa/setup to enable or disable it for the prefix "/2fa".
+// RequireRoleMiddleware sends users with a pending code to prefix.
+func (base *Base) MountTwoFactor(router fiber.Router, prefix string) {
+	models.TwoFactorPath = prefix
+
+	router.Get(prefix, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		if !user.TwoFactorPending {
+			return c.Redirect("/login")
+		}
+		return c.Render("views/partials/two-factor-verify", fiber.Map{
+			"Title":  "Two-factor authentication",
+			"Action": prefix,
+		})
+	})
+
+	router.Post(prefix, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		if !user.TwoFactorPending {
+			return c.Redirect("/login")
+		}
+		err := base.Users.CheckTOTP(user.Email, c.FormValue("code"))
+		if errors.Is(err, models.ErrInvalidCode) {
+			// counted per user rather than per session, which a new login would reset
+			failures := base.countLoginFailure("totp", user.Email)
+			if lockout := loginBackoff(failures, MaxTwoFactorAttempts); lockout > 0 {
+				log.Infof("two-factor locked for %s for %v after %d wrong codes", user.Email, lockout, failures)
+				base.lockEmail(user.Email, lockout)
+				base.Flash.DeleteSession(c)
+				return base.Flash.Redirect(c, "/login", "Too many wrong codes, try again in %s", formatWait(lockout))
+			}
+			return base.Flash.Redirect(c, prefix, "That code didn't work, please try again")
+		}
+		if err != nil {
+			return fmt.Errorf("two-factor check: %w", err)
+		}
+
+		base.resetLoginFailures("totp", user.Email)
+		user.TwoFactorPending = false
+		if err := base.Flash.Set(c, "user", user); err != nil {
+			return err
+		}
+		return c.Redirect("/")
+	})
+
+	router.Get(prefix+"/setup", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		enabled, err := base.Users.HasTOTP(user.Email)
+		if err != nil {
+			return fmt.Errorf("two-factor setup: %w", err)
+		}
+		data := fiber.Map{
+			"Title":         "Two-factor authentication",
+			"Action":        prefix + "/setup",
+			"DisableAction": prefix + "/disable",
+			"Enabled":       enabled,
+		}
+		if !enabled {
+			secret, err := base.Users.BeginTOTP(user.Email)
+			if err != nil {
+				return fmt.Errorf("two-factor setup: %w", err)
+			}
+			qr, err := base.QR.DataURI(helpers.TOTPURI(base.appName, user.Email, secret))
+			if err != nil {
+				return fmt.Errorf("two-factor setup: %w", err)
+			}
+			data["Secret"] = secret
+			// data uris are only trusted in img src when marked as such
+			data["QR"] = ht.URL(qr)
+		}
+		return c.Render("views/partials/two-factor-setup", data)
+	})
+
+	router.Post(prefix+"/setup", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		codes, err := base.Users.EnableTOTP(user.Email, c.FormValue("code"))
+		if errors.Is(err, models.ErrInvalidCode) || errors.Is(err, models.ErrTOTPNotStarted) {
+			return base.Flash.Redirect(c, prefix+"/setup", "That code didn't work, please scan the new QR code and try again")
+		}
+		if err != nil {
+			return fmt.Errorf("two-factor setup: %w", err)
+		}
+		log.Infof("two-factor authentication enabled for %s", user.Email)
+		return c.Render("views/partials/two-factor-recovery", fiber.Map{
+			"Title": "Recovery codes",
+			"Codes": codes,
+		})
+	})
+
+	router.Post(prefix+"/disable", base.requireSignedIn, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		err := base.Users.CheckTOTP(user.Email, c.FormValue("code"))
+		if errors.Is(err, models.ErrInvalidCode) {
+			return base.Flash.Redirect(c, prefix+"/setup", "That code didn't work, please try again")
+		}
+		if err != nil {
+			return fmt.Errorf("two-factor disable: %w", err)
+		}
+		if err := base.Users.DisableTOTP(user.Email); err != nil {
+			return fmt.Errorf("two-factor disable: %w", err)
+		}
+		log.Infof("two-factor authentication disabled for %s", user.Email)
+		return base.Flash.Redirect(c, prefix+"/setup", "Two-factor authentication has been turned off")
+	})
+}
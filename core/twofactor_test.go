@@ -0,0 +1,57 @@
+package core
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+func TestTwoFactorLockout(t *testing.T) {
+	tc, base, _ := newLockoutTest(t)
+	base.MountTwoFactor(tc.app, "/2fa")
+	secret, err := base.Users.BeginTOTP("jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := helpers.TOTPCode(secret, helpers.TOTPStep(time.Now())-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base.Users.EnableTOTP("jane@example.com", code); err != nil {
+		t.Fatal(err)
+	}
+
+	// wrongCode posts a wrong code and returns the path it redirected to
+	wrongCode := func(tc *testClient) string {
+		t.Helper()
+		resp := tc.do(t, fiber.MethodPost, "/2fa", url.Values{"code": {"000000x"}})
+		resp.Body.Close()
+		path, _, _ := strings.Cut(resp.Header.Get(fiber.HeaderLocation), "?")
+		return path
+	}
+
+	if got := login(t, tc, "jane@example.com", "password"); got != "signed in" {
+		t.Fatalf("login = %q; want signed in", got)
+	}
+	for range MaxTwoFactorAttempts - 1 {
+		if got := wrongCode(tc); got != "/2fa" {
+			t.Fatalf("wrong code redirected to %q; want /2fa", got)
+		}
+	}
+
+	// logging in again doesn't start the count over
+	tc = newTestClient(tc.app)
+	if got := login(t, tc, "jane@example.com", "password"); got != "signed in" {
+		t.Fatalf("second login = %q; want signed in", got)
+	}
+	if got := wrongCode(tc); got != "/login" {
+		t.Fatalf("wrong code past the limit redirected to %q; want /login", got)
+	}
+	if got := login(t, tc, "jane@example.com", "password"); got != "locked" {
+		t.Fatalf("login after too many wrong codes = %q; want locked", got)
+	}
+}
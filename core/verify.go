@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// VerificationResendInterval is how long a user waits between verification emails
+var VerificationResendInterval = 5 * time.Minute
+
+// MountEmailVerification adds the verification pages under prefix,
+// e.g. /verify, /verify/resend and /verify/:token for the prefix "/verify".
+// New users are sent a verification link once these pages are mounted.
+func (base *Base) MountEmailVerification(router fiber.Router, prefix string) {
+	if users, ok := base.Users.(*models.UserModel); ok {
+		users.VerifyURL = base.URL() + prefix + "/"
+	}
+
+	router.Get(prefix, func(c *fiber.Ctx) error {
+		user := helpers.GetUser[models.User](c, base.Flash)
+		return c.Render("views/partials/verify-email", fiber.Map{
+			"Title":    "Verify your email",
+			"Action":   prefix + "/resend",
+			"Email":    user.Email,
+			"Verified": user.Verified(),
+		})
+	})
+
+	router.Post(prefix+"/resend", func(c *fiber.Ctx) error {
+		userEmail := helpers.GetUser[models.User](c, base.Flash).Email
+		if userEmail == "" {
+			userEmail = c.FormValue("email")
+		}
+		if userEmail == "" {
+			return base.Flash.Redirect(c, prefix, "Enter the email you signed up with")
+		}
+
+		// one email per address per interval
+		key := "verify-resend-" + userEmail
+		if base.Bank.GetString(key) != "" {
+			return base.Flash.Redirect(c, prefix, "Please wait a few minutes before asking for another link")
+		}
+		base.Bank.SetString(key, "sent", VerificationResendInterval)
+
+		verified, err := base.Users.IsVerified(userEmail)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			log.Errorf("verification lookup error: %v", err)
+		}
+		if err == nil && !verified {
+			if err := base.Users.SendVerification(userEmail); err != nil {
+				log.Errorf("verification email error: %v", err)
+			}
+		}
+		// same response either way so accounts can't be discovered
+		return base.Flash.Redirect(c, prefix, "If %s needs verifying, a new link is on its way", userEmail)
+	})
+
+	router.Get(prefix+"/:token", func(c *fiber.Ctx) error {
+		userEmail, err := base.Mail.UseMagicLink(c.Params("token"), models.VerifyEmailPurpose)
+		if errors.Is(err, email.ErrInvalidMagicLink) {
+			return base.Flash.Redirect(c, prefix, "That verification link is invalid or has expired, please request a new one")
+		}
+		if err != nil {
+			return fmt.Errorf("email verification: %w", err)
+		}
+		if err := base.Users.Verify(userEmail); err != nil {
+			return fmt.Errorf("email verification: %w", err)
+		}
+		log.Infof("verified email for %s", userEmail)
+
+		// refresh the signed in user so gated pages open straight away
+		user := helpers.GetUser[models.User](c, base.Flash)
+		if user.Email == userEmail {
+			user.VerifiedAt = time.Now().UTC()
+			if err := base.Flash.Set(c, "user", user); err != nil {
+				log.Errorf("session save error: %v", err)
+			}
+		}
+		return base.Flash.Redirect(c, "/", "Your email has been verified")
+	})
+}
@@ -0,0 +1,127 @@
+// Package dialect hides the differences between the SQL databases boiler can run on.
+//
+// MySQL is the default. SQLite and Postgres need their driver to be imported by
+// the app, e.g. _ "modernc.org/sqlite" or _ "github.com/jackc/pgx/v5/stdlib".
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Dialect interface {
+	// Name is the dialect name used to select dialect-specific migration files
+	Name() string
+	// DriverName is the database/sql driver used to open connections
+	DriverName() string
+	// DSN builds the data source name of the app database from a base URI
+	DSN(uri, appName string) string
+	// Rebind converts ? placeholders to the ones the driver expects
+	Rebind(query string) string
+	// Upsert builds an insert of the given number of rows that updates the
+	// non-conflicting columns when a row with the same conflict columns exists
+	Upsert(table string, conflict []string, columns []string, rows int) string
+	// Now is the SQL expression for the current UTC timestamp
+	Now() string
+	// Quote quotes an identifier that may be a reserved word
+	Quote(identifier string) string
+	// IsDuplicate reports whether err was caused by a unique constraint
+	IsDuplicate(err error) bool
+	// Types are substituted for <key> placeholders in migrations
+	Types() map[string]string
+	// Lock takes a database-wide advisory lock on conn, returning a release function
+	Lock(ctx context.Context, conn *sql.Conn, name string) (func(), error)
+}
+
+// Or returns d, or MySQL when d is nil
+func Or(d Dialect) Dialect {
+	if d == nil {
+		return MySQL{}
+	}
+	return d
+}
+
+// Replace substitutes the dialect types for <key> placeholders in a query
+func Replace(d Dialect, query string) string {
+	for key, value := range d.Types() {
+		query = strings.ReplaceAll(query, "<"+key+">", value)
+	}
+	return query
+}
+
+// Time binds t the way columns set to Now() store it, so they can be compared.
+// SQLite keeps CURRENT_TIMESTAMP as text to the second, which a time.Time,
+// written by the drivers with fractions and a zone, never equals, so every
+// timestamp written or compared in a query goes through Time.
+func Time(d Dialect, t time.Time) any {
+	if Or(d).Name() == "sqlite" {
+		return t.UTC().Format(time.DateTime)
+	}
+	return t.UTC()
+}
+
+func placeholders(count int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", count), ", ") + ")"
+}
+
+func insertRows(table string, columns []string, rows int) string {
+	values := make([]string, rows)
+	for i := range values {
+		values[i] = placeholders(len(columns))
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES " + strings.Join(values, ", ")
+}
+
+func updatable(conflict []string, columns []string) []string {
+	var updates []string
+	for _, column := range columns {
+		isKey := false
+		for _, key := range conflict {
+			if key == column {
+				isKey = true
+			}
+		}
+		if !isKey {
+			updates = append(updates, column)
+		}
+	}
+	return updates
+}
+
+// onConflict is the upsert clause shared by SQLite and Postgres
+func onConflict(table string, conflict []string, columns []string, rows int) string {
+	query := insertRows(table, columns, rows) + " ON CONFLICT (" + strings.Join(conflict, ", ") + ")"
+	updates := updatable(conflict, columns)
+	if len(updates) == 0 {
+		return query + " DO NOTHING"
+	}
+	sets := make([]string, len(updates))
+	for i, column := range updates {
+		sets[i] = column + " = excluded." + column
+	}
+	return query + " DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// rebindDollar numbers ? placeholders as $1, $2... skipping quoted strings
+func rebindDollar(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 10)
+	n := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+			b.WriteRune(r)
+		case r == '?' && !inQuote:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
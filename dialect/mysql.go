@@ -0,0 +1,80 @@
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var _ Dialect = MySQL{}
+
+type MySQL struct{}
+
+func (MySQL) Name() string {
+	return "mysql"
+}
+
+func (MySQL) DriverName() string {
+	return "mysql"
+}
+
+// DSN expects a URI such as user:password@tcp(localhost:3306)/
+func (MySQL) DSN(uri, appName string) string {
+	return uri + appName + "?parseTime=true&multiStatements=true"
+}
+
+func (MySQL) Rebind(query string) string {
+	return query
+}
+
+func (MySQL) Upsert(table string, conflict []string, columns []string, rows int) string {
+	query := insertRows(table, columns, rows)
+	updates := updatable(conflict, columns)
+	if len(updates) == 0 {
+		return strings.Replace(query, "INSERT", "INSERT IGNORE", 1)
+	}
+	sets := make([]string, len(updates))
+	for i, column := range updates {
+		sets[i] = column + " = VALUES(" + column + ")"
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+}
+
+func (MySQL) Now() string {
+	return "UTC_TIMESTAMP()"
+}
+
+func (MySQL) Quote(identifier string) string {
+	return "`" + identifier + "`"
+}
+
+func (MySQL) IsDuplicate(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062
+}
+
+func (MySQL) Types() map[string]string {
+	return map[string]string{
+		"autoincrement": "INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT",
+		"datetime":      "DATETIME",
+		"blob":          "BLOB",
+	}
+}
+
+func (MySQL) Lock(ctx context.Context, conn *sql.Conn, name string) (func(), error) {
+	var acquired sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT(DATABASE(), ?), 60)", "."+name).Scan(&acquired)
+	if err != nil {
+		return nil, err
+	}
+	if acquired.Int64 != 1 {
+		return nil, fmt.Errorf("timed out waiting for lock %s", name)
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(CONCAT(DATABASE(), ?))", "."+name)
+	}, nil
+}
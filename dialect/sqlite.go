@@ -0,0 +1,72 @@
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+var _ Dialect = SQLite{}
+
+// SQLite runs the app against a single database file, e.g. for development and tests
+type SQLite struct {
+	// Driver is the registered driver name, "sqlite" (modernc.org/sqlite) by default
+	// or "sqlite3" (github.com/mattn/go-sqlite3)
+	Driver string
+}
+
+func (SQLite) Name() string {
+	return "sqlite"
+}
+
+func (d SQLite) DriverName() string {
+	if d.Driver == "" {
+		return "sqlite"
+	}
+	return d.Driver
+}
+
+// DSN expects a directory for the database file, defaulting to the working directory
+func (d SQLite) DSN(uri, appName string) string {
+	path := appName + ".sqlite3"
+	if uri != "" {
+		path = strings.TrimSuffix(uri, "/") + "/" + path
+	}
+	if d.DriverName() == "sqlite3" {
+		return "file:" + path + "?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on"
+	}
+	return "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+}
+
+func (SQLite) Rebind(query string) string {
+	return query
+}
+
+func (SQLite) Upsert(table string, conflict []string, columns []string, rows int) string {
+	return onConflict(table, conflict, columns, rows)
+}
+
+func (SQLite) Now() string {
+	return "CURRENT_TIMESTAMP"
+}
+
+func (SQLite) Quote(identifier string) string {
+	return `"` + identifier + `"`
+}
+
+func (SQLite) IsDuplicate(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func (SQLite) Types() map[string]string {
+	return map[string]string{
+		"autoincrement": "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"datetime":      "DATETIME",
+		"blob":          "BLOB",
+	}
+}
+
+// Lock is a no-op since SQLite already serializes writers on the database file
+func (SQLite) Lock(ctx context.Context, conn *sql.Conn, name string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,378 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	ht "html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// states of a campaign
+const (
+	CampaignDraft   = "draft"
+	CampaignSending = "sending"
+	CampaignSent    = "sent"
+)
+
+// tunables of the campaign worker
+var (
+	// CampaignBatchSize is how many subscribers a campaign queues at a time
+	CampaignBatchSize = 50
+	// CampaignInterval is the wait between the batches of a campaign, throttling
+	// how fast it fills the outbox
+	CampaignInterval = time.Minute
+	// CampaignPollInterval is how often the worker looks for a batch to send
+	CampaignPollInterval = 15 * time.Second
+)
+
+var (
+	ErrNoCampaign      = errors.New("email: no such campaign")
+	ErrCampaignStarted = errors.New("email: campaign already started")
+)
+
+type Campaign struct {
+	ID       int
+	ListID   int
+	ListName string
+	Subject  string
+	// Template is the email template of the campaign, or empty when it's sent as Body
+	Template   string
+	Body       string
+	Status     string
+	Created    time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// CampaignStats counts what became of the emails of a campaign
+type CampaignStats struct {
+	// Recipients counts the subscribers the campaign was queued for
+	Recipients int
+	// Queued counts the emails waiting in the outbox
+	Queued int
+	Sent   int
+	// Failed counts the emails the outbox gave up on or that couldn't be queued
+	Failed int
+	// Unsubscribed counts the subscribers who unsubscribed from the campaign's email
+	Unsubscribed int
+}
+
+// CampaignData is what a campaign's template or body is executed with
+type CampaignData struct {
+	Email          string
+	List           List
+	Campaign       Campaign
+	UnsubscribeURL string
+}
+
+// CreateCampaign adds a draft campaign to the list. It's sent as the email
+// template if there is one, executed with CampaignData, and subject overrides
+// the template's. Otherwise body is sent, an html/template also executed with
+// CampaignData that should link {{.UnsubscribeURL}}.
+func (m *ListModel) CreateCampaign(list, subject, template, body string) (Campaign, error) {
+	l, err := m.GetList(list)
+	if err != nil {
+		return Campaign{}, err
+	}
+	switch {
+	case template != "":
+		if mail, ok := m.Mail.(*MailModel); ok && mail.templates[template] == nil {
+			return Campaign{}, fmt.Errorf("%w: %s", ErrNoTemplate, template)
+		}
+	case subject == "" || body == "":
+		return Campaign{}, errors.New("email: a campaign needs a template or a subject and body")
+	default:
+		if _, err := ht.New("body").Parse(body); err != nil {
+			return Campaign{}, err
+		}
+	}
+
+	stmt := `INSERT INTO campaigns (list_id, subject, template, body, status, created) VALUES (?, ?, ?, ?, ?, ?)`
+	args := []any{l.ID, subject, template, body, CampaignDraft, dialect.Time(m.Dialect, time.Now())}
+	var id int64
+	// postgres drivers don't support LastInsertId
+	if dialect.Or(m.Dialect).Name() == "postgres" {
+		if err := m.DB.QueryRow(m.rebind(stmt+` RETURNING id`), args...).Scan(&id); err != nil {
+			return Campaign{}, err
+		}
+	} else {
+		result, err := m.DB.Exec(m.rebind(stmt), args...)
+		if err != nil {
+			return Campaign{}, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return Campaign{}, err
+		}
+	}
+	return m.GetCampaign(int(id))
+}
+
+// StartCampaign has the worker send a draft campaign to the list's subscribers,
+// CampaignBatchSize of them every CampaignInterval
+func (m *ListModel) StartCampaign(id int) error {
+	if m.URL == "" {
+		return errors.New("email: mount the list handlers before sending campaigns")
+	}
+	now := dialect.Time(m.Dialect, time.Now())
+	stmt := `UPDATE campaigns SET status = ?, started_at = ?, next_batch_at = ? WHERE id = ? AND status = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), CampaignSending, now, now, id, CampaignDraft)
+	if err != nil {
+		return err
+	}
+	if changed, err := result.RowsAffected(); err != nil || changed > 0 {
+		return err
+	}
+	if _, err := m.GetCampaign(id); err != nil {
+		return err
+	}
+	return ErrCampaignStarted
+}
+
+func (m *ListModel) GetCampaign(id int) (Campaign, error) {
+	campaigns, err := m.queryCampaigns(`WHERE c.id = ?`, id)
+	if err != nil {
+		return Campaign{}, err
+	}
+	if len(campaigns) == 0 {
+		return Campaign{}, ErrNoCampaign
+	}
+	return campaigns[0], nil
+}
+
+// Campaigns returns the list's campaigns, newest first, or every list's when it is empty
+func (m *ListModel) Campaigns(list string) ([]Campaign, error) {
+	if list == "" {
+		return m.queryCampaigns(`ORDER BY c.id DESC`)
+	}
+	return m.queryCampaigns(`WHERE l.name = ? ORDER BY c.id DESC`, list)
+}
+
+func (m *ListModel) queryCampaigns(clause string, args ...any) ([]Campaign, error) {
+	stmt := `SELECT c.id, c.list_id, l.name, c.subject, c.template, c.body, c.status, c.created, c.started_at, c.finished_at
+	FROM campaigns c JOIN lists l ON l.id = c.list_id ` + clause
+	rows, err := m.DB.Query(m.rebind(stmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []Campaign
+	for rows.Next() {
+		var campaign Campaign
+		var startedAt, finishedAt sql.NullTime
+		err := rows.Scan(&campaign.ID, &campaign.ListID, &campaign.ListName, &campaign.Subject, &campaign.Template,
+			&campaign.Body, &campaign.Status, &campaign.Created, &startedAt, &finishedAt)
+		if err != nil {
+			return nil, err
+		}
+		campaign.StartedAt = startedAt.Time
+		campaign.FinishedAt = finishedAt.Time
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, rows.Err()
+}
+
+// CampaignStats counts the campaign's emails by their state in the outbox
+func (m *ListModel) CampaignStats(id int) (CampaignStats, error) {
+	if _, err := m.GetCampaign(id); err != nil {
+		return CampaignStats{}, err
+	}
+	var stats CampaignStats
+	stmt := `SELECT COUNT(*),
+	COALESCE(SUM(CASE WHEN o.status = ? THEN 1 ELSE 0 END), 0),
+	COALESCE(SUM(CASE WHEN o.status = ? OR (r.outbox_id IS NULL AND r.error <> '') THEN 1 ELSE 0 END), 0)
+	FROM campaign_recipients r LEFT JOIN email_outbox o ON o.id = r.outbox_id
+	WHERE r.campaign_id = ?`
+	err := m.DB.QueryRow(m.rebind(stmt), OutboxSent, OutboxDead, id).Scan(&stats.Recipients, &stats.Sent, &stats.Failed)
+	if err != nil {
+		return CampaignStats{}, err
+	}
+	stats.Queued = stats.Recipients - stats.Sent - stats.Failed
+
+	stmt = `SELECT COUNT(*) FROM subscribers WHERE unsubscribed_campaign_id = ? AND status = ?`
+	if err := m.DB.QueryRow(m.rebind(stmt), id, SubscriberUnsubscribed).Scan(&stats.Unsubscribed); err != nil {
+		return CampaignStats{}, err
+	}
+	return stats, nil
+}
+
+// RunCampaigns queues the batches of the campaigns being sent until ctx is done.
+// The emails go out through the outbox worker, so several processes can run
+// this without sending anything twice.
+func (m *ListModel) RunCampaigns(ctx context.Context) {
+	ticker := time.NewTicker(CampaignPollInterval)
+	defer ticker.Stop()
+	for {
+		m.sendBatches(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendBatches queues the next batch of every campaign that is due one
+func (m *ListModel) sendBatches(ctx context.Context) {
+	now := time.Now()
+	stmt := `SELECT id FROM campaigns WHERE status = ? AND next_batch_at <= ? ORDER BY id`
+	rows, err := m.DB.Query(m.rebind(stmt), CampaignSending, dialect.Time(m.Dialect, now))
+	if err != nil {
+		log.Errorf("campaign query error: %v", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Errorf("campaign scan error: %v", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		// claim the batch by pushing the next one back, another worker may have already
+		stmt := `UPDATE campaigns SET next_batch_at = ? WHERE id = ? AND status = ? AND next_batch_at <= ?`
+		result, err := m.DB.Exec(m.rebind(stmt), dialect.Time(m.Dialect, now.Add(CampaignInterval)), id, CampaignSending,
+			dialect.Time(m.Dialect, now))
+		if err != nil {
+			log.Errorf("campaign claim error: %v", err)
+			continue
+		}
+		if changed, err := result.RowsAffected(); err != nil || changed == 0 {
+			continue
+		}
+		if err := m.sendBatch(ctx, id); err != nil {
+			log.Errorf("campaign %d error: %v", id, err)
+		}
+	}
+}
+
+// sendBatch queues the campaign for the next CampaignBatchSize subscribers it
+// hasn't been queued for, finishing it when they run out
+func (m *ListModel) sendBatch(ctx context.Context, id int) error {
+	campaign, err := m.GetCampaign(id)
+	if err != nil {
+		return err
+	}
+	list, err := m.GetList(campaign.ListName)
+	if err != nil {
+		return err
+	}
+	var body *ht.Template
+	if campaign.Template == "" {
+		if body, err = ht.New("body").Parse(campaign.Body); err != nil {
+			return err
+		}
+	}
+
+	stmt := `SELECT s.id, s.email, s.token FROM subscribers s
+	WHERE s.list_id = ? AND s.status = ?
+	AND NOT EXISTS (SELECT 1 FROM campaign_recipients r WHERE r.campaign_id = ? AND r.subscriber_id = s.id)
+	ORDER BY s.id LIMIT ?`
+	rows, err := m.DB.Query(m.rebind(stmt), list.ID, SubscriberSubscribed, campaign.ID, CampaignBatchSize)
+	if err != nil {
+		return err
+	}
+	var subscribers []Subscriber
+	for rows.Next() {
+		var subscriber Subscriber
+		if err := rows.Scan(&subscriber.ID, &subscriber.Email, &subscriber.Token); err != nil {
+			rows.Close()
+			return err
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, subscriber := range subscribers {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// the recipient is recorded first so a subscriber is never queued twice
+		stmt := `INSERT INTO campaign_recipients (campaign_id, subscriber_id, created) VALUES (?, ?, ?)`
+		if _, err := m.DB.Exec(m.rebind(stmt), campaign.ID, subscriber.ID, dialect.Time(m.Dialect, time.Now())); err != nil {
+			log.Errorf("campaign %d recipient error: %v", campaign.ID, err)
+			continue
+		}
+		outboxID, err := m.queueCampaign(campaign, list, body, subscriber)
+		if err != nil {
+			stmt = `UPDATE campaign_recipients SET error = ? WHERE campaign_id = ? AND subscriber_id = ?`
+			_, err = m.DB.Exec(m.rebind(stmt), truncate(err.Error(), 1000), campaign.ID, subscriber.ID)
+		} else {
+			stmt = `UPDATE campaign_recipients SET outbox_id = ? WHERE campaign_id = ? AND subscriber_id = ?`
+			_, err = m.DB.Exec(m.rebind(stmt), outboxID, campaign.ID, subscriber.ID)
+		}
+		if err != nil {
+			log.Errorf("campaign %d recipient error: %v", campaign.ID, err)
+		}
+	}
+
+	if len(subscribers) < CampaignBatchSize {
+		stmt := `UPDATE campaigns SET status = ?, finished_at = ?, next_batch_at = NULL WHERE id = ?`
+		if _, err := m.DB.Exec(m.rebind(stmt), CampaignSent, dialect.Time(m.Dialect, time.Now()), campaign.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueCampaign queues the campaign's email to a subscriber, with the list's
+// headers and a link to unsubscribe
+func (m *ListModel) queueCampaign(campaign Campaign, list List, body *ht.Template, subscriber Subscriber) (int64, error) {
+	unsubscribeURL := m.URL + "/unsubscribe/" + subscriber.Token + "?campaign=" + strconv.Itoa(campaign.ID)
+	data := CampaignData{
+		Email:          subscriber.Email,
+		List:           list,
+		Campaign:       campaign,
+		UnsubscribeURL: unsubscribeURL,
+	}
+	message := NewMessage().To(subscriber.Email).Subject(campaign.Subject).Header("List-Id", m.listID(list))
+	// RFC 8058 only allows https, so development servers get a plain link
+	if strings.HasPrefix(unsubscribeURL, "https://") {
+		message = message.OneClickUnsubscribe(unsubscribeURL)
+	} else {
+		message = message.ListUnsubscribe(unsubscribeURL)
+	}
+	if body == nil {
+		message = message.Template(campaign.Template, data)
+	} else {
+		var html bytes.Buffer
+		if err := body.Execute(&html, data); err != nil {
+			return 0, err
+		}
+		message = message.HTML(html.String())
+	}
+	return m.Mail.QueueMessage(message)
+}
+
+// listID is the List-Id header of RFC 2919 identifying the list, e.g.
+// "Weekly news <news.example.com>"
+func (m *ListModel) listID(list List) string {
+	host := "localhost"
+	if u, err := url.Parse(m.URL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	title := list.Title
+	if title == "" {
+		title = list.Name
+	}
+	return title + " <" + list.Name + "." + host + ">"
+}
@@ -0,0 +1,203 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/joashgobin/boiler/email"
+)
+
+var confirmLink = regexp.MustCompile(`/confirm/([^"<\s]+)`)
+
+// newTestLists returns a ListModel with a "news" list, mounted at https://example.com/lists
+func newTestLists(t *testing.T) (*email.ListModel, *email.MailModel, *email.MemoryTransport) {
+	t.Helper()
+	mail, transport := newTestMail(t)
+	lists := &email.ListModel{DB: mail.DB, Dialect: testDialect, Mail: mail, URL: "https://example.com/lists"}
+	if _, err := lists.CreateList("news", "News"); err != nil {
+		t.Fatal(err)
+	}
+	return lists, mail, transport
+}
+
+// subscribe subscribes the address to the news list, following the link in
+// the confirmation email when confirm is set
+func subscribe(t *testing.T, lists *email.ListModel, mail *email.MailModel, transport *email.MemoryTransport, address string, confirm bool) {
+	t.Helper()
+	if err := lists.Subscribe("news", address); err != nil {
+		t.Fatal(err)
+	}
+	mail.SendDue(context.Background())
+	match := confirmLink.FindStringSubmatch(transport.AssertSent(t, address).HTML())
+	if match == nil {
+		t.Fatalf("no confirmation link in the email to %s", address)
+	}
+	if !confirm {
+		return
+	}
+	if _, err := lists.ConfirmSubscription("news", match[1]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	// a campaign's batches are due one after the other
+	set(t, &email.CampaignInterval, 0)
+
+	tests := []struct {
+		name        string
+		subscribers int
+		batchSize   int
+		wantBatches int
+	}{
+		{"one batch", 2, 5, 1},
+		{"last batch partly full", 3, 2, 2},
+		{"last batch empty", 4, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set(t, &email.CampaignBatchSize, tt.batchSize)
+			ctx := context.Background()
+			lists, mail, transport := newTestLists(t)
+			var addresses []string
+			for i := range tt.subscribers {
+				address := fmt.Sprintf("reader%d@example.com", i)
+				subscribe(t, lists, mail, transport, address, true)
+				addresses = append(addresses, address)
+			}
+			subscribe(t, lists, mail, transport, "pending@example.com", false)
+			subscribe(t, lists, mail, transport, "gone@example.com", true)
+			gone, err := lists.Subscribers("news", email.SubscriberSubscribed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := lists.Unsubscribe(gone[len(gone)-1].Token, 0); err != nil {
+				t.Fatal(err)
+			}
+			transport.Reset()
+
+			campaign, err := lists.CreateCampaign("news", "This week", "", `<p>Hi {{.Email}}</p><a href="{{.UnsubscribeURL}}">Unsubscribe</a>`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := lists.StartCampaign(campaign.ID); err != nil {
+				t.Fatal(err)
+			}
+			batches := 0
+			for campaign.Status != email.CampaignSent && batches < 10 {
+				lists.SendBatches(ctx)
+				batches++
+				if campaign, err = lists.GetCampaign(campaign.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if batches != tt.wantBatches {
+				t.Fatalf("campaign took %d batches; want %d", batches, tt.wantBatches)
+			}
+			for mail.SendDue(ctx) {
+			}
+
+			transport.AssertCount(t, tt.subscribers)
+			transport.AssertNotSent(t, "pending@example.com")
+			transport.AssertNotSent(t, "gone@example.com")
+			for _, address := range addresses {
+				message := transport.AssertSent(t, address)
+				if !strings.Contains(message.HTML(), "Hi "+address) {
+					t.Errorf("email to %s = %q; want it addressed to them", address, message.HTML())
+				}
+				if got := message.Header("List-Id"); got != "News <news.example.com>" {
+					t.Errorf("List-Id = %q; want News <news.example.com>", got)
+				}
+				unsubscribe := message.Header("List-Unsubscribe")
+				if !strings.Contains(unsubscribe, "?campaign="+strconv.Itoa(campaign.ID)) || message.Header("List-Unsubscribe-Post") == "" {
+					t.Errorf("List-Unsubscribe = %q; want a one-click link crediting the campaign", unsubscribe)
+				}
+			}
+			stats, err := lists.CampaignStats(campaign.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (email.CampaignStats{Recipients: tt.subscribers, Sent: tt.subscribers}); stats != want {
+				t.Fatalf("stats = %+v; want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func TestCampaignStats(t *testing.T) {
+	set(t, &email.OutboxMaxAttempts, 1)
+	ctx := context.Background()
+	lists, mail, transport := newTestLists(t)
+	for _, address := range []string{"ann@example.com", "bob@example.com", "cy@example.com"} {
+		subscribe(t, lists, mail, transport, address, true)
+	}
+	campaign, err := lists.CreateCampaign("news", "This week", "", `<a href="{{.UnsubscribeURL}}">Unsubscribe</a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lists.StartCampaign(campaign.ID); err != nil {
+		t.Fatal(err)
+	}
+	lists.SendBatches(ctx)
+	transport.Fail(errors.New("connection refused"))
+	mail.SendDue(ctx)
+	transport.Fail(nil)
+
+	// one email is retried, and one reader unsubscribes through the campaign's link
+	dead, err := mail.ListOutbox(email.OutboxDead, 10)
+	if err != nil || len(dead) != 3 {
+		t.Fatalf("dead messages = %d, %v; want 3", len(dead), err)
+	}
+	if err := mail.RetryOutbox(dead[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	mail.SendDue(ctx)
+	subscribers, err := lists.Subscribers("news", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lists.Unsubscribe(subscribers[0].Token, campaign.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := lists.CampaignStats(campaign.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (email.CampaignStats{Recipients: 3, Sent: 1, Failed: 2, Unsubscribed: 1}); stats != want {
+		t.Fatalf("stats = %+v; want %+v", stats, want)
+	}
+}
+
+func TestStartCampaign(t *testing.T) {
+	lists, _, _ := newTestLists(t)
+	campaign, err := lists.CreateCampaign("news", "This week", "", "<p>News</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		id   int
+		want error
+	}{
+		{"draft", campaign.ID, nil},
+		{"already started", campaign.ID, email.ErrCampaignStarted},
+		{"missing", campaign.ID + 1, email.ErrNoCampaign},
+	}
+	for _, tt := range tests {
+		if err := lists.StartCampaign(tt.id); !errors.Is(err, tt.want) {
+			t.Errorf("%s: StartCampaign = %v; want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := lists.CreateCampaign("news", "No body", "", ""); err == nil {
+		t.Error("CreateCampaign allowed a campaign without a template or body")
+	}
+	if _, err := lists.CreateCampaign("olds", "This week", "", "<p>News</p>"); !errors.Is(err, email.ErrNoList) {
+		t.Errorf("CreateCampaign on a missing list = %v; want ErrNoList", err)
+	}
+}
@@ -0,0 +1,272 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// DKIMHeaders are the headers signed when they're in a message. The
+// List-Unsubscribe ones must be signed for one-click unsubscribe to be trusted.
+var DKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID", "MIME-Version",
+	"Content-Type", "Content-Transfer-Encoding", "List-Id", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIMKeyBits is the size of the RSA keys made by GenerateDKIMKey
+var DKIMKeyBits = 2048
+
+// DKIMSigner adds a DKIM-Signature to outgoing mail so receivers can check it
+// came from the domain, using relaxed canonicalization
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	// Key is an *rsa.PrivateKey or ed25519.PrivateKey
+	Key crypto.Signer
+}
+
+// NewDKIMSigner reads a PEM private key, PKCS #1 or PKCS #8, from keyPath
+func NewDKIMSigner(domain, selector, keyPath string) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("email: DKIM needs a domain and selector")
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("email: no PEM key in %s", keyPath)
+	}
+	var key any
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("email: DKIM key %s: %w", keyPath, err)
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return &DKIMSigner{Domain: domain, Selector: selector, Key: key}, nil
+	case ed25519.PrivateKey:
+		return &DKIMSigner{Domain: domain, Selector: selector, Key: key}, nil
+	}
+	return nil, fmt.Errorf("email: DKIM key %s isn't RSA or Ed25519", keyPath)
+}
+
+// DKIMFromEnv returns the signer configured by MAIL_DKIM_SELECTOR and MAIL_DKIM_KEY,
+// the key's path, in config.env, or nil when they aren't set. MAIL_DKIM_DOMAIN
+// defaults to the domain of MAIL_USER_EMAIL.
+func DKIMFromEnv() (*DKIMSigner, error) {
+	selector := helpers.GetenvDefault("MAIL_DKIM_SELECTOR", "")
+	keyPath := helpers.GetenvDefault("MAIL_DKIM_KEY", "")
+	if selector == "" || keyPath == "" {
+		return nil, nil
+	}
+	domain := helpers.GetenvDefault("MAIL_DKIM_DOMAIN", "")
+	if domain == "" {
+		_, domain, _ = strings.Cut(helpers.Getenv("MAIL_USER_EMAIL"), "@")
+	}
+	return NewDKIMSigner(domain, selector, keyPath)
+}
+
+var (
+	defaultDKIM     *DKIMSigner
+	defaultDKIMOnce sync.Once
+)
+
+// dkimDefault is the signer from config.env, loaded once
+func dkimDefault() *DKIMSigner {
+	defaultDKIMOnce.Do(func() {
+		var err error
+		if defaultDKIM, err = DKIMFromEnv(); err != nil {
+			log.Errorf("DKIM signing disabled: %v", err)
+		}
+	})
+	return defaultDKIM
+}
+
+// GenerateDKIMKey writes a new RSA private key to keyPath as PKCS #8 PEM,
+// refusing to replace an existing one
+func GenerateDKIMKey(keyPath string) error {
+	key, err := rsa.GenerateKey(rand.Reader, DKIMKeyBits)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// RecordName is the DNS name of the signer's TXT record, e.g. mail._domainkey.example.com
+func (s *DKIMSigner) RecordName() string {
+	return s.Selector + "._domainkey." + s.Domain
+}
+
+// RecordValue is the content of the signer's TXT record with its public key
+func (s *DKIMSigner) RecordValue() (string, error) {
+	switch public := s.Key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public), nil
+	}
+	return "", errors.New("email: DKIM key isn't RSA or Ed25519")
+}
+
+// ZoneRecord is the TXT record as a zone file line, with the value split into
+// the 255 character strings DNS allows
+func (s *DKIMSigner) ZoneRecord() (string, error) {
+	value, err := s.RecordValue()
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, strconv.Quote(value[:255]))
+		value = value[255:]
+	}
+	parts = append(parts, strconv.Quote(value))
+	return s.RecordName() + ". IN TXT ( " + strings.Join(parts, " ") + " )", nil
+}
+
+var (
+	wsp          = regexp.MustCompile(`[ \t]+`)
+	trailingCRLF = regexp.MustCompile(`(\r\n)+$`)
+)
+
+// Sign returns the message with a DKIM-Signature header in front. Line endings
+// are normalized to CRLF, which the signature covers.
+func (s *DKIMSigner) Sign(raw []byte) ([]byte, error) {
+	raw = bytes.ReplaceAll(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	header, body, ok := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !ok {
+		header, body = bytes.TrimSuffix(raw, []byte("\r\n")), nil
+	}
+	fields := splitHeader(string(header))
+
+	algorithm := "rsa-sha256"
+	if _, ok := s.Key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+	bodyHash := sha256.Sum256([]byte(relaxedBody(string(body))))
+
+	// every instance of a header is signed, from the bottom up as verifiers read them
+	hash := sha256.New()
+	var signed []string
+	for _, name := range DKIMHeaders {
+		key := strings.ToLower(name)
+		for i := len(fields) - 1; i >= 0; i-- {
+			fieldName, _, _ := strings.Cut(fields[i], ":")
+			if strings.ToLower(strings.TrimSpace(fieldName)) == key {
+				hash.Write([]byte(relaxedHeader(fields[i]) + "\r\n"))
+				signed = append(signed, key)
+			}
+		}
+	}
+
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=relaxed/relaxed",
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+		"t=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"h=" + strings.Join(signed, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+	// the signature covers its own header with an empty b=
+	hash.Write([]byte(relaxedHeader("DKIM-Signature: " + strings.Join(tags, "; "))))
+	digest := hash.Sum(nil)
+
+	var signature []byte
+	var err error
+	switch key := s.Key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, digest)
+	default:
+		signature, err = s.Key.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("DKIM-Signature: " + strings.Join(tags, ";\r\n\t"))
+	b := base64.StdEncoding.EncodeToString(signature)
+	for len(b) > 72 {
+		out.WriteString(b[:72] + "\r\n\t")
+		b = b[72:]
+	}
+	out.WriteString(b + "\r\n")
+	out.Write(raw)
+	return out.Bytes(), nil
+}
+
+// splitHeader returns the header's fields, each with its folded lines
+func splitHeader(header string) []string {
+	var fields []string
+	for _, line := range strings.Split(header, "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// relaxedHeader canonicalizes a header field as in RFC 6376 3.4.2
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(wsp.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// relaxedBody canonicalizes a body as in RFC 6376 3.4.4
+func relaxedBody(body string) string {
+	lines := strings.Split(body, "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(wsp.ReplaceAllString(line, " "), " ")
+	}
+	body = trailingCRLF.ReplaceAllString(strings.Join(lines, "\r\n"), "")
+	if body == "" {
+		return ""
+	}
+	return body + "\r\n"
+}
@@ -0,0 +1,192 @@
+package email_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/joashgobin/boiler/email"
+)
+
+func TestRelaxedHeader(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		// the examples of RFC 6376 3.4.5
+		{"A: X", "a:X"},
+		{"B : Y\t\r\n\tZ  ", "b:Y Z"},
+		{"Subject:   Hello \t  there  ", "subject:Hello there"},
+		{"To:", "to:"},
+	}
+	for _, tt := range tests {
+		if got := email.RelaxedHeader(tt.field); got != tt.want {
+			t.Errorf("RelaxedHeader(%q) = %q; want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		// the example of RFC 6376 3.4.5
+		{" C \r\nD \t E\r\n\r\n\r\n", " C\r\nD E\r\n"},
+		{"no newline", "no newline\r\n"},
+		{"", ""},
+		{"\r\n\r\n", ""},
+		{"kept\r\n\r\nblank line\r\n", "kept\r\n\r\nblank line\r\n"},
+	}
+	for _, tt := range tests {
+		if got := email.RelaxedBody(tt.body); got != tt.want {
+			t.Errorf("RelaxedBody(%q) = %q; want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+var (
+	errBodyHash = errors.New("body hash mismatch")
+	errDKIM     = errors.New("signature mismatch")
+	dkimB       = regexp.MustCompile(`(^|[;\s])b=[^;]*`)
+)
+
+// verifyDKIM checks the DKIM-Signature at the top of a message the way a receiver does
+func verifyDKIM(signed []byte, public crypto.PublicKey) error {
+	header, body, _ := bytes.Cut(signed, []byte("\r\n\r\n"))
+	var fields []string
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	signature, fields := fields[0], fields[1:]
+	_, value, _ := strings.Cut(signature, ":")
+	tags := map[string]string{}
+	for _, tag := range strings.Split(value, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+		tags[name] = strings.Join(strings.Fields(value), "")
+	}
+
+	bodyHash := sha256.Sum256([]byte(email.RelaxedBody(string(body))))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return errBodyHash
+	}
+
+	// each name in h= takes the next instance of the header from the bottom
+	hash := sha256.New()
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i >= 0; i-- {
+			fieldName, _, _ := strings.Cut(fields[i], ":")
+			if !used[i] && strings.EqualFold(strings.TrimSpace(fieldName), name) {
+				hash.Write([]byte(email.RelaxedHeader(fields[i]) + "\r\n"))
+				used[i] = true
+				break
+			}
+		}
+	}
+	hash.Write([]byte(email.RelaxedHeader(dkimB.ReplaceAllString(signature, "${1}b="))))
+	digest := hash.Sum(nil)
+
+	b, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(public, crypto.SHA256, digest, b) != nil {
+			return errDKIM
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(public, digest, b) {
+			return errDKIM
+		}
+	}
+	return nil
+}
+
+// replace returns a tamper function replacing old with new in the signed message
+func replace(old, new string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		return bytes.Replace(signed, []byte(old), []byte(new), 1)
+	}
+}
+
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written with bare newlines, which Sign turns into CRLF
+	raw := "From: Boiler <boiler@example.com>\nTo: jane@example.com\nSubject:  Hello   there\n" +
+		"X-Unsigned: anything\n\nHi Jane,  \n\nSee you soon.\n\n\n"
+
+	tests := []struct {
+		name   string
+		tamper func(signed []byte) []byte
+		want   error
+	}{
+		{name: "as signed"},
+		{
+			name:   "refolded header",
+			tamper: replace("Subject:  Hello   there", "Subject: Hello\r\n  there"),
+		},
+		{
+			name:   "trailing blank lines",
+			tamper: func(signed []byte) []byte { return append(signed, "\r\n\r\n"...) },
+		},
+		{
+			name:   "unsigned header changed",
+			tamper: replace("X-Unsigned: anything", "X-Unsigned: something else"),
+		},
+		{
+			name:   "body changed",
+			tamper: replace("See you soon.", "See you never."),
+			want:   errBodyHash,
+		},
+		{
+			name:   "subject changed",
+			tamper: replace("Hello   there", "Goodbye"),
+			want:   errDKIM,
+		},
+		{
+			name: "header added",
+			tamper: func(signed []byte) []byte {
+				return bytes.Replace(signed, []byte("\r\n\r\n"), []byte("\r\nSubject: Second subject\r\n\r\n"), 1)
+			},
+			want: errDKIM,
+		},
+	}
+	for algorithm, key := range map[string]crypto.Signer{"rsa": rsaKey, "ed25519": edKey} {
+		signer := &email.DKIMSigner{Domain: "example.com", Selector: "mail", Key: key}
+		for _, tt := range tests {
+			t.Run(algorithm+" "+tt.name, func(t *testing.T) {
+				signed, err := signer.Sign([]byte(raw))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.tamper != nil {
+					signed = tt.tamper(signed)
+				}
+				if err := verifyDKIM(signed, key.Public()); !errors.Is(err, tt.want) {
+					t.Fatalf("verifyDKIM = %v; want %v\n%s", err, tt.want, signed)
+				}
+			})
+		}
+	}
+}
@@ -2,23 +2,29 @@ package email
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/wneessen/go-mail"
-
 	ht "html/template"
 
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
 	"github.com/joashgobin/boiler/helpers"
 )
 
 //go:embed templates/*
 var templatesFS embed.FS
 
+// MagicLinkTTL is how long links from GetMagicLink remain usable
+var MagicLinkTTL = 24 * time.Hour
+
+var ErrInvalidMagicLink = errors.New("email: invalid, used or expired magic link")
+
 type emailData struct {
 	Subject string
 	Body    string
@@ -26,7 +32,26 @@ type emailData struct {
 
 type MailModel struct {
 	DB        *sql.DB
+	Dialect   dialect.Dialect
 	WaitGroup *sync.WaitGroup
+	// Transport delivers the queued emails, an SMTPTransport from config.env when nil
+	Transport Transport
+	// DKIM signs every email as it's sent, the signer from config.env when nil
+	DKIM *DKIMSigner
+	// Audit records every magic link that is used when set
+	Audit helpers.AuditFunc
+	// wake nudges the outbox worker when a message is queued
+	wake chan struct{}
+	// templates are the email templates by name, see LoadTemplates
+	templates map[string]*ht.Template
+}
+
+// audit action for a magic link that was used up
+const AuditMagicLinkUse = "magiclink.use"
+
+// rebind adapts a query to the model's dialect, MySQL by default
+func (m *MailModel) rebind(query string) string {
+	return dialect.Or(m.Dialect).Rebind(query)
 }
 
 type MagicLink struct {
@@ -38,29 +63,30 @@ type MagicLink struct {
 	Used    bool
 }
 
+// NewMailModel returns a mail model; the magiclinks and email_outbox tables are created by the core migrations
 func NewMailModel(db *sql.DB, wg *sync.WaitGroup, appName string) *MailModel {
-	helpers.MigrateUp(db, `
-	USE <appName>;
-
-CREATE TABLE IF NOT EXISTS magiclinks (
-    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    email VARCHAR(100) NOT NULL,
-    purpose VARCHAR(100) NOT NULL,
-    value VARCHAR(200) NOT NULL UNIQUE,
-    result VARCHAR(200) NOT NULL,
-	used BOOLEAN
-);
-`, map[string]string{"appName": appName})
-	return &MailModel{DB: db, WaitGroup: wg}
+	return &MailModel{DB: db, WaitGroup: wg, wake: make(chan struct{}, 1)}
 }
 
 type MailInterface interface {
 	Send(to, bcc, subject string, swaps ...any)
+	SendTemplate(to, name string, data any) error
+	QueueTemplate(to, bcc, name string, data any) (int64, error)
 	NotifyAdmin(subject string, swaps ...any)
 	GetMagicLink(email, purpose, urlPrefix string) string
+	GetExpiringMagicLink(email, purpose, urlPrefix string, ttl time.Duration) string
 	GetMagicLinks() []MagicLink
+	ListMagicLinks(limit int) ([]MagicLink, error)
 	IsMagicLinkValid(link string) bool
+	CheckMagicLink(value, purpose string) (string, error)
+	UseMagicLink(value, purpose string) (string, error)
 	SetMagicLinkResult(value, result string) string
+	Deliver(ctx context.Context, message Message) error
+	QueueMessage(message Message) (int64, error)
+	Queue(to, bcc, subject string, swaps ...any) (int64, error)
+	OutboxStatus(id int64) (OutboxMessage, error)
+	ListOutbox(status string, limit int) ([]OutboxMessage, error)
+	RetryOutbox(id int64) error
 }
 
 func (m *MailModel) GetMagicLinks() []MagicLink {
@@ -69,7 +95,7 @@ func (m *MailModel) GetMagicLinks() []MagicLink {
 	SELECT id,email,purpose,value,result,used
 	FROM magiclinks
 	`
-	rows, err := m.DB.Query(query)
+	rows, err := m.DB.Query(m.rebind(query))
 	if err != nil {
 		log.Errorf("magic links query error: %v", err)
 		return nil
@@ -86,11 +112,32 @@ func (m *MailModel) GetMagicLinks() []MagicLink {
 	return links
 }
 
+// ListMagicLinks returns the latest magic links, newest first
+func (m *MailModel) ListMagicLinks(limit int) ([]MagicLink, error) {
+	query := `SELECT id, email, purpose, value, result, used
+	FROM magiclinks ORDER BY id DESC LIMIT ?`
+	rows, err := m.DB.Query(m.rebind(query), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []MagicLink
+	for rows.Next() {
+		var link MagicLink
+		if err := rows.Scan(&link.ID, &link.Email, &link.Purpose, &link.Value, &link.Result, &link.Used); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
 func (m *MailModel) SetMagicLinkResult(value, result string) string {
 	updateQuery := `
 	UPDATE magiclinks SET result = ? WHERE value = ?
 	`
-	stmt, err := m.DB.Prepare(updateQuery)
+	stmt, err := m.DB.Prepare(m.rebind(updateQuery))
 	if err != nil {
 		log.Errorf("prepare statement error: %v", err)
 		return ""
@@ -107,9 +154,9 @@ func (m *MailModel) SetMagicLinkResult(value, result string) string {
 	}
 
 	var userEmail string
-	err = m.DB.QueryRow(`
+	err = m.DB.QueryRow(m.rebind(`
 	SELECT email FROM magiclinks WHERE value = ?
-	`, value).Scan(&userEmail)
+	`), value).Scan(&userEmail)
 	if err != nil {
 		return ""
 	}
@@ -119,16 +166,17 @@ func (m *MailModel) SetMagicLinkResult(value, result string) string {
 func (m *MailModel) IsMagicLinkValid(link string) bool {
 	// log.Infof("verifying magic link: %s", link)
 	updateQuery := `
-	UPDATE magiclinks SET used = ? WHERE value = ?
+	UPDATE magiclinks SET used = ?
+	WHERE value = ? AND used = ? AND (expires_at IS NULL OR expires_at > ?)
 	`
-	stmt, err := m.DB.Prepare(updateQuery)
+	stmt, err := m.DB.Prepare(m.rebind(updateQuery))
 	if err != nil {
 		log.Errorf("prepare statement error: %v", err)
 		return false
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(true, link)
+	result, err := stmt.Exec(true, link, false, dialect.Time(m.Dialect, time.Now()))
 	if err != nil {
 		log.Errorf("execute error: %v", err)
 		return false
@@ -141,15 +189,70 @@ func (m *MailModel) IsMagicLinkValid(link string) bool {
 	if rowsAffected == 0 {
 		return false
 	}
+	if m.Audit != nil {
+		var userEmail, purpose string
+		err := m.DB.QueryRow(m.rebind(`SELECT email, purpose FROM magiclinks WHERE value = ?`), link).Scan(&userEmail, &purpose)
+		if err == nil {
+			m.Audit.Emit(userEmail, AuditMagicLinkUse, userEmail, map[string]any{"purpose": purpose})
+		}
+	}
 	return true
 }
 
+// CheckMagicLink returns the email a link was issued to without using it up
+func (m *MailModel) CheckMagicLink(value, purpose string) (string, error) {
+	query := `
+	SELECT email FROM magiclinks
+	WHERE value = ? AND purpose = ? AND used = ? AND (expires_at IS NULL OR expires_at > ?)
+	`
+	var userEmail string
+	err := m.DB.QueryRow(m.rebind(query), value, purpose, false, dialect.Time(m.Dialect, time.Now())).Scan(&userEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidMagicLink
+	}
+	return userEmail, err
+}
+
+// UseMagicLink marks a link as used and returns the email it was issued to,
+// failing with ErrInvalidMagicLink if it was already used or has expired
+func (m *MailModel) UseMagicLink(value, purpose string) (string, error) {
+	userEmail, err := m.CheckMagicLink(value, purpose)
+	if err != nil {
+		return "", err
+	}
+	updateQuery := `
+	UPDATE magiclinks SET used = ?
+	WHERE value = ? AND purpose = ? AND used = ?
+	`
+	result, err := m.DB.Exec(m.rebind(updateQuery), true, value, purpose, false)
+	if err != nil {
+		return "", err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	// another request used the link in the meantime
+	if rowsAffected == 0 {
+		return "", ErrInvalidMagicLink
+	}
+	m.Audit.Emit(userEmail, AuditMagicLinkUse, userEmail, map[string]any{"purpose": purpose})
+	return userEmail, nil
+}
+
+// GetMagicLink returns a single-use link valid for MagicLinkTTL
 func (m *MailModel) GetMagicLink(email, purpose, urlPrefix string) string {
+	return m.GetExpiringMagicLink(email, purpose, urlPrefix, MagicLinkTTL)
+}
+
+// GetExpiringMagicLink returns a single-use link valid for the given duration
+func (m *MailModel) GetExpiringMagicLink(email, purpose, urlPrefix string, ttl time.Duration) string {
 	value := purpose + "_" + helpers.GetHash(email+purpose+time.Now().Format(time.RFC3339)) + "-" + helpers.GetRandomUUID()
 	query := `
-	INSERT INTO magiclinks(email,purpose,value,used,result) VALUES (?,?,?,?,?)
+	INSERT INTO magiclinks(email,purpose,value,used,result,created,expires_at) VALUES (?,?,?,?,?,?,?)
 	`
-	_, err := m.DB.Exec(query, email, purpose, value, false, "")
+	now := time.Now()
+	_, err := m.DB.Exec(m.rebind(query), email, purpose, value, false, "", dialect.Time(m.Dialect, now), dialect.Time(m.Dialect, now.Add(ttl)))
 	if err != nil {
 		log.Errorf("magic link generation error: %v", err)
 		return urlPrefix
@@ -158,74 +261,60 @@ func (m *MailModel) GetMagicLink(email, purpose, urlPrefix string) string {
 	return urlPrefix + value
 }
 
+// NotifyAdmin queues an email to ADMIN_EMAIL, see Send
 func (m *MailModel) NotifyAdmin(subject string, swaps ...any) {
-	body := ""
-	if len(swaps) > 1 {
-		body = fmt.Sprintf(swaps[0].(string), swaps[1:]...)
-	} else {
-		body = swaps[0].(string)
-	}
-	SendEmail(helpers.Getenv("ADMIN_EMAIL"), subject, body, "", m.WaitGroup)
+	m.Send(helpers.Getenv("ADMIN_EMAIL"), "", subject, swaps...)
 }
 
+// Send queues an email whose body is swaps[0] formatted with the rest of swaps,
+// logging rather than returning errors. Use Queue to follow its delivery.
 func (m *MailModel) Send(to, bcc, subject string, swaps ...any) {
-	body := ""
-	if len(swaps) > 1 {
-		body = fmt.Sprintf(swaps[0].(string), swaps[1:]...)
-	} else {
-		body = swaps[0].(string)
+	if _, err := m.Queue(to, bcc, subject, swaps...); err != nil {
+		log.Errorf("failed to queue mail to %s: %v", to, err)
 	}
-	SendEmail(to, subject, body, bcc, m.WaitGroup)
 }
 
+// SendEmail sends an email in the background without the outbox, so it is lost
+// if the mail server is down.
+//
+// Deprecated: use MailModel.Send, which retries until the email is delivered.
 func SendEmail(to string, subject string, body string, bcc string, wg *sync.WaitGroup) {
 	helpers.Background(
 		func() {
-			data := emailData{Subject: subject, Body: body}
-			var senderAddr string = helpers.Getenv("MAIL_USER_EMAIL")
-			var senderName string = helpers.Getenv("MAIL_USERNAME")
-			username := senderAddr
-			password := helpers.Getenv("MAIL_PW")
-			mailHost := helpers.Getenv("MAIL_HOST")
-
-			message := mail.NewMsg()
-			if err := message.FromFormat(senderName, senderAddr); err != nil {
-				log.Infof("failed to set 'from' address: %s", senderAddr)
-			}
-			if err := message.To(to); err != nil {
-				log.Infof("failed to set 'to' address: %s", to)
-			}
-			message.Subject(subject)
-			message.SetBodyString(mail.TypeTextPlain, body)
-			htmlTmpl, err := ht.New("").Funcs(ht.FuncMap{
-				"safeHTML": func(s string) ht.HTML {
-					return ht.HTML(s)
-				},
-			}).ParseFS(templatesFS, "templates/email.html")
+			from, recipients, raw, err := buildMessage(to, bcc, subject, body)
 			if err != nil {
-				log.Infof("could not parse email template: %v", err)
+				log.Errorf("failed to build mail: %v", err)
 				return
 			}
-			htmlBody := new(bytes.Buffer)
-			err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
-			if err != nil {
-				log.Infof("could not parse email template: %v", err)
-				return
+			if err := sendSigned(context.Background(), smtpDefault(), dkimDefault(), from, recipients, raw); err != nil {
+				log.Errorf("failed to send mail: %v", err)
 			}
-			message.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
-			message.AddBcc(bcc)
+		}, wg)
+}
 
-			client, err := mail.NewClient(mailHost, mail.WithTLSPortPolicy(mail.TLSMandatory),
-				mail.WithSMTPAuth(mail.SMTPAuthPlain), mail.WithUsername(username), mail.WithPassword(password))
-			if err != nil {
-				log.Infof("failed to create mail client: %s\n", err)
-			}
+// formatBody is swaps[0] formatted with the rest of swaps
+func formatBody(swaps []any) string {
+	if len(swaps) == 0 {
+		return ""
+	}
+	format, _ := swaps[0].(string)
+	if len(swaps) > 1 {
+		return fmt.Sprintf(format, swaps[1:]...)
+	}
+	return format
+}
 
-			if err := client.DialAndSend(message); err != nil {
-				log.Infof("failed to send mail: %s", err)
-			}
+var htmlTemplate = ht.Must(ht.New("").Funcs(ht.FuncMap{
+	"safeHTML": func(s string) ht.HTML {
+		return ht.HTML(s)
+	},
+}).ParseFS(templatesFS, "templates/email.html"))
 
-			// helpers.WasteTime(5)
-			// log.Infof("Sending email\n'%s'\n to: %s", message, to)
-		}, wg)
+// buildMessage renders an email with the body in templates/email.html
+func buildMessage(to, bcc, subject, body string) (string, []string, []byte, error) {
+	htmlBody := new(bytes.Buffer)
+	if err := htmlTemplate.ExecuteTemplate(htmlBody, "htmlBody", emailData{Subject: subject, Body: body}); err != nil {
+		return "", nil, nil, err
+	}
+	return NewMessage().To(to).Bcc(bcc).Subject(subject).Text(body).HTML(htmlBody.String()).render()
 }
@@ -0,0 +1,83 @@
+package email_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/joashgobin/boiler/core/models"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/email"
+	"github.com/joashgobin/boiler/migrate"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/spf13/viper"
+)
+
+var testDialect = dialect.SQLite{Driver: "sqlite3"}
+
+func TestMain(m *testing.M) {
+	// the sender of queued emails, normally read from config.env
+	viper.Set("MAIL_USERNAME", "Boiler")
+	viper.Set("MAIL_USER_EMAIL", "boiler@example.com")
+	viper.Set("ADMIN_EMAIL", "admin@example.com")
+	os.Exit(m.Run())
+}
+
+// newTestMail returns a MailModel on a SQLite database in a temporary directory
+// with the boiler migrations applied, delivering through the returned transport
+func newTestMail(t *testing.T) (*email.MailModel, *email.MemoryTransport) {
+	t.Helper()
+	db, err := sql.Open(testDialect.DriverName(), testDialect.DSN(t.TempDir(), "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	runner, err := migrate.New(db, testDialect, "boiler", os.DirFS("../core"), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runner.Register(12, "backfill_user_roles", models.BackfillUserRoles, models.RestoreRolesColumn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	transport := &email.MemoryTransport{}
+	return &email.MailModel{DB: db, Dialect: testDialect, Transport: transport}, transport
+}
+
+func TestListMagicLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		links int
+		limit int
+		want  int
+	}{
+		{"fewer than the limit", 2, 5, 2},
+		{"capped at the limit", 5, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail, _ := newTestMail(t)
+			var values []string
+			for range tt.links {
+				link := mail.GetMagicLink("jane@example.com", "test", "http://localhost:8080/magic/")
+				values = append(values, strings.TrimPrefix(link, "http://localhost:8080/magic/"))
+			}
+			links, err := mail.ListMagicLinks(tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(links) != tt.want {
+				t.Fatalf("listed %d links; want %d", len(links), tt.want)
+			}
+			// newest first
+			if links[0].Value != values[len(values)-1] {
+				t.Fatalf("first link = %q; want the newest %q", links[0].Value, values[len(values)-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package email
+
+import "context"
+
+// the worker steps and canonicalization, exported for the email_test package
+
+var (
+	RetryDelay    = retryDelay
+	Truncate      = truncate
+	RelaxedHeader = relaxedHeader
+	RelaxedBody   = relaxedBody
+)
+
+func (m *MailModel) SendDue(ctx context.Context) bool {
+	return m.sendDue(ctx)
+}
+
+func (m *ListModel) SendBatches(ctx context.Context) {
+	m.sendBatches(ctx)
+}
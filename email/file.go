@@ -0,0 +1,141 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileTransport writes every message to a file in Dir instead of sending it,
+// for reading development mail with an email client
+type FileTransport struct {
+	Dir string
+	// Maildir delivers into Dir/new by way of Dir/tmp, so a maildir client such as
+	// mutt can open Dir. Otherwise each message is written as Dir/<name>.eml.
+	Maildir bool
+}
+
+var _ Capturer = (*FileTransport)(nil)
+
+// files written by this process, to keep names unique within a second
+var fileCount atomic.Int64
+
+// envelope headers put in front of the message, as the envelope isn't part of it
+const (
+	headerEnvelopeFrom = "Return-Path"
+	headerEnvelopeTo   = "X-Envelope-To"
+)
+
+func (t *FileTransport) Send(ctx context.Context, from string, to []string, raw []byte) error {
+	host, _ := os.Hostname()
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), fileCount.Add(1),
+		strings.NewReplacer("/", "_", ":", "_").Replace(host))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s: <%s>\r\n%s: %s\r\n", headerEnvelopeFrom, from, headerEnvelopeTo, strings.Join(to, ", "))
+	b.Write(raw)
+
+	if !t.Maildir {
+		if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(t.Dir, name+".eml"), b.Bytes(), 0o644)
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.Dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.Join(t.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.Dir, "new", name))
+}
+
+// Messages reads back the messages in Dir, newest first
+func (t *FileTransport) Messages() []CapturedMessage {
+	var messages []CapturedMessage
+	for _, path := range t.files() {
+		message, err := readMessageFile(path)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	slices.SortStableFunc(messages, func(a, b CapturedMessage) int {
+		return b.Sent.Compare(a.Sent)
+	})
+	return messages
+}
+
+// Reset deletes the messages in Dir
+func (t *FileTransport) Reset() error {
+	for _, path := range t.files() {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *FileTransport) files() []string {
+	var paths []string
+	if t.Maildir {
+		for _, sub := range []string{"new", "cur"} {
+			entries, _ := os.ReadDir(filepath.Join(t.Dir, sub))
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					paths = append(paths, filepath.Join(t.Dir, sub, entry.Name()))
+				}
+			}
+		}
+		return paths
+	}
+	paths, _ = filepath.Glob(filepath.Join(t.Dir, "*.eml"))
+	return paths
+}
+
+// readMessageFile splits the envelope headers written by Send off a message file
+func readMessageFile(path string) (CapturedMessage, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return CapturedMessage{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return CapturedMessage{}, err
+	}
+	message := CapturedMessage{
+		ID:   strings.TrimSuffix(filepath.Base(path), ".eml"),
+		Raw:  b,
+		Sent: info.ModTime(),
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	for range 2 {
+		line, err := reader.ReadLine()
+		if err != nil {
+			break
+		}
+		key, value, _ := strings.Cut(line, ": ")
+		switch key {
+		case headerEnvelopeFrom:
+			message.From = strings.Trim(value, "<>")
+			message.Raw = message.Raw[len(line)+2:]
+		case headerEnvelopeTo:
+			message.To = strings.Split(value, ", ")
+			message.Raw = message.Raw[len(line)+2:]
+		}
+	}
+	return message, nil
+}
@@ -0,0 +1,227 @@
+package email
+
+import (
+	"html"
+	"strings"
+)
+
+// a minimal HTML tokenizer for the email's own markup, enough to inline CSS and
+// derive the plain text part without depending on a full HTML parser
+
+type tokenKind int
+
+const (
+	textToken tokenKind = iota
+	startTagToken
+	endTagToken
+	commentToken
+	// doctypes and processing instructions
+	otherToken
+)
+
+type htmlAttr struct {
+	Key string
+	// Value is unescaped
+	Value string
+}
+
+type htmlToken struct {
+	Kind tokenKind
+	// Raw is the token as it appears in the document
+	Raw string
+	// Name is the lower case tag name
+	Name        string
+	Attrs       []htmlAttr
+	SelfClosing bool
+}
+
+func (t htmlToken) attr(key string) (string, bool) {
+	for _, attr := range t.Attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+// setAttr replaces or adds an attribute and rebuilds Raw
+func (t *htmlToken) setAttr(key, value string) {
+	found := false
+	for i := range t.Attrs {
+		if t.Attrs[i].Key == key {
+			t.Attrs[i].Value = value
+			found = true
+		}
+	}
+	if !found {
+		t.Attrs = append(t.Attrs, htmlAttr{Key: key, Value: value})
+	}
+
+	var b strings.Builder
+	b.WriteString("<" + t.Name)
+	for _, attr := range t.Attrs {
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Value) + `"`)
+	}
+	if t.SelfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	t.Raw = b.String()
+}
+
+// elements without an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// elements whose content is text up to their end tag
+var rawTextElements = map[string]bool{"style": true, "script": true, "title": true, "textarea": true}
+
+func isVoid(t htmlToken) bool {
+	return t.SelfClosing || voidElements[t.Name]
+}
+
+func tokenizeHTML(s string) []htmlToken {
+	var tokens []htmlToken
+	text := 0
+	flush := func(end int) {
+		if end > text {
+			tokens = append(tokens, htmlToken{Kind: textToken, Raw: s[text:end]})
+		}
+	}
+	for i := 0; i < len(s); {
+		if s[i] != '<' || i+1 >= len(s) {
+			i++
+			continue
+		}
+		next := s[i+1]
+		switch {
+		case strings.HasPrefix(s[i:], "<!--"):
+			flush(i)
+			end := strings.Index(s[i+4:], "-->")
+			if end < 0 {
+				end = len(s)
+			} else {
+				end += i + 7
+			}
+			tokens = append(tokens, htmlToken{Kind: commentToken, Raw: s[i:end]})
+			i, text = end, end
+		case next == '!' || next == '?':
+			flush(i)
+			end := tagEnd(s, i)
+			tokens = append(tokens, htmlToken{Kind: otherToken, Raw: s[i:end]})
+			i, text = end, end
+		case next == '/' && i+2 < len(s) && isLetter(s[i+2]):
+			flush(i)
+			end := tagEnd(s, i)
+			name := strings.TrimSpace(strings.TrimSuffix(s[i+2:end], ">"))
+			if j := strings.IndexAny(name, " \t\r\n"); j >= 0 {
+				name = name[:j]
+			}
+			tokens = append(tokens, htmlToken{Kind: endTagToken, Raw: s[i:end], Name: strings.ToLower(name)})
+			i, text = end, end
+		case isLetter(next):
+			flush(i)
+			token, end := parseStartTag(s, i)
+			tokens = append(tokens, token)
+			i, text = end, end
+			if rawTextElements[token.Name] && !token.SelfClosing {
+				close := strings.Index(strings.ToLower(s[i:]), "</"+token.Name)
+				if close < 0 {
+					close = len(s) - i
+				}
+				i += close
+				flush(i)
+				text = i
+			}
+		default:
+			i++
+		}
+	}
+	flush(len(s))
+	return tokens
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// tagEnd is the index after the '>' closing the tag at i
+func tagEnd(s string, i int) int {
+	end := strings.IndexByte(s[i:], '>')
+	if end < 0 {
+		return len(s)
+	}
+	return i + end + 1
+}
+
+func parseStartTag(s string, i int) (htmlToken, int) {
+	token := htmlToken{Kind: startTagToken}
+	j := i + 1
+	for j < len(s) && !strings.ContainsRune(" \t\r\n/>", rune(s[j])) {
+		j++
+	}
+	token.Name = strings.ToLower(s[i+1 : j])
+	for j < len(s) {
+		for j < len(s) && strings.ContainsRune(" \t\r\n", rune(s[j])) {
+			j++
+		}
+		if j >= len(s) {
+			break
+		}
+		if s[j] == '>' {
+			j++
+			break
+		}
+		if s[j] == '/' {
+			token.SelfClosing = j+1 < len(s) && s[j+1] == '>'
+			j++
+			continue
+		}
+		k := j
+		for k < len(s) && !strings.ContainsRune(" \t\r\n=/>", rune(s[k])) {
+			k++
+		}
+		attr := htmlAttr{Key: strings.ToLower(s[j:k])}
+		j = k
+		for j < len(s) && strings.ContainsRune(" \t\r\n", rune(s[j])) {
+			j++
+		}
+		if j < len(s) && s[j] == '=' {
+			j++
+			for j < len(s) && strings.ContainsRune(" \t\r\n", rune(s[j])) {
+				j++
+			}
+			if j < len(s) && (s[j] == '"' || s[j] == '\'') {
+				quote := s[j]
+				end := strings.IndexByte(s[j+1:], quote)
+				if end < 0 {
+					end = len(s) - j - 1
+				}
+				attr.Value = html.UnescapeString(s[j+1 : j+1+end])
+				j = min(j+end+2, len(s))
+			} else {
+				k := j
+				for k < len(s) && !strings.ContainsRune(" \t\r\n>", rune(s[k])) {
+					k++
+				}
+				attr.Value = html.UnescapeString(s[j:k])
+				j = k
+			}
+		}
+		if attr.Key != "" {
+			token.Attrs = append(token.Attrs, attr)
+		}
+	}
+	token.Raw = s[i:j]
+	return token, j
+}
+
+func renderTokens(tokens []htmlToken) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString(token.Raw)
+	}
+	return b.String()
+}
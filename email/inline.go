@@ -0,0 +1,323 @@
+package email
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// cssRule is a declaration block of a selector that can be inlined
+type cssRule struct {
+	selector     []cssCompound
+	specificity  int
+	order        int
+	declarations []cssDeclaration
+}
+
+type cssDeclaration struct {
+	Property string
+	Value    string
+}
+
+// cssCompound is a part of a selector like div.note#first, with the
+// combinator joining it to the part before it
+type cssCompound struct {
+	// child is set for the > combinator, otherwise it is a descendant
+	child   bool
+	tag     string
+	id      string
+	classes []string
+}
+
+// the element an inlined rule is matched against
+type cssElement struct {
+	tag     string
+	id      string
+	classes []string
+}
+
+var cssComments = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// InlineCSS moves the rules of the document's <style> elements into style attributes,
+// since many email clients ignore style sheets. Rules a style attribute can't
+// express, such as @media queries and :hover, stay in a <style> element.
+func InlineCSS(document string) string {
+	tokens := tokenizeHTML(document)
+
+	var css strings.Builder
+	for i, token := range tokens {
+		if token.Kind == startTagToken && token.Name == "style" && i+1 < len(tokens) && tokens[i+1].Kind == textToken {
+			css.WriteString(tokens[i+1].Raw + "\n")
+		}
+	}
+	rules, leftover := parseCSS(css.String())
+	if len(rules) == 0 {
+		return document
+	}
+
+	var out []htmlToken
+	var ancestors []cssElement
+	keptStyle := false
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch token.Kind {
+		case startTagToken:
+			if token.Name == "style" {
+				// drop the style element, keeping the leftover rules in the first one
+				for i+1 < len(tokens) && !(tokens[i+1].Kind == endTagToken && tokens[i+1].Name == "style") {
+					i++
+				}
+				i++
+				if !keptStyle && leftover != "" {
+					out = append(out, htmlToken{Kind: textToken, Raw: "<style>\n" + leftover + "</style>"})
+				}
+				keptStyle = true
+				continue
+			}
+			element := cssElement{tag: token.Name}
+			element.id, _ = token.attr("id")
+			if class, ok := token.attr("class"); ok {
+				element.classes = strings.Fields(class)
+			}
+			if style := inlineStyle(rules, element, ancestors, token); style != "" {
+				token.setAttr("style", style)
+			}
+			if !isVoid(token) {
+				ancestors = append(ancestors, element)
+			}
+		case endTagToken:
+			for j := len(ancestors) - 1; j >= 0; j-- {
+				if ancestors[j].tag == token.Name {
+					ancestors = ancestors[:j]
+					break
+				}
+			}
+		}
+		out = append(out, token)
+	}
+	return renderTokens(out)
+}
+
+// inlineStyle is the style attribute for the element, with the matching rules in
+// order of specificity followed by the element's own style, which wins
+func inlineStyle(rules []cssRule, element cssElement, ancestors []cssElement, token htmlToken) string {
+	var matched []cssRule
+	for _, rule := range rules {
+		if matchSelector(rule.selector, element, ancestors) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return ""
+	}
+	slices.SortStableFunc(matched, func(a, b cssRule) int {
+		if a.specificity != b.specificity {
+			return a.specificity - b.specificity
+		}
+		return a.order - b.order
+	})
+
+	var order []string
+	values := make(map[string]string)
+	set := func(declaration cssDeclaration) {
+		if _, ok := values[declaration.Property]; !ok {
+			order = append(order, declaration.Property)
+		}
+		values[declaration.Property] = declaration.Value
+	}
+	for _, rule := range matched {
+		for _, declaration := range rule.declarations {
+			set(declaration)
+		}
+	}
+	if style, ok := token.attr("style"); ok {
+		for _, declaration := range parseDeclarations(style) {
+			set(declaration)
+		}
+	}
+
+	var b strings.Builder
+	for _, property := range order {
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(property + ": " + values[property] + ";")
+	}
+	return b.String()
+}
+
+// matchSelector matches the compounds right to left, the last against the element
+// and the ones before it against its ancestors
+func matchSelector(selector []cssCompound, element cssElement, ancestors []cssElement) bool {
+	last := selector[len(selector)-1]
+	if !last.matches(element) {
+		return false
+	}
+	if len(selector) == 1 {
+		return true
+	}
+	rest := selector[:len(selector)-1]
+	if last.child {
+		if len(ancestors) == 0 {
+			return false
+		}
+		return matchSelector(rest, ancestors[len(ancestors)-1], ancestors[:len(ancestors)-1])
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if matchSelector(rest, ancestors[i], ancestors[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c cssCompound) matches(element cssElement) bool {
+	if c.tag != "" && c.tag != "*" && c.tag != element.tag {
+		return false
+	}
+	if c.id != "" && c.id != element.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !slices.Contains(element.classes, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCSS splits a style sheet into the rules that can be inlined and the CSS
+// text of those that can't
+func parseCSS(css string) ([]cssRule, string) {
+	css = cssComments.ReplaceAllString(css, "")
+	var rules []cssRule
+	var leftover strings.Builder
+	for {
+		css = strings.TrimSpace(css)
+		if css == "" {
+			break
+		}
+		if css[0] == '@' {
+			end := atRuleEnd(css)
+			leftover.WriteString(css[:end] + "\n")
+			css = css[end:]
+			continue
+		}
+		open := strings.IndexByte(css, '{')
+		close := strings.IndexByte(css, '}')
+		if open < 0 || close < open {
+			break
+		}
+		selectors, block := css[:open], css[open+1:close]
+		css = css[close+1:]
+		declarations := parseDeclarations(block)
+		for _, selector := range strings.Split(selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			compounds, specificity, ok := parseSelector(selector)
+			if !ok {
+				leftover.WriteString(selector + " {" + block + "}\n")
+				continue
+			}
+			rules = append(rules, cssRule{
+				selector:     compounds,
+				specificity:  specificity,
+				order:        len(rules),
+				declarations: declarations,
+			})
+		}
+	}
+	return rules, leftover.String()
+}
+
+// atRuleEnd is the index after an @ rule, either a statement or a block
+func atRuleEnd(css string) int {
+	semicolon := strings.IndexByte(css, ';')
+	open := strings.IndexByte(css, '{')
+	if open < 0 || (semicolon >= 0 && semicolon < open) {
+		if semicolon < 0 {
+			return len(css)
+		}
+		return semicolon + 1
+	}
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(css)
+}
+
+func parseDeclarations(block string) []cssDeclaration {
+	var declarations []cssDeclaration
+	for _, declaration := range strings.Split(block, ";") {
+		property, value, ok := strings.Cut(declaration, ":")
+		property, value = strings.ToLower(strings.TrimSpace(property)), strings.TrimSpace(value)
+		if !ok || property == "" || value == "" {
+			continue
+		}
+		declarations = append(declarations, cssDeclaration{Property: property, Value: value})
+	}
+	return declarations
+}
+
+// parseSelector reads selectors made of tags, classes and ids joined by
+// descendant or child combinators, reporting false for any other selector.
+// :root is read as html.
+func parseSelector(selector string) ([]cssCompound, int, bool) {
+	if selector == "" || strings.ContainsAny(selector, "[+~") {
+		return nil, 0, false
+	}
+	selector = strings.ReplaceAll(selector, ">", " > ")
+	var compounds []cssCompound
+	specificity := 0
+	child := false
+	for _, part := range strings.Fields(selector) {
+		if part == ">" {
+			if len(compounds) == 0 || child {
+				return nil, 0, false
+			}
+			child = true
+			continue
+		}
+		if part == ":root" {
+			part = "html"
+		}
+		if strings.Contains(part, ":") {
+			return nil, 0, false
+		}
+		compound := cssCompound{child: child}
+		child = false
+		for part != "" {
+			end := strings.IndexAny(part[1:], ".#") + 1
+			if end == 0 {
+				end = len(part)
+			}
+			switch piece := part[:end]; piece[0] {
+			case '.':
+				compound.classes = append(compound.classes, piece[1:])
+				specificity += 100
+			case '#':
+				compound.id = piece[1:]
+				specificity += 10000
+			default:
+				compound.tag = strings.ToLower(piece)
+				if piece != "*" {
+					specificity++
+				}
+			}
+			part = part[end:]
+		}
+		compounds = append(compounds, compound)
+	}
+	if child || len(compounds) == 0 {
+		return nil, 0, false
+	}
+	return compounds, specificity, true
+}
@@ -0,0 +1,311 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/helpers"
+)
+
+// states of a subscriber
+const (
+	// SubscriberPending subscribers haven't followed the confirmation link yet
+	SubscriberPending      = "pending"
+	SubscriberSubscribed   = "subscribed"
+	SubscriberUnsubscribed = "unsubscribed"
+)
+
+// audit actions of the list model
+const (
+	AuditListSubscribe   = "list.subscribe"
+	AuditListUnsubscribe = "list.unsubscribe"
+)
+
+// SubscribeConfirmTTL is how long the double opt-in link of Subscribe stays valid
+var SubscribeConfirmTTL = 3 * 24 * time.Hour
+
+var (
+	ErrNoList       = errors.New("email: no such list")
+	ErrNoSubscriber = errors.New("email: no such subscriber")
+)
+
+type ListModelInterface interface {
+	CreateList(name, title string) (List, error)
+	GetList(name string) (List, error)
+	Lists() ([]List, error)
+	Subscribe(list, email string) error
+	ConfirmSubscription(list, token string) (string, error)
+	Unsubscribe(token string, campaignID int) (Subscriber, error)
+	GetSubscriber(token string) (Subscriber, error)
+	Subscribers(list, status string) ([]Subscriber, error)
+	CreateCampaign(list, subject, template, body string) (Campaign, error)
+	StartCampaign(id int) error
+	GetCampaign(id int) (Campaign, error)
+	Campaigns(list string) ([]Campaign, error)
+	CampaignStats(id int) (CampaignStats, error)
+}
+
+type List struct {
+	ID      int
+	Name    string
+	Title   string
+	Created time.Time
+	// Subscribers counts the confirmed subscribers
+	Subscribers int
+}
+
+type Subscriber struct {
+	ID        int
+	ListID    int
+	ListName  string
+	ListTitle string
+	Email     string
+	Status    string
+	// Token identifies the subscriber in unsubscribe links
+	Token          string
+	Created        time.Time
+	ConfirmedAt    time.Time
+	UnsubscribedAt time.Time
+}
+
+// ListModel keeps mailing lists, their subscribers and the campaigns sent to them
+type ListModel struct {
+	DB        *sql.DB
+	Dialect   dialect.Dialect
+	Mail      MailInterface
+	WaitGroup *sync.WaitGroup
+	// URL is where the list handlers are mounted, e.g. https://example.com/lists,
+	// set when they are mounted and needed to subscribe or send campaigns
+	URL string
+	// Audit records subscriptions and unsubscriptions when set
+	Audit helpers.AuditFunc
+}
+
+var _ ListModelInterface = (*ListModel)(nil)
+
+// rebind adapts a query to the model's dialect, MySQL by default
+func (m *ListModel) rebind(query string) string {
+	return dialect.Or(m.Dialect).Rebind(query)
+}
+
+// subscribePurpose is the magic link purpose confirming a subscription to the list
+func subscribePurpose(list string) string {
+	return "subscribe-" + list
+}
+
+// CreateList adds a list; name appears in URLs and title in emails
+func (m *ListModel) CreateList(name, title string) (List, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, "/?#% ") {
+		return List{}, fmt.Errorf("email: invalid list name %q", name)
+	}
+	stmt := `INSERT INTO lists (name, title, created) VALUES (?, ?, ?)`
+	if _, err := m.DB.Exec(m.rebind(stmt), name, title, dialect.Time(m.Dialect, time.Now())); err != nil {
+		return List{}, err
+	}
+	return m.GetList(name)
+}
+
+func (m *ListModel) GetList(name string) (List, error) {
+	lists, err := m.queryLists(`WHERE l.name = ?`, name)
+	if err != nil {
+		return List{}, err
+	}
+	if len(lists) == 0 {
+		return List{}, ErrNoList
+	}
+	return lists[0], nil
+}
+
+func (m *ListModel) Lists() ([]List, error) {
+	return m.queryLists(`ORDER BY l.name`)
+}
+
+func (m *ListModel) queryLists(clause string, args ...any) ([]List, error) {
+	stmt := `SELECT l.id, l.name, l.title, l.created,
+	(SELECT COUNT(*) FROM subscribers s WHERE s.list_id = l.id AND s.status = ?)
+	FROM lists l ` + clause
+	rows, err := m.DB.Query(m.rebind(stmt), append([]any{SubscriberSubscribed}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []List
+	for rows.Next() {
+		var list List
+		if err := rows.Scan(&list.ID, &list.Name, &list.Title, &list.Created, &list.Subscribers); err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+// Subscribe adds the email to the list as pending and sends it a link to confirm
+// the subscription. Emails that are already subscribed aren't sent anything.
+func (m *ListModel) Subscribe(list, email string) error {
+	if m.URL == "" {
+		return errors.New("email: mount the list handlers before subscribing")
+	}
+	l, err := m.GetList(list)
+	if err != nil {
+		return err
+	}
+	email = strings.TrimSpace(email)
+
+	var status string
+	stmt := `SELECT status FROM subscribers WHERE list_id = ? AND email = ?`
+	err = m.DB.QueryRow(m.rebind(stmt), l.ID, email).Scan(&status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		token, err := newSubscriberToken()
+		if err != nil {
+			return err
+		}
+		stmt = `INSERT INTO subscribers (list_id, email, status, token, created) VALUES (?, ?, ?, ?, ?)`
+		if _, err := m.DB.Exec(m.rebind(stmt), l.ID, email, SubscriberPending, token, dialect.Time(m.Dialect, time.Now())); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case status == SubscriberSubscribed:
+		return nil
+	default:
+		stmt = `UPDATE subscribers SET status = ? WHERE list_id = ? AND email = ?`
+		if _, err := m.DB.Exec(m.rebind(stmt), SubscriberPending, l.ID, email); err != nil {
+			return err
+		}
+	}
+
+	title := l.Title
+	if title == "" {
+		title = l.Name
+	}
+	link := m.Mail.GetExpiringMagicLink(email, subscribePurpose(l.Name), m.URL+"/"+l.Name+"/confirm/", SubscribeConfirmTTL)
+	m.Mail.Send(email, "", "Confirm your subscription to "+title,
+		`Follow this link to start receiving %s: <a href="%s">%s</a><br>If you didn't ask to subscribe, ignore this email.`,
+		title, link, link)
+	return nil
+}
+
+// ConfirmSubscription uses up a link sent by Subscribe and returns the email it subscribed
+func (m *ListModel) ConfirmSubscription(list, token string) (string, error) {
+	l, err := m.GetList(list)
+	if err != nil {
+		return "", err
+	}
+	email, err := m.Mail.UseMagicLink(token, subscribePurpose(l.Name))
+	if err != nil {
+		return "", err
+	}
+	stmt := `UPDATE subscribers SET status = ?, confirmed_at = ?, unsubscribed_at = NULL, unsubscribed_campaign_id = NULL
+	WHERE list_id = ? AND email = ?`
+	if _, err := m.DB.Exec(m.rebind(stmt), SubscriberSubscribed, dialect.Time(m.Dialect, time.Now()), l.ID, email); err != nil {
+		return "", err
+	}
+	m.Audit.Emit(email, AuditListSubscribe, l.Name, nil)
+	return email, nil
+}
+
+// Unsubscribe takes the subscriber with the token off their list, crediting the
+// campaign whose email they unsubscribed from when campaignID isn't 0.
+// Unsubscribing twice isn't an error.
+func (m *ListModel) Unsubscribe(token string, campaignID int) (Subscriber, error) {
+	subscriber, err := m.GetSubscriber(token)
+	if err != nil {
+		return Subscriber{}, err
+	}
+	if subscriber.Status == SubscriberUnsubscribed {
+		return subscriber, nil
+	}
+	var campaign sql.NullInt64
+	if campaignID != 0 {
+		campaign = sql.NullInt64{Int64: int64(campaignID), Valid: true}
+	}
+	now := time.Now().UTC()
+	stmt := `UPDATE subscribers SET status = ?, unsubscribed_at = ?, unsubscribed_campaign_id = ? WHERE id = ? AND status <> ?`
+	result, err := m.DB.Exec(m.rebind(stmt), SubscriberUnsubscribed, dialect.Time(m.Dialect, now), campaign, subscriber.ID, SubscriberUnsubscribed)
+	if err != nil {
+		return Subscriber{}, err
+	}
+	if changed, err := result.RowsAffected(); err == nil && changed > 0 {
+		metadata := map[string]any{}
+		if campaignID != 0 {
+			metadata["campaign"] = campaignID
+		}
+		m.Audit.Emit(subscriber.Email, AuditListUnsubscribe, subscriber.ListName, metadata)
+	}
+	subscriber.Status = SubscriberUnsubscribed
+	subscriber.UnsubscribedAt = now
+	return subscriber, nil
+}
+
+// GetSubscriber returns the subscriber with the unsubscribe token
+func (m *ListModel) GetSubscriber(token string) (Subscriber, error) {
+	subscribers, err := m.querySubscribers(`WHERE s.token = ?`, token)
+	if err != nil {
+		return Subscriber{}, err
+	}
+	if len(subscribers) == 0 {
+		return Subscriber{}, ErrNoSubscriber
+	}
+	return subscribers[0], nil
+}
+
+// Subscribers returns the list's subscribers with the status, or every one when it is empty
+func (m *ListModel) Subscribers(list, status string) ([]Subscriber, error) {
+	if status == "" {
+		return m.querySubscribers(`WHERE l.name = ? ORDER BY s.id`, list)
+	}
+	return m.querySubscribers(`WHERE l.name = ? AND s.status = ? ORDER BY s.id`, list, status)
+}
+
+func (m *ListModel) querySubscribers(clause string, args ...any) ([]Subscriber, error) {
+	stmt := `SELECT s.id, s.list_id, l.name, l.title, s.email, s.status, s.token, s.created, s.confirmed_at, s.unsubscribed_at
+	FROM subscribers s JOIN lists l ON l.id = s.list_id ` + clause
+	rows, err := m.DB.Query(m.rebind(stmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []Subscriber
+	for rows.Next() {
+		var subscriber Subscriber
+		var confirmedAt, unsubscribedAt sql.NullTime
+		err := rows.Scan(&subscriber.ID, &subscriber.ListID, &subscriber.ListName, &subscriber.ListTitle,
+			&subscriber.Email, &subscriber.Status, &subscriber.Token, &subscriber.Created, &confirmedAt, &unsubscribedAt)
+		if err != nil {
+			return nil, err
+		}
+		subscriber.ConfirmedAt = confirmedAt.Time
+		subscriber.UnsubscribedAt = unsubscribedAt.Time
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers, rows.Err()
+}
+
+func newSubscriberToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// StartCampaigns runs the campaign worker in the background until ctx is done,
+// counted in the model's WaitGroup
+func (m *ListModel) StartCampaigns(ctx context.Context) {
+	m.WaitGroup.Go(func() {
+		m.RunCampaigns(ctx)
+	})
+}
@@ -0,0 +1,146 @@
+package email
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// CaptureTimeout is how long the MemoryTransport assertions wait for the
+// outbox worker to hand over a message
+var CaptureTimeout = 2 * time.Second
+
+// MemoryTransport keeps sent messages in memory for tests. Its zero value is ready to use.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []CapturedMessage
+	next     int
+	// err is returned by Send instead of capturing, see Fail
+	err error
+	// sent is closed and replaced on every send to wake up waiting assertions
+	sent chan struct{}
+}
+
+var _ Capturer = (*MemoryTransport)(nil)
+
+func (t *MemoryTransport) Send(ctx context.Context, from string, to []string, raw []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	t.next++
+	t.messages = append(t.messages, CapturedMessage{
+		ID:   strconv.Itoa(t.next),
+		From: from,
+		To:   slices.Clone(to),
+		Raw:  slices.Clone(raw),
+		Sent: time.Now(),
+	})
+	if t.sent != nil {
+		close(t.sent)
+		t.sent = nil
+	}
+	return nil
+}
+
+// Fail makes Send return err instead of capturing messages, until it's called
+// with nil, to test how failed deliveries are retried
+func (t *MemoryTransport) Fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+}
+
+// Messages returns the captured messages, newest first
+func (t *MemoryTransport) Messages() []CapturedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	messages := slices.Clone(t.messages)
+	slices.Reverse(messages)
+	return messages
+}
+
+// Reset forgets the captured messages
+func (t *MemoryTransport) Reset() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = nil
+	return nil
+}
+
+// To returns the captured messages with the address among their envelope recipients, newest first
+func (t *MemoryTransport) To(address string) []CapturedMessage {
+	var messages []CapturedMessage
+	for _, message := range t.Messages() {
+		if slices.Contains(message.To, address) {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Wait blocks until at least n messages are captured, reporting false if that
+// takes longer than timeout
+func (t *MemoryTransport) Wait(n int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		t.mu.Lock()
+		if len(t.messages) >= n {
+			t.mu.Unlock()
+			return true
+		}
+		if t.sent == nil {
+			t.sent = make(chan struct{})
+		}
+		sent := t.sent
+		t.mu.Unlock()
+
+		select {
+		case <-sent:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// AssertSent fails the test unless a message to the address is captured within
+// CaptureTimeout, and returns the latest one
+func (t *MemoryTransport) AssertSent(tb testing.TB, address string) CapturedMessage {
+	tb.Helper()
+	deadline := time.Now().Add(CaptureTimeout)
+	for {
+		if messages := t.To(address); len(messages) > 0 {
+			return messages[0]
+		}
+		t.mu.Lock()
+		n := len(t.messages)
+		t.mu.Unlock()
+		remaining := time.Until(deadline)
+		if remaining <= 0 || !t.Wait(n+1, remaining) {
+			tb.Fatalf("no email was sent to %s", address)
+			return CapturedMessage{}
+		}
+	}
+}
+
+// AssertNotSent fails the test if a message to the address was captured
+func (t *MemoryTransport) AssertNotSent(tb testing.TB, address string) {
+	tb.Helper()
+	if messages := t.To(address); len(messages) > 0 {
+		tb.Errorf("%d email(s) were sent to %s, the latest with subject %q", len(messages), address, messages[0].Subject())
+	}
+}
+
+// AssertCount fails the test unless exactly n messages are captured within CaptureTimeout
+func (t *MemoryTransport) AssertCount(tb testing.TB, n int) {
+	tb.Helper()
+	t.Wait(n, CaptureTimeout)
+	if got := len(t.Messages()); got != n {
+		tb.Errorf("%d email(s) were sent, want %d", got, n)
+	}
+}
@@ -0,0 +1,366 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/joashgobin/boiler/helpers"
+	"github.com/wneessen/go-mail"
+)
+
+// headers a Message sets through its own methods
+var reservedHeaders = []string{
+	"from", "to", "cc", "bcc", "reply-to", "subject", "date", "message-id", "mime-version",
+	"content-type", "content-transfer-encoding",
+}
+
+// Message builds an email for MailModel.Deliver or MailModel.QueueMessage.
+// Every method returns a copy, so a message can be the base of several others:
+//
+//	message := email.NewMessage().
+//		To("jane@example.com").
+//		ReplyTo("sales@example.com").
+//		Subject("Your invoice").
+//		HTML(`<p>Thanks for your order!</p><img src="cid:logo">`).
+//		Embed("logo", "logo.png", logo).
+//		AttachFile("invoices/1042.pdf")
+//	err := base.Mail.Deliver(ctx, message)
+//
+// The first error of a method, such as a missing file, is returned when the
+// message is delivered or queued.
+type Message struct {
+	to          []string
+	cc          []string
+	bcc         []string
+	replyTo     string
+	subject     string
+	text        string
+	html        string
+	template    string
+	data        any
+	headers     []messageHeader
+	attachments []messageFile
+	err         error
+}
+
+type messageHeader struct {
+	key, value string
+	// preformatted values are written as they are rather than encoded
+	preformatted bool
+}
+
+type messageFile struct {
+	name string
+	data []byte
+	// contentID makes an inline file, referenced from the HTML as cid:<contentID>
+	contentID string
+}
+
+func NewMessage() Message {
+	return Message{}
+}
+
+// To adds recipients, empty addresses are skipped
+func (m Message) To(addresses ...string) Message {
+	m.to = appendAddresses(m.to, addresses)
+	return m
+}
+
+// Cc adds copied recipients, empty addresses are skipped
+func (m Message) Cc(addresses ...string) Message {
+	m.cc = appendAddresses(m.cc, addresses)
+	return m
+}
+
+// Bcc adds hidden recipients, empty addresses are skipped
+func (m Message) Bcc(addresses ...string) Message {
+	m.bcc = appendAddresses(m.bcc, addresses)
+	return m
+}
+
+// ReplyTo sets where replies go instead of the sender
+func (m Message) ReplyTo(address string) Message {
+	m.replyTo = address
+	return m
+}
+
+func (m Message) Subject(subject string) Message {
+	m.subject = subject
+	return m
+}
+
+// Text sets the text/plain part, generated from the HTML when it's left out
+func (m Message) Text(text string) Message {
+	m.text = text
+	return m
+}
+
+// HTML sets the text/html part, with its style sheets inlined when it's delivered
+func (m Message) HTML(html string) Message {
+	m.html = html
+	return m
+}
+
+// Template renders the email template loaded by MailModel.LoadTemplates for
+// the subject, HTML and text the message doesn't set itself
+func (m Message) Template(name string, data any) Message {
+	m.template, m.data = name, data
+	return m
+}
+
+// Header sets a custom header such as X-Campaign. The headers set by the other
+// methods can't be changed this way.
+func (m Message) Header(key, value string) Message {
+	if slices.Contains(reservedHeaders, strings.ToLower(key)) {
+		return m.fail(fmt.Errorf("email: header %s is set by Message itself", key))
+	}
+	if strings.ContainsAny(key+value, "\r\n") || strings.ContainsAny(key, " :") {
+		return m.fail(fmt.Errorf("email: invalid header %q", key))
+	}
+	m.headers = append(slices.Clip(m.headers), messageHeader{key: key, value: value})
+	return m
+}
+
+// ListUnsubscribe adds the List-Unsubscribe header with https or mailto addresses
+// that take the recipient off the list
+func (m Message) ListUnsubscribe(addresses ...string) Message {
+	var values []string
+	for _, address := range addresses {
+		if strings.ContainsAny(address, "\r\n<>, ") {
+			return m.fail(fmt.Errorf("email: invalid unsubscribe address %q", address))
+		}
+		values = append(values, "<"+address+">")
+	}
+	m.headers = append(slices.Clip(m.headers), messageHeader{
+		key: "List-Unsubscribe", value: strings.Join(values, ", "), preformatted: true,
+	})
+	return m
+}
+
+// OneClickUnsubscribe adds the List-Unsubscribe headers of RFC 8058, with which
+// mail clients unsubscribe the recipient by POSTing to the https address
+// without opening it
+func (m Message) OneClickUnsubscribe(address string) Message {
+	if !strings.HasPrefix(address, "https://") {
+		return m.fail(fmt.Errorf("email: one-click unsubscribe address %q isn't https", address))
+	}
+	return m.ListUnsubscribe(address).Header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+}
+
+// Attach adds a file with the content
+func (m Message) Attach(name string, data []byte) Message {
+	m.attachments = append(slices.Clip(m.attachments), messageFile{name: name, data: data})
+	return m
+}
+
+// AttachFS adds a file read from fsys, named after its base name
+func (m Message) AttachFS(fsys fs.FS, name string) Message {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return m.fail(err)
+	}
+	return m.Attach(path.Base(name), data)
+}
+
+// AttachFile adds a file read from disk, named after its base name
+func (m Message) AttachFile(filename string) Message {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return m.fail(err)
+	}
+	return m.Attach(filepath.Base(filename), data)
+}
+
+// Embed adds an inline file, usually an image, shown by the HTML with
+// src="cid:<contentID>"
+func (m Message) Embed(contentID, name string, data []byte) Message {
+	if contentID == "" || strings.ContainsAny(contentID, "\r\n<> ") {
+		return m.fail(fmt.Errorf("email: invalid content ID %q", contentID))
+	}
+	m.attachments = append(slices.Clip(m.attachments), messageFile{name: name, data: data, contentID: contentID})
+	return m
+}
+
+// EmbedFS adds an inline file read from fsys, see Embed
+func (m Message) EmbedFS(contentID string, fsys fs.FS, name string) Message {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return m.fail(err)
+	}
+	return m.Embed(contentID, path.Base(name), data)
+}
+
+// EmbedFile adds an inline file read from disk, see Embed
+func (m Message) EmbedFile(contentID, filename string) Message {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return m.fail(err)
+	}
+	return m.Embed(contentID, filepath.Base(filename), data)
+}
+
+// Err is the first error of the methods that built the message
+func (m Message) Err() error {
+	return m.err
+}
+
+func (m Message) fail(err error) Message {
+	if m.err == nil {
+		m.err = err
+	}
+	return m
+}
+
+func appendAddresses(list, addresses []string) []string {
+	list = slices.Clip(list)
+	for _, address := range addresses {
+		if address = strings.TrimSpace(address); address != "" {
+			list = append(list, address)
+		}
+	}
+	return list
+}
+
+// render writes the message from MAIL_USER_EMAIL as RFC 5322 bytes along with
+// its envelope sender and recipients. Bcc only appears in the envelope.
+func (m Message) render() (string, []string, []byte, error) {
+	if m.err != nil {
+		return "", nil, nil, m.err
+	}
+	if len(m.to)+len(m.cc)+len(m.bcc) == 0 {
+		return "", nil, nil, errors.New("email: message has no recipients")
+	}
+	message := mail.NewMsg()
+	if err := message.FromFormat(helpers.Getenv("MAIL_USERNAME"), helpers.Getenv("MAIL_USER_EMAIL")); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid 'from' address: %w", err)
+	}
+	if len(m.to) > 0 {
+		if err := message.To(m.to...); err != nil {
+			return "", nil, nil, fmt.Errorf("invalid 'to' address: %w", err)
+		}
+	}
+	if len(m.cc) > 0 {
+		if err := message.Cc(m.cc...); err != nil {
+			return "", nil, nil, fmt.Errorf("invalid 'cc' address: %w", err)
+		}
+	}
+	if len(m.bcc) > 0 {
+		if err := message.Bcc(m.bcc...); err != nil {
+			return "", nil, nil, fmt.Errorf("invalid 'bcc' address: %w", err)
+		}
+	}
+	if m.replyTo != "" {
+		if err := message.ReplyTo(m.replyTo); err != nil {
+			return "", nil, nil, fmt.Errorf("invalid 'reply-to' address: %w", err)
+		}
+	}
+	message.Subject(m.subject)
+	for _, header := range m.headers {
+		if header.preformatted {
+			message.SetGenHeaderPreformatted(mail.Header(header.key), header.value)
+		} else {
+			message.SetGenHeader(mail.Header(header.key), header.value)
+		}
+	}
+
+	switch {
+	case m.html == "":
+		message.SetBodyString(mail.TypeTextPlain, m.text)
+	case m.text == "":
+		message.SetBodyString(mail.TypeTextPlain, HTMLToText(m.html))
+		message.AddAlternativeString(mail.TypeTextHTML, m.html)
+	default:
+		message.SetBodyString(mail.TypeTextPlain, m.text)
+		message.AddAlternativeString(mail.TypeTextHTML, m.html)
+	}
+	for _, file := range m.attachments {
+		opts := []mail.FileOption{}
+		if contentType := mime.TypeByExtension(path.Ext(file.name)); contentType != "" {
+			opts = append(opts, mail.WithFileContentType(mail.ContentType(contentType)))
+		}
+		if file.contentID != "" {
+			opts = append(opts, mail.WithFileContentID("<"+file.contentID+">"))
+			message.EmbedReadSeeker(file.name, bytes.NewReader(file.data), opts...)
+		} else {
+			message.AttachReadSeeker(file.name, bytes.NewReader(file.data), opts...)
+		}
+	}
+
+	from, err := message.GetSender(false)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	recipients, err := message.GetRecipients()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// go-mail wraps envelope addresses in angle brackets
+	for i, rcpt := range recipients {
+		recipients[i] = strings.Trim(rcpt, "<>")
+	}
+	raw := new(bytes.Buffer)
+	if _, err := message.WriteTo(raw); err != nil {
+		return "", nil, nil, err
+	}
+	return strings.Trim(from, "<>"), recipients, raw.Bytes(), nil
+}
+
+// fill renders the message's template, if it has one, into the parts the
+// message doesn't set itself, and inlines the style sheets of its HTML
+func (m *MailModel) fill(message Message) (Message, error) {
+	if message.err == nil && message.template != "" {
+		subject, htmlBody, text, err := m.renderTemplate(message.template, message.data)
+		if err != nil {
+			return message, err
+		}
+		if message.subject == "" {
+			message.subject = subject
+		}
+		if message.html == "" {
+			message.html = htmlBody
+			if message.text == "" {
+				message.text = text
+			}
+		}
+	}
+	if message.html != "" {
+		message.html = InlineCSS(message.html)
+	}
+	return message, nil
+}
+
+// Deliver sends the message through the Transport right away, returning its error.
+// Use QueueMessage to have the outbox retry it instead.
+func (m *MailModel) Deliver(ctx context.Context, message Message) error {
+	message, err := m.fill(message)
+	if err != nil {
+		return err
+	}
+	from, recipients, raw, err := message.render()
+	if err != nil {
+		return err
+	}
+	return m.send(ctx, from, recipients, raw)
+}
+
+// QueueMessage stores the message in the outbox like Queue and returns its id
+func (m *MailModel) QueueMessage(message Message) (int64, error) {
+	message, err := m.fill(message)
+	if err != nil {
+		return 0, err
+	}
+	from, recipients, raw, err := message.render()
+	if err != nil {
+		return 0, err
+	}
+	return m.enqueue(from, recipients, message.subject, raw)
+}
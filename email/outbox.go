@@ -0,0 +1,307 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
+)
+
+// states of a message in the outbox
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	// OutboxDead messages ran out of attempts and wait for RetryOutbox
+	OutboxDead = "dead"
+)
+
+var (
+	// OutboxPollInterval is how often the worker looks for due messages when nothing wakes it
+	OutboxPollInterval = 5 * time.Second
+	// OutboxBatchSize is how many messages the worker sends per round
+	OutboxBatchSize = 20
+	// OutboxMaxAttempts is how many sends fail before a message is dead
+	OutboxMaxAttempts = 8
+	// OutboxRetryDelay is the wait after the first failure, doubled for every
+	// further one up to OutboxMaxRetryDelay
+	OutboxRetryDelay    = 30 * time.Second
+	OutboxMaxRetryDelay = 6 * time.Hour
+	// OutboxClaimTimeout returns messages to pending when a worker stopped while sending them
+	OutboxClaimTimeout = 10 * time.Minute
+	// OutboxDrainTimeout is how long the worker keeps sending due messages on shutdown
+	OutboxDrainTimeout = 10 * time.Second
+)
+
+var ErrNoMessage = errors.New("email: no such outbox message")
+
+// OutboxMessage is the delivery state of a queued email
+type OutboxMessage struct {
+	ID            int64
+	Sender        string
+	Recipients    []string
+	Subject       string
+	Status        string
+	Attempts      int
+	LastError     string
+	Created       time.Time
+	NextAttemptAt time.Time
+	SentAt        time.Time
+}
+
+// Queue stores an email in the outbox and returns its id for OutboxStatus. The
+// outbox worker started with StartOutbox sends it, retrying failures with backoff.
+func (m *MailModel) Queue(to, bcc, subject string, swaps ...any) (int64, error) {
+	from, recipients, raw, err := buildMessage(to, bcc, subject, formatBody(swaps))
+	if err != nil {
+		return 0, err
+	}
+	return m.enqueue(from, recipients, subject, raw)
+}
+
+func (m *MailModel) enqueue(from string, recipients []string, subject string, raw []byte) (int64, error) {
+	if len(recipients) == 0 {
+		return 0, errors.New("email: message has no recipients")
+	}
+	now := time.Now().UTC()
+	stmt := `INSERT INTO email_outbox (sender, recipients, subject, message, status, created, next_attempt_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	args := []any{from, strings.Join(recipients, ","), truncate(subject, 255), raw, OutboxPending,
+		dialect.Time(m.Dialect, now), dialect.Time(m.Dialect, now)}
+
+	var id int64
+	// postgres drivers don't support LastInsertId
+	if dialect.Or(m.Dialect).Name() == "postgres" {
+		err := m.DB.QueryRow(m.rebind(stmt+` RETURNING id`), args...).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		result, err := m.DB.Exec(m.rebind(stmt), args...)
+		if err != nil {
+			return 0, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return 0, err
+		}
+	}
+
+	m.wakeWorker()
+	return id, nil
+}
+
+// OutboxStatus returns the delivery state of a queued email
+func (m *MailModel) OutboxStatus(id int64) (OutboxMessage, error) {
+	messages, err := m.queryOutbox(`WHERE id = ?`, id)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+	if len(messages) == 0 {
+		return OutboxMessage{}, ErrNoMessage
+	}
+	return messages[0], nil
+}
+
+// ListOutbox returns the latest messages with the status, or with any status when it is empty
+func (m *MailModel) ListOutbox(status string, limit int) ([]OutboxMessage, error) {
+	if status == "" {
+		return m.queryOutbox(`ORDER BY id DESC LIMIT ?`, limit)
+	}
+	return m.queryOutbox(`WHERE status = ? ORDER BY id DESC LIMIT ?`, status, limit)
+}
+
+// RetryOutbox gives a dead message a fresh set of attempts
+func (m *MailModel) RetryOutbox(id int64) error {
+	stmt := `UPDATE email_outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), OutboxPending, dialect.Time(m.Dialect, time.Now()), id, OutboxDead)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoMessage
+	}
+	m.wakeWorker()
+	return nil
+}
+
+func (m *MailModel) queryOutbox(clause string, args ...any) ([]OutboxMessage, error) {
+	stmt := `SELECT id, sender, recipients, subject, status, attempts, last_error, created, next_attempt_at, sent_at
+	FROM email_outbox ` + clause
+	rows, err := m.DB.Query(m.rebind(stmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var message OutboxMessage
+		var recipients string
+		var sentAt sql.NullTime
+		err := rows.Scan(&message.ID, &message.Sender, &recipients, &message.Subject, &message.Status,
+			&message.Attempts, &message.LastError, &message.Created, &message.NextAttemptAt, &sentAt)
+		if err != nil {
+			return nil, err
+		}
+		message.Recipients = strings.Split(recipients, ",")
+		message.SentAt = sentAt.Time
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// StartOutbox runs the outbox worker in the background until ctx is done,
+// counted in the model's WaitGroup so shutdown waits for it to drain
+func (m *MailModel) StartOutbox(ctx context.Context) {
+	m.WaitGroup.Go(func() {
+		m.RunOutbox(ctx)
+	})
+}
+
+// RunOutbox sends due messages until ctx is done, then keeps sending the ones
+// that are already due for up to OutboxDrainTimeout
+func (m *MailModel) RunOutbox(ctx context.Context) {
+	ticker := time.NewTicker(OutboxPollInterval)
+	defer ticker.Stop()
+	for {
+		for m.sendDue(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			drain, cancel := context.WithTimeout(context.Background(), OutboxDrainTimeout)
+			for drain.Err() == nil && m.sendDue(drain) {
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+// wakeWorker tells the worker there is a message to send rather than waiting for the next poll
+func (m *MailModel) wakeWorker() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendDue sends a batch of due messages and reports whether there may be more
+func (m *MailModel) sendDue(ctx context.Context) bool {
+	now := time.Now().UTC()
+
+	// a worker stopped in the middle of these
+	stmt := `UPDATE email_outbox SET status = ? WHERE status = ? AND claimed_at < ?`
+	if _, err := m.DB.Exec(m.rebind(stmt), OutboxPending, OutboxSending,
+		dialect.Time(m.Dialect, now.Add(-OutboxClaimTimeout))); err != nil {
+		log.Errorf("outbox reclaim error: %v", err)
+		return false
+	}
+
+	stmt = `SELECT id FROM email_outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ?`
+	rows, err := m.DB.Query(m.rebind(stmt), OutboxPending, dialect.Time(m.Dialect, now), OutboxBatchSize)
+	if err != nil {
+		log.Errorf("outbox query error: %v", err)
+		return false
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			log.Errorf("outbox scan error: %v", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		log.Errorf("outbox query error: %v", err)
+		return false
+	}
+	rows.Close()
+
+	claimed := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return false
+		}
+		if m.sendOne(ctx, id) {
+			claimed++
+		}
+	}
+	return len(ids) == OutboxBatchSize && claimed == len(ids)
+}
+
+// sendOne claims a message so no other worker sends it too, then sends it.
+// It reports whether the message was claimed.
+func (m *MailModel) sendOne(ctx context.Context, id int64) bool {
+	stmt := `UPDATE email_outbox SET status = ?, claimed_at = ? WHERE id = ? AND status = ?`
+	result, err := m.DB.Exec(m.rebind(stmt), OutboxSending, dialect.Time(m.Dialect, time.Now()), id, OutboxPending)
+	if err != nil {
+		log.Errorf("outbox claim error: %v", err)
+		return false
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+		return false
+	}
+
+	var from, recipients string
+	var raw []byte
+	var attempts int
+	stmt = `SELECT sender, recipients, message, attempts FROM email_outbox WHERE id = ?`
+	if err := m.DB.QueryRow(m.rebind(stmt), id).Scan(&from, &recipients, &raw, &attempts); err != nil {
+		log.Errorf("outbox load error: %v", err)
+		return true
+	}
+
+	err = m.send(ctx, from, strings.Split(recipients, ","), raw)
+	if err == nil {
+		stmt = `UPDATE email_outbox SET status = ?, attempts = ?, last_error = '', sent_at = ? WHERE id = ?`
+		if _, err := m.DB.Exec(m.rebind(stmt), OutboxSent, attempts+1, dialect.Time(m.Dialect, time.Now()), id); err != nil {
+			log.Errorf("outbox update error: %v", err)
+		}
+		return true
+	}
+
+	attempts++
+	status := OutboxPending
+	if attempts >= OutboxMaxAttempts {
+		status = OutboxDead
+		log.Errorf("outbox message %d to %s is dead after %d attempts: %v", id, recipients, attempts, err)
+	} else {
+		log.Warnf("outbox message %d to %s failed, attempt %d: %v", id, recipients, attempts, err)
+	}
+	stmt = `UPDATE email_outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?`
+	_, err = m.DB.Exec(m.rebind(stmt), status, attempts, truncate(err.Error(), 1000),
+		dialect.Time(m.Dialect, time.Now().Add(retryDelay(attempts))), id)
+	if err != nil {
+		log.Errorf("outbox update error: %v", err)
+	}
+	return true
+}
+
+// retryDelay is OutboxRetryDelay doubled for every failure after the first
+func retryDelay(attempts int) time.Duration {
+	delay := OutboxRetryDelay
+	for i := 1; i < attempts && delay < OutboxMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, OutboxMaxRetryDelay)
+}
+
+// truncate cuts s to at most n bytes without leaving half a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
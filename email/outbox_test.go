@@ -0,0 +1,174 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joashgobin/boiler/email"
+)
+
+// set changes a tunable for the test
+func set[T any](t *testing.T, v *T, value T) {
+	old := *v
+	*v = value
+	t.Cleanup(func() { *v = old })
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{9, 128 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := email.RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v; want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		// é is two bytes, so half of it is dropped
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+	}
+	for _, tt := range tests {
+		if got := email.Truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q; want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	set(t, &email.OutboxMaxAttempts, 3)
+	// failed messages are due again right away
+	set(t, &email.OutboxRetryDelay, 0)
+
+	tests := []struct {
+		name         string
+		failures     int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"sent first time", 0, email.OutboxSent, 1},
+		{"sent after failures", 2, email.OutboxSent, 3},
+		{"dead after the last attempt", 3, email.OutboxDead, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mail, transport := newTestMail(t)
+			id, err := mail.Queue("jane@example.com", "", "Hello", "Hello Jane")
+			if err != nil {
+				t.Fatal(err)
+			}
+			transport.Fail(errors.New("connection refused"))
+			for attempt := range email.OutboxMaxAttempts + 1 {
+				if attempt == tt.failures {
+					transport.Fail(nil)
+				}
+				mail.SendDue(ctx)
+			}
+
+			message, err := mail.OutboxStatus(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.Status != tt.wantStatus || message.Attempts != tt.wantAttempts {
+				t.Fatalf("message is %s after %d attempts; want %s after %d",
+					message.Status, message.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if sent := len(transport.To("jane@example.com")); sent != 1 && tt.wantStatus == email.OutboxSent {
+				t.Fatalf("%d emails delivered; want 1", sent)
+			}
+			if tt.wantStatus == email.OutboxDead {
+				transport.AssertNotSent(t, "jane@example.com")
+				if message.LastError != "connection refused" {
+					t.Fatalf("last error = %q; want the transport's", message.LastError)
+				}
+			} else if message.LastError != "" || message.SentAt.IsZero() {
+				t.Fatalf("sent message has last error %q and sent at %v", message.LastError, message.SentAt)
+			}
+		})
+	}
+}
+
+func TestRetryOutbox(t *testing.T) {
+	set(t, &email.OutboxMaxAttempts, 1)
+	ctx := context.Background()
+	mail, transport := newTestMail(t)
+	id, err := mail.Queue("jane@example.com", "", "Hello", "Hello Jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mail.RetryOutbox(id); !errors.Is(err, email.ErrNoMessage) {
+		t.Fatalf("RetryOutbox of a pending message = %v; want ErrNoMessage", err)
+	}
+
+	transport.Fail(errors.New("connection refused"))
+	mail.SendDue(ctx)
+	dead, err := mail.ListOutbox(email.OutboxDead, 10)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("dead messages = %v, %v; want the message", dead, err)
+	}
+
+	if err := mail.RetryOutbox(id); err != nil {
+		t.Fatal(err)
+	}
+	message, err := mail.OutboxStatus(id)
+	if err != nil || message.Status != email.OutboxPending || message.Attempts != 0 {
+		t.Fatalf("retried message = %+v, %v; want pending with no attempts", message, err)
+	}
+	transport.Fail(nil)
+	mail.SendDue(ctx)
+	transport.AssertSent(t, "jane@example.com")
+	if err := mail.RetryOutbox(id); !errors.Is(err, email.ErrNoMessage) {
+		t.Fatalf("RetryOutbox of a sent message = %v; want ErrNoMessage", err)
+	}
+}
+
+func TestOutboxBatches(t *testing.T) {
+	set(t, &email.OutboxBatchSize, 2)
+	ctx := context.Background()
+	mail, transport := newTestMail(t)
+	for range 3 {
+		if _, err := mail.Queue("jane@example.com", "", "Hello", "Hello Jane"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		more bool
+		sent int
+	}{
+		{true, 2},
+		{false, 3},
+		{false, 3},
+	}
+	for i, tt := range tests {
+		if more := mail.SendDue(ctx); more != tt.more {
+			t.Fatalf("round %d: SendDue = %v; want %v", i, more, tt.more)
+		}
+		if sent := len(transport.Messages()); sent != tt.sent {
+			t.Fatalf("round %d: %d emails sent; want %d", i, sent, tt.sent)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/joashgobin/boiler/helpers"
+)
+
+var (
+	// SMTPIdleTimeout is how long an SMTPTransport keeps its connection open after a send
+	SMTPIdleTimeout = 30 * time.Second
+	// SMTPTimeout bounds every conversation with the mail server
+	SMTPTimeout = time.Minute
+)
+
+// SMTPTransport sends through a mail server over STARTTLS, reusing one
+// connection for messages sent within SMTPIdleTimeout of each other
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	client *smtp.Client
+	idle   *time.Timer
+}
+
+var _ Transport = (*SMTPTransport)(nil)
+
+// NewSMTPTransport returns a transport for MAIL_HOST on MAIL_PORT (587 by
+// default), signing in as MAIL_USER_EMAIL with MAIL_PW
+func NewSMTPTransport() *SMTPTransport {
+	port := helpers.GetenvDefault("MAIL_PORT", "")
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPTransport{
+		Host:     helpers.Getenv("MAIL_HOST"),
+		Port:     port,
+		Username: helpers.Getenv("MAIL_USER_EMAIL"),
+		Password: helpers.Getenv("MAIL_PW"),
+	}
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, from string, to []string, raw []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+
+	// the server may have dropped an idle connection, in which case RSET fails
+	reused := t.client != nil
+	if reused {
+		t.conn.SetDeadline(time.Now().Add(SMTPTimeout))
+		if err := t.client.Reset(); err != nil {
+			t.drop()
+			reused = false
+		}
+	}
+	if !reused {
+		if err := t.dial(ctx); err != nil {
+			return err
+		}
+	}
+
+	// a stalled server gives up with the context rather than holding the sender
+	conn := t.conn
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(SMTPTimeout))
+	err := t.send(from, to, raw)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		t.drop()
+		return err
+	}
+	t.idle = time.AfterFunc(SMTPIdleTimeout, func() { t.Close() })
+	return nil
+}
+
+// Close says goodbye to the mail server, a later Send connects again
+func (t *SMTPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	t.conn.SetDeadline(time.Now().Add(SMTPTimeout))
+	err := t.client.Quit()
+	t.drop()
+	return err
+}
+
+func (t *SMTPTransport) dial(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.Host, t.Port))
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	conn.SetDeadline(time.Now().Add(SMTPTimeout))
+
+	client, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	err = t.hello(client)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	t.conn, t.client = conn, client
+	return nil
+}
+
+// hello upgrades the connection to TLS and signs in
+func (t *SMTPTransport) hello(client *smtp.Client) error {
+	if ok, _ := client.Extension("STARTTLS"); !ok {
+		return errors.New("email: mail server does not support STARTTLS")
+	}
+	if err := client.StartTLS(&tls.Config{ServerName: t.Host}); err != nil {
+		return err
+	}
+	if t.Username == "" {
+		return nil
+	}
+	return client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host))
+}
+
+func (t *SMTPTransport) send(from string, to []string, raw []byte) error {
+	if err := t.client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := t.client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := t.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := bytes.NewReader(raw).WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// drop closes the connection without saying goodbye
+func (t *SMTPTransport) drop() {
+	if t.client != nil {
+		t.client.Close()
+	}
+	t.conn, t.client = nil, nil
+}
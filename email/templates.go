@@ -0,0 +1,121 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	ht "html/template"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// TemplateDir is the directory of the fs given to LoadTemplates holding the email templates
+var TemplateDir = "emails"
+
+var ErrNoTemplate = errors.New("email: no such template")
+
+// LoadTemplates parses the email templates in TemplateDir of fsys, so a broken
+// template fails at startup rather than when it's sent. Every file but
+// layout.html is a template named after the file, e.g. emails/welcome.html is
+// "welcome", and defines a "subject" and the "content" of the email:
+//
+//	{{define "subject"}}Welcome, {{.Name}}{{end}}
+//	{{define "content"}}<p>Thanks for signing up!</p>{{end}}
+//
+// The content is rendered inside the "layout" template of emails/layout.html, or
+// boiler's own layout when there is none. A template can define "text" to write
+// the text/plain part itself, otherwise it's generated from the HTML.
+func (m *MailModel) LoadTemplates(fsys fs.FS, funcs ht.FuncMap) error {
+	base := ht.New("").Funcs(ht.FuncMap{
+		"safeHTML": func(s string) ht.HTML {
+			return ht.HTML(s)
+		},
+	}).Funcs(funcs)
+
+	layout := path.Join(TemplateDir, "layout.html")
+	var err error
+	if _, statErr := fs.Stat(fsys, layout); statErr == nil {
+		base, err = base.ParseFS(fsys, layout)
+	} else {
+		base, err = base.ParseFS(templatesFS, "templates/layout.html")
+	}
+	if err != nil {
+		return err
+	}
+	if base.Lookup("layout") == nil {
+		return fmt.Errorf("email: %s doesn't define \"layout\"", layout)
+	}
+
+	files, err := fs.Glob(fsys, path.Join(TemplateDir, "*.html"))
+	if err != nil {
+		return err
+	}
+	templates := make(map[string]*ht.Template, len(files))
+	var errs []error
+	for _, file := range files {
+		if file == layout {
+			continue
+		}
+		name := strings.TrimSuffix(path.Base(file), ".html")
+		page, err := ht.Must(base.Clone()).ParseFS(fsys, file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, required := range []string{"subject", "content"} {
+			if page.Lookup(required) == nil {
+				errs = append(errs, fmt.Errorf("email: %s doesn't define %q", file, required))
+			}
+		}
+		templates[name] = page
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	m.templates = templates
+	return nil
+}
+
+// SendTemplate queues the email template loaded by LoadTemplates, executed with data
+func (m *MailModel) SendTemplate(to, name string, data any) error {
+	_, err := m.QueueTemplate(to, "", name, data)
+	return err
+}
+
+// QueueTemplate queues the email template like SendTemplate, with a bcc, and
+// returns its id for OutboxStatus
+func (m *MailModel) QueueTemplate(to, bcc, name string, data any) (int64, error) {
+	return m.QueueMessage(NewMessage().To(to).Bcc(bcc).Template(name, data))
+}
+
+// renderTemplate executes a template into its subject, HTML with the CSS inlined and text
+func (m *MailModel) renderTemplate(name string, data any) (string, string, string, error) {
+	page, ok := m.templates[name]
+	if !ok {
+		return "", "", "", fmt.Errorf("%w: %s", ErrNoTemplate, name)
+	}
+	var subject, body, text bytes.Buffer
+	if err := page.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	if err := page.ExecuteTemplate(&body, "layout", data); err != nil {
+		return "", "", "", err
+	}
+	htmlBody := InlineCSS(body.String())
+	// html/template escapes the subject and text as if they were HTML
+	plainSubject := singleLine(html.UnescapeString(subject.String()))
+	if page.Lookup("text") == nil {
+		return plainSubject, htmlBody, HTMLToText(htmlBody), nil
+	}
+	if err := page.ExecuteTemplate(&text, "text", data); err != nil {
+		return "", "", "", err
+	}
+	return plainSubject, htmlBody, strings.TrimSpace(html.UnescapeString(text.String())), nil
+}
+
+// singleLine joins the lines of a subject written across several
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,133 @@
+package email
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// elements that start on a new line
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "div": true, "dl": true, "dt": true,
+	"dd": true, "footer": true, "form": true, "header": true, "main": true, "nav": true, "ol": true,
+	"section": true, "table": true, "tr": true, "ul": true,
+}
+
+// elements set apart by a blank line
+var paragraphElements = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true, "hr": true,
+}
+
+// elements whose content isn't shown
+var hiddenElements = map[string]bool{"head": true, "style": true, "script": true, "title": true, "template": true}
+
+var (
+	spaces     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText renders an HTML email as plain text for its text/plain part, with
+// links followed by their address and list items by dashes
+func HTMLToText(document string) string {
+	var b strings.Builder
+	newlines := 0
+	hidden := 0
+	pre := 0
+	// links waiting for their end tag to print the address
+	var links []string
+	breakLine := func(n int) {
+		if b.Len() == 0 {
+			return
+		}
+		for ; newlines < n; newlines++ {
+			b.WriteString("\n")
+		}
+	}
+	write := func(s string) {
+		if s == "" {
+			return
+		}
+		if newlines > 0 || b.Len() == 0 {
+			s = strings.TrimLeft(s, " ")
+		}
+		if s == "" {
+			return
+		}
+		b.WriteString(s)
+		newlines = 0
+	}
+
+	for _, token := range tokenizeHTML(document) {
+		switch token.Kind {
+		case textToken:
+			if hidden > 0 {
+				continue
+			}
+			text := html.UnescapeString(token.Raw)
+			if pre == 0 {
+				text = spaces.ReplaceAllString(text, " ")
+			}
+			write(text)
+		case startTagToken:
+			switch {
+			case hiddenElements[token.Name]:
+				if !isVoid(token) {
+					hidden++
+				}
+			case hidden > 0:
+			case token.Name == "br":
+				b.WriteString("\n")
+				newlines++
+			case token.Name == "li":
+				breakLine(1)
+				write("- ")
+			case token.Name == "td" || token.Name == "th":
+				write(" ")
+			case token.Name == "img":
+				if alt, _ := token.attr("alt"); alt != "" {
+					write(alt)
+				}
+			case token.Name == "a":
+				href, _ := token.attr("href")
+				links = append(links, href)
+			case paragraphElements[token.Name]:
+				breakLine(2)
+				if token.Name == "pre" {
+					pre++
+				}
+				if token.Name == "hr" {
+					write("----")
+					breakLine(2)
+				}
+			case blockElements[token.Name]:
+				breakLine(1)
+			}
+		case endTagToken:
+			switch {
+			case hiddenElements[token.Name]:
+				hidden = max(hidden-1, 0)
+			case hidden > 0:
+			case token.Name == "a" && len(links) > 0:
+				href := links[len(links)-1]
+				links = links[:len(links)-1]
+				if href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "mailto:") &&
+					!strings.HasSuffix(strings.TrimSpace(b.String()), href) {
+					write(" (" + href + ")")
+				}
+			case paragraphElements[token.Name]:
+				if token.Name == "pre" {
+					pre = max(pre-1, 0)
+				}
+				breakLine(2)
+			case blockElements[token.Name] || token.Name == "li":
+				breakLine(1)
+			}
+		}
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
@@ -0,0 +1,156 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport delivers a rendered RFC 5322 message to the envelope recipients,
+// which include Bcc addresses that don't appear in the message itself
+type Transport interface {
+	Send(ctx context.Context, from string, to []string, raw []byte) error
+}
+
+// Capturer is a transport that keeps the messages instead of delivering them,
+// listed newest first by the dev /_mail pages
+type Capturer interface {
+	Transport
+	Messages() []CapturedMessage
+	Reset() error
+}
+
+// CapturedMessage is a message kept by a Capturer
+type CapturedMessage struct {
+	// ID tells the message apart from the others of its Capturer
+	ID   string
+	From string
+	To   []string
+	Raw  []byte
+	Sent time.Time
+}
+
+// Header returns the decoded value of a header of the message
+func (m CapturedMessage) Header(key string) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Raw))
+	if err != nil {
+		return ""
+	}
+	value := msg.Header.Get(key)
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(value); err == nil {
+		return decoded
+	}
+	return value
+}
+
+// Subject returns the decoded subject of the message
+func (m CapturedMessage) Subject() string {
+	return m.Header("Subject")
+}
+
+// Text returns the decoded text/plain part of the message
+func (m CapturedMessage) Text() string {
+	return m.part("text/plain")
+}
+
+// HTML returns the decoded text/html part of the message
+func (m CapturedMessage) HTML() string {
+	return m.part("text/html")
+}
+
+func (m CapturedMessage) part(mediaType string) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Raw))
+	if err != nil {
+		return ""
+	}
+	return findPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, mediaType)
+}
+
+// findPart walks nested multipart bodies for the first part of mediaType
+func findPart(contentType, encoding string, body io.Reader, mediaType string) string {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	parsed, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(parsed, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				return ""
+			}
+			// the multipart reader already decodes quoted-printable parts
+			found := findPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, mediaType)
+			if found != "" {
+				return found
+			}
+		}
+	}
+	if parsed != mediaType {
+		return ""
+	}
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+var (
+	defaultTransport     Transport
+	defaultTransportOnce sync.Once
+)
+
+// transport is the model's Transport, or an SMTPTransport from config.env when it has none
+func (m *MailModel) transport() Transport {
+	if m.Transport != nil {
+		return m.Transport
+	}
+	return smtpDefault()
+}
+
+// send signs the message with the model's DKIMSigner, or the one from config.env
+// when it has none, and hands it to the transport
+func (m *MailModel) send(ctx context.Context, from string, to []string, raw []byte) error {
+	signer := m.DKIM
+	if signer == nil {
+		signer = dkimDefault()
+	}
+	return sendSigned(ctx, m.transport(), signer, from, to, raw)
+}
+
+func sendSigned(ctx context.Context, transport Transport, signer *DKIMSigner, from string, to []string, raw []byte) error {
+	if signer != nil {
+		signed, err := signer.Sign(raw)
+		if err != nil {
+			return fmt.Errorf("DKIM signing: %w", err)
+		}
+		raw = signed
+	}
+	return transport.Send(ctx, from, to, raw)
+}
+
+func smtpDefault() Transport {
+	defaultTransportOnce.Do(func() {
+		defaultTransport = NewSMTPTransport()
+	})
+	return defaultTransport
+}
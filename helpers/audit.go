@@ -0,0 +1,12 @@
+package helpers
+
+// AuditFunc records a security-relevant or data-changing event. Models call it
+// with the actor when they know who acted and an empty actor otherwise.
+type AuditFunc func(actor, action, target string, metadata map[string]any)
+
+// Emit calls f if it is set
+func (f AuditFunc) Emit(actor, action, target string, metadata map[string]any) {
+	if f != nil {
+		f(actor, action, target, metadata)
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"encoding/json"
 	"time"
 
-	"github.com/gofiber/storage/valkey"
+	"github.com/gofiber/fiber/v2"
 )
 
 type Bank struct {
-	storage *valkey.Storage
+	storage fiber.Storage
 	prefix  string
 }
 
@@ -23,7 +23,8 @@ type BankInterface interface {
 
 var _ BankInterface = (*Bank)(nil)
 
-func NewBank(storage *valkey.Storage, appName string) *Bank {
+// NewBank stores values under keys prefixed with the app name in any fiber storage
+func NewBank(storage fiber.Storage, appName string) *Bank {
 	return &Bank{storage: storage, prefix: appName + "-"}
 }
 
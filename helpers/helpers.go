@@ -383,8 +383,18 @@ func FolderExists(folderPath string) bool {
 
 // helper to create a database connection pool
 func OpenDB(dsn string) (*sql.DB, error) {
+	return OpenDBContext(context.Background(), dsn)
+}
+
+// helper to create a database connection pool, giving up when ctx is done
+func OpenDBContext(ctx context.Context, dsn string) (*sql.DB, error) {
+	return OpenDBDriver(ctx, "mysql", dsn)
+}
+
+// helper to create a database connection pool for any registered driver
+func OpenDBDriver(ctx context.Context, driver string, dsn string) (*sql.DB, error) {
 	// set maximum connection lifetime to prevent resource leaks
-	db, err := sql.Open("mysql", dsn)
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
@@ -395,7 +405,7 @@ func OpenDB(dsn string) (*sql.DB, error) {
 	db.SetMaxIdleConns(10)
 
 	// ping with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	err = db.PingContext(ctx)
@@ -488,6 +498,7 @@ func SaveTextToDirectory(text string, filePath string) error {
 	return nil
 }
 
+// Deprecated: errors are only logged and nothing records what was applied; use the migrate package.
 func RunMigration(migrationQuery string, db *sql.DB) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -518,6 +529,7 @@ func RunMigration(migrationQuery string, db *sql.DB) {
 	// log.Infof("migration executed, rows affected: %d", rowsAffected)
 }
 
+// Deprecated: errors are only logged and nothing records what was applied; use the migrate package.
 func MigrateUp(db *sql.DB, migrationQuery string, args map[string]string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -604,6 +616,20 @@ func Getenv(key string) string {
 	return val
 }
 
+// GetenvDefault reads an optional key from config.env, returning def when it isn't set
+func GetenvDefault(key, def string) string {
+	viper.SetConfigName("config")
+	viper.SetConfigType("env")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		log.Errorf("error reading config.env file: %v", err)
+	}
+	if !viper.IsSet(key) {
+		return def
+	}
+	return viper.GetString(strings.ToLower(key))
+}
+
 func ConvertPNGToJPG(inputPath, outputPath string) {
 	if FileExists(outputPath) {
 		return
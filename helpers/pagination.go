@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Pagination is what the pagination partial needs to link a page to its neighbours
+type Pagination struct {
+	Total   int
+	PrevURL string
+	NextURL string
+}
+
+// Paginate links to the pages before and after the current one by setting the
+// before or after query parameter to the cursors, keeping every other parameter
+// such as filters and sorting. An empty cursor leaves its link out.
+func Paginate(c *fiber.Ctx, total int, prev, next string) Pagination {
+	link := func(key, cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		values, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+		values.Del("after")
+		values.Del("before")
+		values.Set(key, cursor)
+		return c.Path() + "?" + values.Encode()
+	}
+	return Pagination{
+		Total:   total,
+		PrevURL: link("before", prev),
+		NextURL: link("after", next),
+	}
+}
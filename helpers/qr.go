@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 
 	"github.com/gofiber/fiber/v2"
@@ -39,6 +41,7 @@ type QR struct {
 
 type QRInterface interface {
 	Send(c *fiber.Ctx, message string) error
+	DataURI(message string) (string, error)
 }
 
 func (qr *QR) Send(c *fiber.Ctx, message string) error {
@@ -49,3 +52,27 @@ func (qr *QR) Send(c *fiber.Ctx, message string) error {
 	// c.Response().Header.Set("Cache-Control", "max-age=31536000, public")
 	return c.SendFile(jpegSavePath + ".jpeg")
 }
+
+// nopCloser lets the qr writer encode into a buffer
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// DataURI returns a PNG data URI of the QR code without writing it to disk,
+// for messages such as secrets that shouldn't be cached
+func (qr *QR) DataURI(message string) (string, error) {
+	code, err := qrc.New(message)
+	if err != nil {
+		return "", err
+	}
+	buf := nopCloser{new(bytes.Buffer)}
+	w := standard.NewWithWriter(buf, standard.WithBuiltinImageEncoder(standard.PNG_FORMAT))
+	if err := code.Save(w); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -221,6 +221,9 @@ func SessionLocalsMiddleware(store *session.Store) fiber.Handler {
 		// add user to locals
 		c.Locals("user", sess.Get("user"))
 
+		// add the admin behind an impersonated user to locals
+		c.Locals("impersonator", sess.Get("impersonator"))
+
 		// add old values to locals
 		if c.Query("show") == "retained" {
 			c.Locals("old", sess.Get("old"))
@@ -304,3 +307,22 @@ func (flash *FlashModel) RequireRedirect(redirectRoute string, keys ...string) f
 		return c.Next()
 	}
 }
+
+// SessionResetMiddleware drops sessions that can no longer be decoded, e.g. ones
+// saved before a change to a stored type, so the visitor starts a fresh session
+// instead of seeing an error on every request
+func SessionResetMiddleware(store *session.Store, cookieName string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := store.Get(c); err != nil {
+			id := c.Cookies(cookieName)
+			if id == "" {
+				return err
+			}
+			log.Infof("resetting undecodable session: %v", err)
+			if err := store.Delete(id); err != nil {
+				return err
+			}
+		}
+		return c.Next()
+	}
+}
@@ -2,11 +2,11 @@ package helpers
 
 import (
 	"database/sql"
-	"fmt"
 	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
 )
 
 type ShelfModelInterface interface {
@@ -17,25 +17,53 @@ type ShelfModelInterface interface {
 }
 
 type ShelfModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect dialect.Dialect
 }
 
 func (s *ShelfModel) Set(key string, value string) {
-	SetShelf(s.DB, key, value)
+	log.Infof("setting in shelf: %s", key)
+	d := dialect.Or(s.Dialect)
+	query := d.Rebind(d.Upsert("shelf", []string{"name"}, []string{"name", "value"}, 1))
+	result, err := s.DB.Exec(query, key, value)
+	if err != nil {
+		log.Errorf("failed to insert/update key: %v", key)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		log.Errorf("failed to check the affected rows: %v", err)
+		return
+	}
+	if rowsAffected == 0 {
+		log.Errorf("%v", sql.ErrNoRows)
+		return
+	}
+	log.Infof("updated key-value pair: (%s, %s)", key, value)
 }
 
 func (s *ShelfModel) Get(key string) string {
-	return GetShelf(s.DB, key)
+	log.Infof("retrieving from shelf: %s", key)
+
+	var value string
+	query := dialect.Or(s.Dialect).Rebind("SELECT value FROM shelf WHERE name = ?")
+	err := s.DB.QueryRow(query, key).Scan(&value)
+	if err != nil {
+		log.Errorf("shelf value not found for key %s", key)
+		return ""
+	}
+	return value
 }
 
 func (s *ShelfModel) GetMany(filter string) map[string]string {
 	query := `
-	SELECT name,value FROM shelf WHERE name LIKE '%` + filter + `%'
+	SELECT name,value FROM shelf WHERE name LIKE ?
 	`
-	rows, err := s.DB.Query(query)
+	rows, err := s.DB.Query(dialect.Or(s.Dialect).Rebind(query), "%"+filter+"%")
 	if err != nil {
 		return map[string]string{}
 	}
+	defer rows.Close()
 	values := make(map[string]string)
 	for rows.Next() {
 		name := ""
@@ -50,20 +78,15 @@ func (s *ShelfModel) GetMany(filter string) map[string]string {
 }
 
 func (s *ShelfModel) SetMany(pairs map[string]string) error {
-	placeholders := make([]string, len(pairs))
+	if len(pairs) == 0 {
+		return nil
+	}
 	values := make([]interface{}, 0)
-	count := 0
 	for i, pair := range pairs {
-		placeholders[count] = "(?, ?)"
-		count++
 		values = append(values, i, pair)
 	}
-	query := fmt.Sprintf(`
-		INSERT INTO shelf (name, value)
-		VALUES %s
-		ON DUPLICATE KEY UPDATE
-			value = VALUES(value)
-		`, strings.Join(placeholders, ","))
+	d := dialect.Or(s.Dialect)
+	query := d.Rebind(d.Upsert("shelf", []string{"name"}, []string{"name", "value"}, len(pairs)))
 	_, err := s.DB.Exec(query, values...)
 	// log.Infof("inserting multiple values:\n%v", query)
 	if err != nil {
@@ -74,44 +97,17 @@ func (s *ShelfModel) SetMany(pairs map[string]string) error {
 	return nil
 }
 
+// SetShelf stores a value in a MySQL shelf; use ShelfModel for other dialects
 func SetShelf(db *sql.DB, key string, value string) {
-	log.Infof("setting in shelf: %s", key)
-	query := `
-		INSERT INTO shelf (name, value)
-		VALUES (?, ?)
-		ON DUPLICATE KEY UPDATE
-			value = VALUES(value)
-		`
-	result, err := db.Exec(query, key, value)
-	if err != nil {
-		log.Errorf("failed to insert/update key: %v", key)
-		return
-	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Errorf("failed to check the affected rows: %v", err)
-		return
-	}
-	if rowsAffected == 0 {
-		log.Errorf("%v", sql.ErrNoRows)
-		return
-	}
-	log.Infof("updated key-value pair: (%s, %s)", key, value)
+	(&ShelfModel{DB: db}).Set(key, value)
 }
 
+// GetShelf retrieves a value from a MySQL shelf; use ShelfModel for other dialects
 func GetShelf(db *sql.DB, key string) string {
-	log.Infof("retrieving from shelf: %s", key)
-
-	var value string
-	query := "SELECT value FROM shelf WHERE name = ?"
-	err := db.QueryRow(query, key).Scan(&value)
-	if err != nil {
-		log.Errorf("shelf value not found for key %s", key)
-		return ""
-	}
-	return value
+	return (&ShelfModel{DB: db}).Get(key)
 }
 
+// Deprecated: the shelf table is created by the core migrations.
 func InitShelf(db *sql.DB, appName string) {
 	RunMigration(strings.ReplaceAll(`
 	-- Select database
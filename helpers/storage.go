@@ -0,0 +1,216 @@
+package helpers
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/joashgobin/boiler/dialect"
+)
+
+var (
+	_ fiber.Storage = (*MemoryStorage)(nil)
+	_ fiber.Storage = (*SQLStorage)(nil)
+)
+
+type memoryEntry struct {
+	value  []byte
+	expiry int64
+}
+
+// MemoryStorage keeps values in process memory, so it is not shared between preforked processes
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	data map[string]memoryEntry
+	done chan struct{}
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	s := &MemoryStorage{
+		data: make(map[string]memoryEntry),
+		done: make(chan struct{}),
+	}
+	go s.gc(10 * time.Second)
+	return s
+}
+
+func (s *MemoryStorage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+	s.mu.RLock()
+	entry, ok := s.data[key]
+	s.mu.RUnlock()
+	if !ok || (entry.expiry != 0 && entry.expiry <= time.Now().Unix()) {
+		return nil, nil
+	}
+	// copy the value so callers can't change the stored one
+	value := make([]byte, len(entry.value))
+	copy(value, entry.value)
+	return value, nil
+}
+
+func (s *MemoryStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	var expiry int64
+	if exp != 0 {
+		expiry = time.Now().Add(exp).Unix()
+	}
+	// copy the value since fiber reuses its buffers
+	value := make([]byte, len(val))
+	copy(value, val)
+
+	s.mu.Lock()
+	s.data[key] = memoryEntry{value: value, expiry: expiry}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	s.mu.Lock()
+	delete(s.data, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) Reset() error {
+	s.mu.Lock()
+	s.data = make(map[string]memoryEntry)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			now := time.Now().Unix()
+			s.mu.Lock()
+			for key, entry := range s.data {
+				if entry.expiry != 0 && entry.expiry <= now {
+					delete(s.data, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// SQLStorage keeps values in a database table, sharing them between processes without valkey
+type SQLStorage struct {
+	db      *sql.DB
+	dialect dialect.Dialect
+	table   string
+	done    chan struct{}
+}
+
+// NewSQLStorage uses a table with the columns k VARCHAR(255) PRIMARY KEY, v <blob>
+// and e BIGINT, such as fiber_storage made by the boiler migrations, failing
+// when the table doesn't have them; the database is not closed by Close
+func NewSQLStorage(db *sql.DB, d dialect.Dialect, table string) (*SQLStorage, error) {
+	d = dialect.Or(d)
+	if table == "" {
+		table = "fiber_storage"
+	}
+	// reads no rows, only checks the columns are there
+	rows, err := db.Query(fmt.Sprintf("SELECT k, v, e FROM %s WHERE 1 = 0", table))
+	if err != nil {
+		return nil, fmt.Errorf("storage table %s: %w", table, err)
+	}
+	rows.Close()
+	s := &SQLStorage{db: db, dialect: d, table: table, done: make(chan struct{})}
+	go s.gc(10 * time.Second)
+	return s, nil
+}
+
+func (s *SQLStorage) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, nil
+	}
+	var value []byte
+	var expiry int64
+	query := s.dialect.Rebind(fmt.Sprintf("SELECT v, e FROM %s WHERE k = ?", s.table))
+	err := s.db.QueryRow(query, key).Scan(&value, &expiry)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expiry != 0 && expiry <= time.Now().Unix() {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func (s *SQLStorage) Set(key string, val []byte, exp time.Duration) error {
+	if key == "" || len(val) == 0 {
+		return nil
+	}
+	var expiry int64
+	if exp != 0 {
+		expiry = time.Now().Add(exp).Unix()
+	}
+	query := s.dialect.Rebind(s.dialect.Upsert(s.table, []string{"k"}, []string{"k", "v", "e"}, 1))
+	_, err := s.db.Exec(query, key, val, expiry)
+	return err
+}
+
+func (s *SQLStorage) Delete(key string) error {
+	if key == "" {
+		return nil
+	}
+	_, err := s.db.Exec(s.dialect.Rebind(fmt.Sprintf("DELETE FROM %s WHERE k = ?", s.table)), key)
+	return err
+}
+
+func (s *SQLStorage) Reset() error {
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s", s.table))
+	return err
+}
+
+func (s *SQLStorage) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
+
+func (s *SQLStorage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	query := s.dialect.Rebind(fmt.Sprintf("DELETE FROM %s WHERE e <> 0 AND e <= ?", s.table))
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.db.Exec(query, time.Now().Unix()); err != nil {
+				log.Errorf("storage gc error: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package helpers_test
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/helpers"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newSQLStorage(t *testing.T) *helpers.SQLStorage {
+	t.Helper()
+	d := dialect.SQLite{Driver: "sqlite3"}
+	db, err := sql.Open(d.DriverName(), d.DSN(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migration, err := os.ReadFile("../core/migrations/0023_create_fiber_storage.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(dialect.Replace(d, string(migration))); err != nil {
+		t.Fatal(err)
+	}
+	s, err := helpers.NewSQLStorage(db, d, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	storages := map[string]func(t *testing.T) fiber.Storage{
+		"memory": func(t *testing.T) fiber.Storage {
+			s := helpers.NewMemoryStorage()
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"sql": func(t *testing.T) fiber.Storage {
+			return newSQLStorage(t)
+		},
+	}
+	for name, open := range storages {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+
+			value := []byte("value")
+			if err := s.Set("key", value, 0); err != nil {
+				t.Fatal(err)
+			}
+			// the stored value mustn't follow the caller's buffer
+			value[0] = 'X'
+			got, err := s.Get("key")
+			if err != nil || string(got) != "value" {
+				t.Fatalf("Get = %q, %v; want value", got, err)
+			}
+			// nor the buffer Get returned
+			got[0] = 'X'
+			if got, _ := s.Get("key"); string(got) != "value" {
+				t.Fatalf("Get after changing the result = %q; want value", got)
+			}
+
+			if err := s.Set("key", []byte("replaced"), 0); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := s.Get("key"); string(got) != "replaced" {
+				t.Fatalf("Get after Set = %q; want replaced", got)
+			}
+
+			if err := s.Set("expired", []byte("value"), -time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := s.Get("expired"); got != nil {
+				t.Fatalf("Get of an expired key = %q; want nil", got)
+			}
+
+			if err := s.Delete("key"); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := s.Get("key"); got != nil {
+				t.Fatalf("Get after Delete = %q; want nil", got)
+			}
+
+			if err := s.Set("other", []byte("value"), time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Reset(); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := s.Get("other"); !bytes.Equal(got, nil) {
+				t.Fatalf("Get after Reset = %q; want nil", got)
+			}
+		})
+	}
+}
+
+func TestNewSQLStorageChecksTable(t *testing.T) {
+	d := dialect.SQLite{Driver: "sqlite3"}
+	db, err := sql.Open(d.DriverName(), d.DSN(t.TempDir(), "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE sessions (k VARCHAR(255) PRIMARY KEY, v BLOB)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"fiber_storage", "sessions"} {
+		if _, err := helpers.NewSQLStorage(db, d, table); err == nil {
+			t.Errorf("NewSQLStorage accepted %s, which lacks the storage columns", table)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Run executes an up, down or status command against the runners in order,
+// writing a summary to w. Down reverts a single migration from the last
+// runner that has one applied.
+func Run(ctx context.Context, command string, w io.Writer, runners ...*Runner) error {
+	switch command {
+	case "up":
+		total := 0
+		for _, r := range runners {
+			applied, err := r.Up(ctx)
+			for _, migration := range applied {
+				fmt.Fprintf(w, "applied %s %04d_%s\n", r.scope, migration.Version, migration.Name)
+			}
+			total += len(applied)
+			if err != nil {
+				return err
+			}
+		}
+		if total == 0 {
+			fmt.Fprintln(w, "no pending migrations")
+		}
+		return nil
+	case "down":
+		for i := len(runners) - 1; i >= 0; i-- {
+			reverted, err := runners[i].Down(ctx)
+			if err != nil {
+				return err
+			}
+			if reverted != nil {
+				fmt.Fprintf(w, "reverted %s %04d_%s\n", runners[i].scope, reverted.Version, reverted.Name)
+				return nil
+			}
+		}
+		fmt.Fprintln(w, "no applied migrations")
+		return nil
+	case "status":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SCOPE\tMIGRATION\tSTATUS")
+		for _, r := range runners {
+			statuses, err := r.Status(ctx)
+			if err != nil {
+				return err
+			}
+			for _, status := range statuses {
+				state := "pending"
+				if status.Applied {
+					state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+				}
+				if status.Modified {
+					state += " (modified)"
+				}
+				fmt.Fprintf(tw, "%s\t%04d_%s\t%s\n", status.Scope, status.Version, status.Name, state)
+			}
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+	}
+}
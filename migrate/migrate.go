@@ -0,0 +1,353 @@
+// Package migrate applies versioned SQL migrations read from an fs.FS and
+// records them in a schema_migrations table.
+//
+// Migration files are named <version>_<name>.up.sql with an optional
+// <version>_<name>.down.sql, e.g. 0001_create_users.up.sql. A file named
+// <version>_<name>.<dialect>.up.sql replaces the generic one for that dialect,
+// and <autoincrement>, <datetime> and <blob> are replaced with the column
+// types of the dialect. Steps that SQL can't express portably, such as
+// reshaping data, are written in Go and added with Runner.Register.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/joashgobin/boiler/dialect"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("migrate: applied migration has been modified")
+	ErrNoDownMigration  = errors.New("migrate: no down migration")
+	ErrLocked           = errors.New("migrate: failed to acquire migration lock")
+	ErrUnknownCommand   = errors.New("migrate: unknown command, expected up, down or status")
+)
+
+var fileRegex = regexp.MustCompile(`^(\d+)_(.+?)(?:\.(mysql|sqlite|postgres))?\.(up|down)\.sql$`)
+
+// Func is a migration step written in Go, run in the migration's transaction
+type Func func(ctx context.Context, tx *sql.Tx, d dialect.Dialect) error
+
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+
+	// UpFunc and DownFunc replace Up and Down for migrations added with Register
+	UpFunc   Func
+	DownFunc Func
+}
+
+type Status struct {
+	Scope     string
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool
+}
+
+// Runner applies the migrations of a single scope, so that boiler and app
+// migrations can share the schema_migrations table without version clashes
+type Runner struct {
+	db         *sql.DB
+	dialect    dialect.Dialect
+	scope      string
+	migrations []Migration
+}
+
+// New reads the migrations in dir of fsys for the given scope, picking the files
+// meant for the dialect (MySQL when nil)
+func New(db *sql.DB, d dialect.Dialect, scope string, fsys fs.FS, dir string) (*Runner, error) {
+	d = dialect.Or(d)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: failed to read %s: %w", dir, err)
+	}
+	byVersion := make(map[int64]*Migration)
+	// dialect-specific files win over generic ones whatever the directory order
+	specific := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := fileRegex.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		if matches[3] != "" && matches[3] != d.Name() {
+			continue
+		}
+		kind := matches[1] + "." + matches[4]
+		if matches[3] == "" && specific[kind] {
+			continue
+		}
+		if matches[3] != "" {
+			specific[kind] = true
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: invalid version in %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: failed to read %s: %w", entry.Name(), err)
+		}
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: version %d used by both %s and %s", version, migration.Name, matches[2])
+		}
+		if matches[4] == "up" {
+			migration.Up = string(content)
+			hash := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(hash[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	r := &Runner{db: db, dialect: d, scope: scope}
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d (%s) has no up migration", migration.Version, migration.Name)
+		}
+		r.migrations = append(r.migrations, *migration)
+	}
+	sort.Slice(r.migrations, func(i, j int) bool {
+		return r.migrations[i].Version < r.migrations[j].Version
+	})
+	return r, nil
+}
+
+// Register adds a Go migration between the SQL ones. Since code can't be hashed
+// like a file, its checksum only covers the version and name.
+func (r *Runner) Register(version int64, name string, up, down Func) error {
+	if up == nil {
+		return fmt.Errorf("migrate: version %d (%s) has no up migration", version, name)
+	}
+	for _, migration := range r.migrations {
+		if migration.Version == version {
+			return fmt.Errorf("migrate: version %d used by both %s and %s", version, migration.Name, name)
+		}
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("go:%d_%s", version, name)))
+	r.migrations = append(r.migrations, Migration{
+		Version:  version,
+		Name:     name,
+		Checksum: hex.EncodeToString(hash[:]),
+		UpFunc:   up,
+		DownFunc: down,
+	})
+	sort.Slice(r.migrations, func(i, j int) bool {
+		return r.migrations[i].Version < r.migrations[j].Version
+	})
+	return nil
+}
+
+func (r *Runner) Scope() string {
+	return r.scope
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies all pending migrations in order and returns the ones applied
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range r.migrations {
+			if record, exists := done[migration.Version]; exists {
+				if record.checksum != migration.Checksum {
+					return fmt.Errorf("%w: %s version %d (%s)", ErrChecksumMismatch, r.scope, migration.Version, migration.Name)
+				}
+				continue
+			}
+			if err := r.apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the most recently applied migration, returning nil if none are applied
+func (r *Runner) Down(ctx context.Context) (*Migration, error) {
+	var reverted *Migration
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := r.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(r.migrations) - 1; i >= 0; i-- {
+			migration := r.migrations[i]
+			if _, exists := done[migration.Version]; !exists {
+				continue
+			}
+			if migration.Down == "" && migration.DownFunc == nil {
+				return fmt.Errorf("%w: %s version %d (%s)", ErrNoDownMigration, r.scope, migration.Version, migration.Name)
+			}
+			if err := r.revert(ctx, conn, migration); err != nil {
+				return err
+			}
+			reverted = &migration
+			return nil
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status lists every known migration and whether it has been applied
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := r.ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	done, err := r.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(r.migrations))
+	for _, migration := range r.migrations {
+		status := Status{Scope: r.scope, Version: migration.Version, Name: migration.Name}
+		if record, exists := done[migration.Version]; exists {
+			status.Applied = true
+			status.AppliedAt = record.appliedAt
+			status.Modified = record.checksum != migration.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (r *Runner) apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if migration.UpFunc != nil {
+		err = migration.UpFunc(ctx, tx, r.dialect)
+	} else {
+		_, err = tx.ExecContext(ctx, dialect.Replace(r.dialect, migration.Up))
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: %s version %d (%s) failed: %w", r.scope, migration.Version, migration.Name, err)
+	}
+	_, err = tx.ExecContext(ctx, r.dialect.Rebind(`
+	INSERT INTO schema_migrations (scope, version, name, checksum, applied_at)
+	VALUES (?, ?, ?, ?, ?)
+	`), r.scope, migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("migrate: failed to record %s version %d: %w", r.scope, migration.Version, err)
+	}
+	return tx.Commit()
+}
+
+func (r *Runner) revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if migration.DownFunc != nil {
+		err = migration.DownFunc(ctx, tx, r.dialect)
+	} else {
+		_, err = tx.ExecContext(ctx, dialect.Replace(r.dialect, migration.Down))
+	}
+	if err != nil {
+		return fmt.Errorf("migrate: reverting %s version %d (%s) failed: %w", r.scope, migration.Version, migration.Name, err)
+	}
+	_, err = tx.ExecContext(ctx, r.dialect.Rebind(`
+	DELETE FROM schema_migrations WHERE scope = ? AND version = ?
+	`), r.scope, migration.Version)
+	if err != nil {
+		return fmt.Errorf("migrate: failed to unrecord %s version %d: %w", r.scope, migration.Version, err)
+	}
+	return tx.Commit()
+}
+
+func (r *Runner) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, r.dialect.Rebind(`
+	SELECT version, checksum, applied_at FROM schema_migrations WHERE scope = ?
+	`), r.scope)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+	done := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var record appliedMigration
+		if err := rows.Scan(&version, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = record
+	}
+	return done, rows.Err()
+}
+
+// withLock holds an advisory lock on a single connection so that preforked
+// processes and parallel deployments don't apply the same migration twice
+func (r *Runner) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	release, err := r.dialect.Lock(ctx, conn, "schema_migrations")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLocked, err)
+	}
+	defer release()
+
+	if err := r.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func (r *Runner) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, dialect.Replace(r.dialect, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		scope VARCHAR(50) NOT NULL,
+		version BIGINT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at <datetime> NOT NULL,
+		PRIMARY KEY (scope, version)
+	)
+	`))
+	if err != nil {
+		return fmt.Errorf("migrate: failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package migrate_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/joashgobin/boiler/dialect"
+	"github.com/joashgobin/boiler/migrate"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testDialect = dialect.SQLite{Driver: "sqlite3"}
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(testDialect.DriverName(), testDialect.DSN(t.TempDir(), "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testFS holds two migrations, the second with a SQLite version that wins over the generic one
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/0001_create_notes.up.sql":         {Data: []byte(`CREATE TABLE notes (id <autoincrement>, body VARCHAR(255) NOT NULL);`)},
+		"migrations/0001_create_notes.down.sql":       {Data: []byte(`DROP TABLE notes;`)},
+		"migrations/0002_add_created.up.sql":          {Data: []byte(`ALTER TABLE notes ADD COLUMN created BOGUS SYNTAX;`)},
+		"migrations/0002_add_created.sqlite.up.sql":   {Data: []byte(`ALTER TABLE notes ADD COLUMN created <datetime> NULL;`)},
+		"migrations/0002_add_created.postgres.up.sql": {Data: []byte(`ALTER TABLE notes ADD COLUMN created TIMESTAMP NULL;`)},
+		"migrations/0002_add_created.sqlite.down.sql": {Data: []byte(`ALTER TABLE notes DROP COLUMN created;`)},
+		"migrations/README.md":                        {Data: []byte(`not a migration`)},
+		"migrations/0003_not_in_this_dir/0003.up.sql": {Data: []byte(`not a migration either`)},
+	}
+}
+
+func newRunner(t *testing.T, db *sql.DB, scope string, fsys fstest.MapFS) *migrate.Runner {
+	t.Helper()
+	runner, err := migrate.New(db, testDialect, scope, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runner
+}
+
+func names(migrations []migrate.Migration) []string {
+	var names []string
+	for _, migration := range migrations {
+		names = append(names, migration.Name)
+	}
+	return names
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	runner := newRunner(t, db, "app", testFS())
+
+	applied, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(applied), ","); got != "create_notes,add_created" {
+		t.Fatalf("Up applied %s; want create_notes,add_created", got)
+	}
+	if _, err := db.Exec(`INSERT INTO notes (body, created) VALUES ('hello', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("the SQLite version of add_created wasn't applied: %v", err)
+	}
+	if applied, err := runner.Up(ctx); err != nil || len(applied) != 0 {
+		t.Fatalf("second Up applied %v, %v; want nothing", names(applied), err)
+	}
+
+	steps := []string{"add_created", "create_notes", ""}
+	for _, want := range steps {
+		reverted, err := runner.Down(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if reverted != nil {
+			got = reverted.Name
+		}
+		if got != want {
+			t.Fatalf("Down reverted %q; want %q", got, want)
+		}
+	}
+	if _, err := db.Exec(`SELECT * FROM notes`); err == nil {
+		t.Fatal("notes still exists after reverting every migration")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "version used twice",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql": {Data: []byte(`SELECT 1;`)},
+				"migrations/0001_b.up.sql": {Data: []byte(`SELECT 1;`)},
+			},
+			wantErr: "version 1 used by both",
+		},
+		{
+			name: "down without up",
+			files: fstest.MapFS{
+				"migrations/0001_a.down.sql": {Data: []byte(`SELECT 1;`)},
+			},
+			wantErr: "has no up migration",
+		},
+		{
+			name:    "missing directory",
+			files:   fstest.MapFS{},
+			wantErr: "failed to read migrations",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := migrate.New(newTestDB(t), testDialect, "app", tt.files, "migrations")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("New error = %v; want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestModifiedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	if _, err := newRunner(t, db, "app", testFS()).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	files := testFS()
+	files["migrations/0001_create_notes.up.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE notes (id <autoincrement>);`)}
+	runner := newRunner(t, db, "app", files)
+	if _, err := runner.Up(ctx); !errors.Is(err, migrate.ErrChecksumMismatch) {
+		t.Fatalf("Up after editing an applied migration = %v; want ErrChecksumMismatch", err)
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Applied || !statuses[0].Modified || statuses[1].Modified {
+		t.Fatalf("statuses = %+v; want only the first modified", statuses)
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	files := testFS()
+	files["migrations/0003_broken.up.sql"] = &fstest.MapFile{Data: []byte(`INSERT INTO notes (body) VALUES ('kept?'); NOT SQL;`)}
+	runner := newRunner(t, db, "app", files)
+
+	applied, err := runner.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "version 3 (broken) failed") {
+		t.Fatalf("Up error = %v; want version 3 to fail", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("Up applied %v; want the two migrations before the broken one", names(applied))
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[2].Applied {
+		t.Fatal("the broken migration was recorded as applied")
+	}
+	var notes int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&notes); err != nil || notes != 0 {
+		t.Fatalf("notes = %d, %v; want the broken migration rolled back", notes, err)
+	}
+}
+
+func TestNoDownMigration(t *testing.T) {
+	ctx := context.Background()
+	files := fstest.MapFS{"migrations/0001_create_notes.up.sql": testFS()["migrations/0001_create_notes.up.sql"]}
+	runner := newRunner(t, newTestDB(t), "app", files)
+	if _, err := runner.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Down(ctx); !errors.Is(err, migrate.ErrNoDownMigration) {
+		t.Fatalf("Down = %v; want ErrNoDownMigration", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	runner := newRunner(t, db, "app", testFS())
+
+	var ran []string
+	up := func(ctx context.Context, tx *sql.Tx, d dialect.Dialect) error {
+		ran = append(ran, "up")
+		_, err := tx.ExecContext(ctx, `INSERT INTO notes (body) VALUES ('from go')`)
+		return err
+	}
+	down := func(ctx context.Context, tx *sql.Tx, d dialect.Dialect) error {
+		ran = append(ran, "down")
+		_, err := tx.ExecContext(ctx, `DELETE FROM notes`)
+		return err
+	}
+	if err := runner.Register(2, "clash", up, down); err == nil {
+		t.Fatal("Register allowed a version the files already use")
+	}
+	if err := runner.Register(5, "no_up", nil, down); err == nil {
+		t.Fatal("Register allowed a migration without up")
+	}
+	if err := runner.Register(3, "seed_notes", up, down); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names(applied), ","); got != "create_notes,add_created,seed_notes" {
+		t.Fatalf("Up applied %s; want the Go migration last", got)
+	}
+	if reverted, err := runner.Down(ctx); err != nil || reverted.Name != "seed_notes" {
+		t.Fatalf("Down = %v, %v; want seed_notes", reverted, err)
+	}
+	if got := strings.Join(ran, ","); got != "up,down" {
+		t.Fatalf("Go migration ran %s; want up,down", got)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	boiler := newRunner(t, db, "boiler", fstest.MapFS{
+		"migrations/0001_create_users.up.sql":   {Data: []byte(`CREATE TABLE users (id <autoincrement>);`)},
+		"migrations/0001_create_users.down.sql": {Data: []byte(`DROP TABLE users;`)},
+	})
+	app := newRunner(t, db, "app", testFS())
+
+	var out bytes.Buffer
+	if err := migrate.Run(ctx, "up", &out, boiler, app); err != nil {
+		t.Fatal(err)
+	}
+	want := "applied boiler 0001_create_users\napplied app 0001_create_notes\napplied app 0002_add_created\n"
+	if out.String() != want {
+		t.Fatalf("up printed %q; want %q", out.String(), want)
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"up", "no pending migrations\n"},
+		// down reverts from the last runner first
+		{"down", "reverted app 0002_add_created\n"},
+		{"down", "reverted app 0001_create_notes\n"},
+		{"down", "reverted boiler 0001_create_users\n"},
+		{"down", "no applied migrations\n"},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		if err := migrate.Run(ctx, tt.command, &out, boiler, app); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Fatalf("%s printed %q; want %q", tt.command, out.String(), tt.want)
+		}
+	}
+
+	out.Reset()
+	if err := migrate.Run(ctx, "status", &out, boiler, app); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(out.String(), "pending"); got != 3 {
+		t.Fatalf("status = %q; want 3 pending migrations", out.String())
+	}
+	if err := migrate.Run(ctx, "sideways", &out, boiler, app); !errors.Is(err, migrate.ErrUnknownCommand) {
+		t.Fatalf("unknown command = %v; want ErrUnknownCommand", err)
+	}
+}
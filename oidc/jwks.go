@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// how often unknown key ids may trigger a refetch of the provider's keys
+const keyRefreshInterval = time.Minute
+
+type publicKey struct {
+	ecdsa *ecdsa.PublicKey
+	rsa   *rsa.PublicKey
+}
+
+func (key publicKey) verify(alg string, signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	switch {
+	case alg == "RS256" && key.rsa != nil:
+		if rsa.VerifyPKCS1v15(key.rsa, crypto.SHA256, digest[:], signature) != nil {
+			return ErrSignature
+		}
+	case alg == "ES256" && key.ecdsa != nil:
+		// JWS signatures are r and s concatenated rather than ASN.1
+		if len(signature) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key.ecdsa, digest[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrToken, alg)
+	}
+	return nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (publicKey, bool) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := encoding.DecodeString(k.N)
+		if err != nil || len(n) < 256 {
+			return publicKey{}, false
+		}
+		e, err := encoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, false
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return publicKey{rsa: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, true
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, errX := encoding.DecodeString(k.X)
+		y, errY := encoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, false
+		}
+		point := append(append([]byte{4}, x...), y...)
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return publicKey{}, false
+		}
+		return publicKey{ecdsa: pub}, true
+	}
+	return publicKey{}, false
+}
+
+// key returns the provider's signing key with the id, fetching the key set
+// again when the provider may have rotated its keys
+func (p *Provider) key(ctx context.Context, kid string) (publicKey, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return publicKey{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, exists := p.keys[kid]; exists {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keyRefreshInterval {
+		return publicKey{}, fmt.Errorf("%w: unknown key %q", ErrToken, kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return publicKey{}, fmt.Errorf("%w: fetching keys: %v", ErrDiscovery, err)
+	}
+	p.keysFetched = time.Now()
+	p.keys = make(map[string]publicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, ok := k.publicKey(); ok {
+			p.keys[k.Kid] = key
+		}
+	}
+	key, exists := p.keys[kid]
+	if !exists {
+		return publicKey{}, fmt.Errorf("%w: unknown key %q", ErrToken, kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,346 @@
+// Package oidc signs users in with an OpenID Connect provider.
+//
+// It implements the authorization code flow with PKCE: discovery, the
+// authorization URL, the code exchange and verification of the RS256 or ES256
+// signed ID token against the provider's published keys. Plain http issuers
+// are accepted so the flow can run against a local stub server.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDiscovery = errors.New("oidc: provider discovery failed")
+	ErrExchange  = errors.New("oidc: code exchange failed")
+	ErrToken     = errors.New("oidc: invalid id token")
+	ErrSignature = errors.New("oidc: invalid id token signature")
+)
+
+// clock skew allowed when checking token times
+const leeway = time.Minute
+
+var encoding = base64.RawURLEncoding
+
+// Provider is an OpenID Connect provider the app is registered with
+type Provider struct {
+	// Name is used in URLs and to link identities, e.g. google
+	Name string
+	// Label is shown on the sign in button, e.g. Google
+	Label        string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes defaults to openid, email and profile
+	Scopes []string
+	// HTTPClient defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+
+	mu          sync.Mutex
+	discovery   *Discovery
+	keys        map[string]publicKey
+	keysFetched time.Time
+}
+
+// Discovery is the part of the provider's /.well-known/openid-configuration the flow needs
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Flow holds the secrets of one sign in attempt, kept in the session between
+// the redirect to the provider and the callback
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// Claims are the verified claims of an ID token
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// UnmarshalJSON also accepts email_verified as a "true" or "false" string, which some providers send
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	var claims struct {
+		plain
+		EmailVerified boolean `json:"email_verified"`
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return err
+	}
+	*c = Claims(claims.plain)
+	c.EmailVerified = bool(claims.EmailVerified)
+	return nil
+}
+
+// audience is a single string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+type boolean bool
+
+func (b *boolean) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("oidc: invalid boolean %s", data)
+	}
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// NewFlow returns fresh state, nonce and PKCE verifier values
+func NewFlow() (Flow, error) {
+	var flow Flow
+	var err error
+	if flow.State, err = randomString(); err != nil {
+		return Flow{}, err
+	}
+	if flow.Nonce, err = randomString(); err != nil {
+		return Flow{}, err
+	}
+	if flow.Verifier, err = randomString(); err != nil {
+		return Flow{}, err
+	}
+	return flow, nil
+}
+
+// Challenge is the S256 PKCE challenge for the flow's verifier
+func (f Flow) Challenge() string {
+	hash := sha256.Sum256([]byte(f.Verifier))
+	return encoding.EncodeToString(hash[:])
+}
+
+func (p *Provider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Discover fetches the provider configuration once and caches it
+func (p *Provider) Discover(ctx context.Context) (Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+	var discovery Discovery
+	endpoint := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, endpoint, &discovery); err != nil {
+		return Discovery{}, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if discovery.Issuer != p.Issuer {
+		return Discovery{}, fmt.Errorf("%w: issuer %q doesn't match %q", ErrDiscovery, discovery.Issuer, p.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return Discovery{}, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+	p.discovery = &discovery
+	return discovery, nil
+}
+
+// AuthCodeURL is where the user is sent to sign in with the provider
+func (p *Provider) AuthCodeURL(ctx context.Context, flow Flow) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {flow.Challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the code from the callback for an ID token and returns its verified claims
+func (p *Provider) Exchange(ctx context.Context, code string, flow Flow) (Claims, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {flow.Verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return Claims{}, fmt.Errorf("%w: %s: %v", ErrExchange, resp.Status, err)
+	}
+	if token.Error != "" {
+		return Claims{}, fmt.Errorf("%w: %s %s", ErrExchange, token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return Claims{}, fmt.Errorf("%w: %s without an id token", ErrExchange, resp.Status)
+	}
+	return p.VerifyIDToken(ctx, token.IDToken, flow.Nonce)
+}
+
+// VerifyIDToken checks the token's signature, issuer, audience, expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: not a jwt", ErrToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, err
+	}
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrToken, err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := key.verify(header.Alg, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, err
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.Issuer:
+		return Claims{}, fmt.Errorf("%w: issuer %q", ErrToken, claims.Issuer)
+	case !claims.Audience.contains(p.ClientID):
+		return Claims{}, fmt.Errorf("%w: audience %v", ErrToken, []string(claims.Audience))
+	case now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrToken)
+	case claims.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrToken)
+	case claims.Nonce != nonce:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrToken)
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrToken)
+	}
+	return claims, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := encoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrToken, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrToken, err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joashgobin/boiler/oidc"
+	"github.com/joashgobin/boiler/oidc/oidctest"
+)
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name string
+		// stub changes the provider before the code is exchanged
+		stub func(s *oidctest.Server)
+		// flow changes what the app kept in the session between the redirect and the callback
+		flow func(flow *oidc.Flow)
+		want error
+	}{
+		{name: "RS256"},
+		{name: "ES256", stub: func(s *oidctest.Server) { s.Algorithm = "ES256" }},
+		{
+			name: "wrong PKCE verifier",
+			flow: func(flow *oidc.Flow) { flow.Verifier += "x" },
+			want: oidc.ErrExchange,
+		},
+		{
+			name: "nonce mismatch",
+			flow: func(flow *oidc.Flow) { flow.Nonce += "x" },
+			want: oidc.ErrToken,
+		},
+		{
+			name: "another audience",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["aud"] = "another-client" }
+			},
+			want: oidc.ErrToken,
+		},
+		{
+			name: "another issuer",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["iss"] = "https://issuer.example.com" }
+			},
+			want: oidc.ErrToken,
+		},
+		{
+			name: "expired",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() }
+			},
+			want: oidc.ErrToken,
+		},
+		{
+			name: "expired within the leeway",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["exp"] = time.Now().Add(-30 * time.Second).Unix() }
+			},
+		},
+		{
+			name: "issued in the future",
+			stub: func(s *oidctest.Server) {
+				s.Tamper = func(claims map[string]any) { claims["iat"] = time.Now().Add(time.Hour).Unix() }
+			},
+			want: oidc.ErrToken,
+		},
+		{
+			name: "missing subject",
+			stub: func(s *oidctest.Server) { delete(s.Claims, "sub") },
+			want: oidc.ErrToken,
+		},
+		{name: "unsupported algorithm", stub: func(s *oidctest.Server) { s.Algorithm = "HS256" }, want: oidc.ErrToken},
+		{name: "forged RS256", stub: func(s *oidctest.Server) { s.Forge = true }, want: oidc.ErrSignature},
+		{
+			name: "forged ES256",
+			stub: func(s *oidctest.Server) { s.Algorithm, s.Forge = "ES256", true },
+			want: oidc.ErrSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := oidctest.NewServer(t)
+			provider := s.Provider("stub", "https://app.example.com/auth/stub/callback")
+			flow, err := oidc.NewFlow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := provider.AuthCodeURL(ctx, flow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			callback := s.Authorize(t, authURL)
+			if state := callback.Query().Get("state"); state != flow.State {
+				t.Fatalf("callback state = %q; want %q", state, flow.State)
+			}
+
+			if tt.stub != nil {
+				tt.stub(s)
+			}
+			if tt.flow != nil {
+				tt.flow(&flow)
+			}
+			claims, err := provider.Exchange(ctx, callback.Query().Get("code"), flow)
+			if tt.want != nil {
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("Exchange error = %v; want %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "stub-user" || claims.Nonce != flow.Nonce || claims.Issuer != s.URL {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeOnce(t *testing.T) {
+	ctx := context.Background()
+	s := oidctest.NewServer(t)
+	provider := s.Provider("stub", "https://app.example.com/auth/stub/callback")
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := s.Authorize(t, authURL).Query().Get("code")
+	if _, err := provider.Exchange(ctx, code, flow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(ctx, code, flow); !errors.Is(err, oidc.ErrExchange) {
+		t.Fatalf("second Exchange error = %v; want %v", err, oidc.ErrExchange)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	s := oidctest.NewServer(t)
+	provider := s.Provider("stub", "https://app.example.com/auth/stub/callback")
+	// the discovery document is found at the same URL but names another issuer
+	provider.Issuer = s.URL + "/"
+	if _, err := provider.Discover(context.Background()); !errors.Is(err, oidc.ErrDiscovery) {
+		t.Fatalf("Discover error = %v; want %v", err, oidc.ErrDiscovery)
+	}
+}
+
+func TestClaims(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       map[string]any
+		wantVerified bool
+		wantAudience []string
+	}{
+		{"verified", map[string]any{"email_verified": true}, true, []string{"stub-client"}},
+		{"verified as a string", map[string]any{"email_verified": "true"}, true, []string{"stub-client"}},
+		{"unverified as a string", map[string]any{"email_verified": "false"}, false, []string{"stub-client"}},
+		{
+			"audience list",
+			map[string]any{"aud": []string{"another-client", "stub-client"}},
+			false,
+			[]string{"another-client", "stub-client"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := oidctest.NewServer(t)
+			s.Claims["email"] = "jane@example.com"
+			for key, value := range tt.claims {
+				s.Claims[key] = value
+			}
+			provider := s.Provider("stub", "https://app.example.com/auth/stub/callback")
+			flow, err := oidc.NewFlow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			authURL, err := provider.AuthCodeURL(ctx, flow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := provider.Exchange(ctx, s.Authorize(t, authURL).Query().Get("code"), flow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.EmailVerified != tt.wantVerified || !slices.Equal(claims.Audience, tt.wantAudience) {
+				t.Fatalf("email_verified = %v, aud = %v; want %v, %v",
+					claims.EmailVerified, claims.Audience, tt.wantVerified, tt.wantAudience)
+			}
+		})
+	}
+}
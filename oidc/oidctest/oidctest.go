@@ -0,0 +1,267 @@
+// Package oidctest runs a stub OpenID Connect provider for tests. It serves
+// discovery, its signing keys, an authorization endpoint that signs the user in
+// straight away and a token endpoint that checks the PKCE verifier.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joashgobin/boiler/oidc"
+)
+
+var encoding = base64.RawURLEncoding
+
+// Server is a stub provider. Change its fields before the token endpoint is
+// called to make it issue bad tokens.
+type Server struct {
+	*httptest.Server
+	ClientID string
+	// Claims are added to every ID token, e.g. sub, email and email_verified
+	Claims map[string]any
+	// Tamper changes the claims of an ID token before it's signed, e.g. to expire it
+	Tamper func(claims map[string]any)
+	// Algorithm signs the ID tokens, RS256 by default or ES256. Any other value
+	// is written to the token header with an RS256 signature.
+	Algorithm string
+	// Forge signs the ID tokens with a key that isn't published
+	Forge bool
+
+	mu     sync.Mutex
+	grants map[string]grant
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// the unpublished keys Forge signs with
+	forgedRSA *rsa.PrivateKey
+	forgedEC  *ecdsa.PrivateKey
+}
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+// NewServer starts a stub provider that is closed when the test ends
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	s := &Server{
+		ClientID: "stub-client",
+		Claims:   map[string]any{"sub": "stub-user"},
+		grants:   make(map[string]grant),
+	}
+	var err error
+	if s.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		tb.Fatal(err)
+	}
+	if s.forgedRSA, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		tb.Fatal(err)
+	}
+	if s.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		tb.Fatal(err)
+	}
+	if s.forgedEC, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		tb.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+	s.Server = httptest.NewServer(mux)
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Provider returns a provider registered with the stub
+func (s *Server) Provider(name, redirectURL string) *oidc.Provider {
+	return &oidc.Provider{
+		Name:        name,
+		Label:       "Stub",
+		Issuer:      s.URL,
+		ClientID:    s.ClientID,
+		RedirectURL: redirectURL,
+		HTTPClient:  s.Client(),
+	}
+}
+
+// Authorize signs in at the authorization URL and returns the callback URL
+// the provider redirects back to, with the code and state in its query
+func (s *Server) Authorize(tb testing.TB, authURL string) *url.URL {
+	tb.Helper()
+	client := *s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		tb.Fatalf("authorization returned %s", resp.Status)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return callback
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.Discovery{
+		Issuer:                s.URL,
+		AuthorizationEndpoint: s.URL + "/authorize",
+		TokenEndpoint:         s.URL + "/token",
+		JWKSURI:               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	x, y := make([]byte, 32), make([]byte, 32)
+	s.ecKey.X.FillBytes(x)
+	s.ecKey.Y.FillBytes(y)
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{
+		{
+			"kid": "rsa", "kty": "RSA", "use": "sig",
+			"n": encoding.EncodeToString(s.rsaKey.N.Bytes()),
+			"e": encoding.EncodeToString(big.NewInt(int64(s.rsaKey.E)).Bytes()),
+		},
+		{
+			"kid": "ec", "kty": "EC", "use": "sig", "crv": "P-256",
+			"x": encoding.EncodeToString(x), "y": encoding.EncodeToString(y),
+		},
+	}})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code := rand.Text()
+	s.mu.Lock()
+	s.grants[code] = grant{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	s.mu.Unlock()
+	callback := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, callback, http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	s.mu.Lock()
+	g, ok := s.grants[r.PostForm.Get("code")]
+	delete(s.grants, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	hash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("redirect_uri") != g.redirectURI ||
+		encoding.EncodeToString(hash[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid_grant", "error_description": "unknown code or wrong code verifier",
+		})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   s.URL,
+		"aud":   s.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	for key, value := range s.Claims {
+		claims[key] = value
+	}
+	if s.Tamper != nil {
+		s.Tamper(claims)
+	}
+	idToken, err := s.sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// sign writes the claims as a JWT signed as the server's fields ask
+func (s *Server) sign(claims map[string]any) (string, error) {
+	alg, kid := s.Algorithm, "rsa"
+	if alg == "" {
+		alg = "RS256"
+	}
+	if alg == "ES256" {
+		kid = "ec"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	if alg == "ES256" {
+		key := s.ecKey
+		if s.Forge {
+			key = s.forgedEC
+		}
+		r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS signatures are r and s concatenated rather than ASN.1
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		sig.FillBytes(signature[32:])
+	} else {
+		key := s.rsaKey
+		if s.Forge {
+			key = s.forgedRSA
+		}
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	}
+	return signed + "." + encoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
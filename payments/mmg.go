@@ -28,6 +28,7 @@ import (
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/session"
 
+	"github.com/joashgobin/boiler/dialect"
 	"github.com/joashgobin/boiler/email"
 	"github.com/joashgobin/boiler/helpers"
 )
@@ -90,6 +91,8 @@ type MMGTransaction struct {
 	Category  string
 	Status    string
 	Metadata  string
+	User      string
+	Product   string
 }
 
 // Config holds application configuration
@@ -190,10 +193,10 @@ func (m *MMGModel) LoadMMGTransactionDetails(merchantNumber int, transactionRefe
 				}
 				query := `
 					UPDATE transactions
-					SET metadata = ?, user = ?, internalid = ?, productcode = ?
+					SET metadata = ?, ` + m.dialect().Quote("user") + ` = ?, internalid = ?, productcode = ?
 					WHERE reference = ?
 					`
-				result, err := m.DB.Exec(query, metadata, user, internalId, productCode, transactionReference)
+				result, err := m.DB.Exec(m.dialect().Rebind(query), metadata, user, internalId, productCode, transactionReference)
 				if err != nil {
 					log.Errorf("failed to update transaction ref %s: %v", transactionReference, err)
 					return
@@ -245,7 +248,7 @@ func (m *MMGModel) getTransactionData(data string, merchantNumber int, resourceT
 	}
 
 	// only metadata is not set
-	stmt, err := m.DB.Prepare(`
+	stmt, err := m.DB.Prepare(m.dialect().Rebind(`
 	INSERT INTO transactions (
 		timestamp,
 		reference,
@@ -256,7 +259,7 @@ func (m *MMGModel) getTransactionData(data string, merchantNumber int, resourceT
 		category,
 		status
 	) VALUES (?,?,?,?,?,?,?,?)
-	`)
+	`))
 	if err != nil {
 		log.Errorf("prepare statement error: %v\n", err)
 		return
@@ -316,8 +319,10 @@ func getEnvironmentData(merchantNumber int) (map[string]string, error) {
 	return pairs, nil
 }
 
-func getResourceToken(db *sql.DB, merchantNumber int) string {
-	return helpers.GetShelf(db, "resource-token-"+strconv.Itoa(merchantNumber))
+// resourceToken reads the merchant's cached resource token from the shelf
+func (m *MMGModel) resourceToken(merchantNumber int) string {
+	shelf := helpers.ShelfModel{DB: m.DB, Dialect: m.Dialect}
+	return shelf.Get("resource-token-" + strconv.Itoa(merchantNumber))
 }
 
 func IsMMGSubscribed(db *sql.DB, thresholdAmount float64, userEmail string) bool {
@@ -423,13 +428,12 @@ func (m *MMGModel) loadMMGTransactionHistory(merchantNumber int) {
 			url := urlBuilder.String()
 
 			// retrieve resource token from database
-			resourceToken := getResourceToken(m.DB, merchantNumber)
+			resourceToken := m.resourceToken(merchantNumber)
 
 			// in case resource token is empty
 			if resourceToken == "" {
 				log.Error("resource token returned empty")
-				email.SendEmail(helpers.Getenv("ADMIN_EMAIL"), "MMG Resource Token Returned Empty",
-					fmt.Sprintf("Merchant: %d", merchantNumber), "", m.WaitGroup)
+				m.notifyAdmin("MMG Resource Token Returned Empty", fmt.Sprintf("Merchant: %d", merchantNumber))
 				m.LoadNewResourceToken(merchantNumber)
 
 				// send request
@@ -448,8 +452,8 @@ func (m *MMGModel) loadMMGTransactionHistory(merchantNumber int) {
 
 				// log error and notify admin via email
 				log.Error("failed to use valid resource token")
-				email.SendEmail(helpers.Getenv("ADMIN_EMAIL"), "MMG Client Authorization Error",
-					fmt.Sprintf("Response: %v<br>Head: %v<br>Merchant: %d", string(body), res.Header, merchantNumber), "", m.WaitGroup)
+				m.notifyAdmin("MMG Client Authorization Error",
+					fmt.Sprintf("Response: %v<br>Head: %v<br>Merchant: %d", string(body), res.Header, merchantNumber))
 
 				// request new resource token
 				m.LoadNewResourceToken(merchantNumber)
@@ -464,8 +468,8 @@ func (m *MMGModel) loadMMGTransactionHistory(merchantNumber int) {
 			if strings.Contains(string(body), "Authentication failed") {
 
 				// notify admin via email
-				email.SendEmail(helpers.Getenv("ADMIN_EMAIL"), "MMG Authentication Error",
-					fmt.Sprintf("Response: %v<br>Head: %v<br>Merchant: %d", string(body), res.Header, merchantNumber), "", m.WaitGroup)
+				m.notifyAdmin("MMG Authentication Error",
+					fmt.Sprintf("Response: %v<br>Head: %v<br>Merchant: %d", string(body), res.Header, merchantNumber))
 				return
 			}
 
@@ -512,11 +516,12 @@ func (m *MMGModel) LoadNewResourceToken(merchantNumber int) {
 
 	if err != nil {
 		log.Error("failed to extract resource token")
-		email.SendEmail(helpers.Getenv("ADMIN_EMAIL"), "MMG Failed Token Extraction", fmt.Sprintf("Response: %s<br>Merchant: %d", string(body), merchantNumber), "", m.WaitGroup)
+		m.notifyAdmin("MMG Failed Token Extraction", fmt.Sprintf("Response: %s<br>Merchant: %d", string(body), merchantNumber))
 		return
 	}
 	log.Infof("new resource token: %s", token)
-	helpers.SetShelf(m.DB, "resource-token-"+strconv.Itoa(merchantNumber), token)
+	shelf := helpers.ShelfModel{DB: m.DB, Dialect: m.Dialect}
+	shelf.Set("resource-token-"+strconv.Itoa(merchantNumber), token)
 }
 
 func (m *MMGModel) GetMMGBalance(merchantNumber int) {
@@ -544,7 +549,7 @@ func (m *MMGModel) GetMMGBalance(merchantNumber int) {
 			if err != nil {
 				return
 			}
-			req.Header.Add("x-wss-token", "Bearer "+getResourceToken(nil, merchantNumber))
+			req.Header.Add("x-wss-token", "Bearer "+m.resourceToken(merchantNumber))
 			req.Header.Add("x-wss-mid", pairs["merchant_mid"])
 			req.Header.Add("x-wss-mkey", pairs["merchant_mkey"])
 			req.Header.Add("x-wss-msecret", pairs["merchant_msecret"])
@@ -776,11 +781,36 @@ type MMGInterface interface {
 	GetUserProducts(userEmail string) []string
 	GetProduct(productCode string) MMGProduct
 	GetMerchant(merchantNumber int) MMGMerchant
+	ListMerchants() ([]MMGMerchant, error)
+	ListProducts() ([]MMGProduct, error)
+	ListTransactions(limit int) ([]MMGTransaction, error)
 }
 
 type MMGModel struct {
 	DB        *sql.DB
+	Dialect   dialect.Dialect
 	WaitGroup *sync.WaitGroup
+	// Audit records every checkout when set
+	Audit helpers.AuditFunc
+	// Mail queues the admin notifications when set, otherwise they are sent directly
+	Mail email.MailInterface
+}
+
+// notifyAdmin tells ADMIN_EMAIL about a problem with the MMG API
+func (m *MMGModel) notifyAdmin(subject, body string) {
+	if m.Mail != nil {
+		m.Mail.NotifyAdmin(subject, "%s", body)
+		return
+	}
+	email.SendEmail(helpers.Getenv("ADMIN_EMAIL"), subject, body, "", m.WaitGroup)
+}
+
+// audit action for a started checkout
+const AuditCheckout = "mmg.checkout"
+
+// dialect returns the model's SQL dialect, MySQL by default
+func (m *MMGModel) dialect() dialect.Dialect {
+	return dialect.Or(m.Dialect)
 }
 
 var _ MMGInterface = (*MMGModel)(nil)
@@ -804,7 +834,7 @@ func (m *MMGModel) GetMerchant(merchantNumber int) MMGMerchant {
 	var merchant MMGMerchant
 	merchant.Number = merchantNumber
 
-	row := m.DB.QueryRow(query, merchantNumber)
+	row := m.DB.QueryRow(m.dialect().Rebind(query), merchantNumber)
 	err := row.Scan(&merchant.Name)
 	if err != nil {
 		return MMGMerchant{Name: "John Doe", Number: 1234567}
@@ -812,6 +842,71 @@ func (m *MMGModel) GetMerchant(merchantNumber int) MMGMerchant {
 	return merchant
 }
 
+func (m *MMGModel) ListMerchants() ([]MMGMerchant, error) {
+	rows, err := m.DB.Query(`SELECT name, number FROM merchants ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var merchants []MMGMerchant
+	for rows.Next() {
+		var merchant MMGMerchant
+		if err := rows.Scan(&merchant.Name, &merchant.Number); err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, merchant)
+	}
+	return merchants, rows.Err()
+}
+
+func (m *MMGModel) ListProducts() ([]MMGProduct, error) {
+	rows, err := m.DB.Query(`SELECT code, description FROM products ORDER BY code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []MMGProduct
+	for rows.Next() {
+		var product MMGProduct
+		if err := rows.Scan(&product.Code, &product.Description); err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// ListTransactions returns the latest transactions, newest first
+func (m *MMGModel) ListTransactions(limit int) ([]MMGTransaction, error) {
+	query := `SELECT timestamp, reference, source, destination, amount, currency, category, status,
+	metadata, ` + m.dialect().Quote("user") + `, productcode
+	FROM transactions ORDER BY timestamp DESC LIMIT ?`
+	rows, err := m.DB.Query(m.dialect().Rebind(query), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []MMGTransaction
+	for rows.Next() {
+		var transaction MMGTransaction
+		var metadata, user, product sql.NullString
+		err := rows.Scan(&transaction.Timestamp, &transaction.Reference, &transaction.From, &transaction.To,
+			&transaction.Amount, &transaction.Currency, &transaction.Category, &transaction.Status,
+			&metadata, &user, &product)
+		if err != nil {
+			return nil, err
+		}
+		transaction.Metadata = metadata.String
+		transaction.User = user.String
+		transaction.Product = product.String
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
 func (m *MMGModel) AddProducts(productMap map[string]string) {
 	for productCode, productDescription := range productMap {
 		m.AddProduct(productCode, productDescription)
@@ -826,7 +921,7 @@ func (m *MMGModel) GetProduct(productCode string) MMGProduct {
 	var product MMGProduct
 	product.Code = productCode
 
-	row := m.DB.QueryRow(query, productCode)
+	row := m.DB.QueryRow(m.dialect().Rebind(query), productCode)
 	var description string
 	err := row.Scan(&description)
 	if err != nil {
@@ -838,8 +933,8 @@ func (m *MMGModel) GetProduct(productCode string) MMGProduct {
 func (m *MMGModel) GetUserProducts(userEmail string) []string {
 	query := `SELECT
 	productcode
-	FROM transactions WHERE user = ?`
-	rows, err := m.DB.Query(query, userEmail)
+	FROM transactions WHERE ` + m.dialect().Quote("user") + ` = ?`
+	rows, err := m.DB.Query(m.dialect().Rebind(query), userEmail)
 	if err != nil {
 		log.Errorf("product query error: %v", err)
 		return []string{}
@@ -876,7 +971,7 @@ func (m *MMGModel) AddProduct(productCode, itemDescription string) error {
 	VALUES (?,?)
 	`
 
-	result, err := m.DB.Exec(query, productCode, itemDescription)
+	result, err := m.DB.Exec(m.dialect().Rebind(query), productCode, itemDescription)
 	if err != nil {
 		return fmt.Errorf("add product exec error: %v", err)
 	}
@@ -900,7 +995,7 @@ func (m *MMGModel) RegisterMerchant(merchantNumber int, merchantName string) err
 	VALUES (?,?)
 	`
 
-	result, err := m.DB.Exec(query, merchantName, merchantNumber)
+	result, err := m.DB.Exec(m.dialect().Rebind(query), merchantName, merchantNumber)
 	if err != nil {
 		return fmt.Errorf("register merchant exec error: %v", err)
 	}
@@ -917,7 +1012,12 @@ func (m *MMGModel) RegisterMerchant(merchantNumber int, merchantName string) err
 }
 
 func (m *MMGModel) Checkout(userEmail string, merchantNumber int, productCode string, cost float64) string {
-	_, url := initiateCheckout(userEmail, merchantNumber, m.GetMerchant(merchantNumber).Name, productCode, cost)
+	internalID, url := initiateCheckout(userEmail, merchantNumber, m.GetMerchant(merchantNumber).Name, productCode, cost)
+	m.Audit.Emit(userEmail, AuditCheckout, productCode, map[string]any{
+		"merchant":    merchantNumber,
+		"cost":        cost,
+		"internal_id": internalID,
+	})
 	// insertPendingPurchase(m.DB, internalTransactionID, itemDescription, productCode, userEmail)
 	return url
 }
@@ -974,42 +1074,7 @@ func initiateCheckout(userEmail string, merchantNumber int, merchantName, produc
 	return internalTransactionID, generateURL(token, config.MerchantMsisdn, config.ClientID)
 }
 
+// NewMMG returns an MMG model; the transactions, merchants and products tables are created by the core migrations
 func NewMMG(db *sql.DB, wg *sync.WaitGroup, appName string) *MMGModel {
-
-	// create database
-	helpers.RunMigration(strings.ReplaceAll(`
-USE <appName>;
-
-CREATE TABLE IF NOT EXISTS transactions (
-    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    timestamp DATETIME NOT NULL,
-	reference VARCHAR(20) NOT NULL UNIQUE,
-	source      VARCHAR(20) NOT NULL,
-	destination        VARCHAR(20) NOT NULL,
-	amount    DECIMAL(10,2) NOT NULL,
-	currency  VARCHAR(5) NOT NULL,
-	category  VARCHAR(30) NOT NULL,
-	status    VARCHAR(20) NOT NULL,
-    metadata VARCHAR(100),
-    user VARCHAR(100),
-	productcode VARCHAR(200),
-	internalid VARCHAR(40),
-    expiration_date DATETIME
-);
-
-CREATE TABLE IF NOT EXISTS merchants (
-    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    name VARCHAR(100) NOT NULL,
-	number INTEGER NOT NULL UNIQUE
-);
-
-CREATE TABLE IF NOT EXISTS products (
-    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
-    code VARCHAR(100) NOT NULL UNIQUE,
-	description VARCHAR(300) NOT NULL
-);
-
-	`, "<appName>", appName), db)
-
 	return &MMGModel{DB: db, WaitGroup: wg}
 }
@@ -0,0 +1,387 @@
+// Package webauthn verifies passkey registrations and assertions.
+//
+// It covers what passwordless sign-in needs without attestation: challenges,
+// client data, authenticator data and ES256/RS256 credential public keys.
+// Binary values are exchanged with the browser as unpadded base64url strings.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrChallenge    = errors.New("webauthn: challenge mismatch")
+	ErrOrigin       = errors.New("webauthn: origin mismatch")
+	ErrRelyingParty = errors.New("webauthn: relying party mismatch")
+	ErrUserPresence = errors.New("webauthn: user not present")
+	ErrSignature    = errors.New("webauthn: invalid signature")
+	ErrSignCount    = errors.New("webauthn: signature counter went backwards, the authenticator may be cloned")
+	ErrUnsupported  = errors.New("webauthn: unsupported credential")
+	ErrMalformed    = errors.New("webauthn: malformed response")
+)
+
+// COSE algorithms offered to the browser, in order of preference
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var encoding = base64.RawURLEncoding
+
+// RelyingParty identifies the site credentials are created for
+type RelyingParty struct {
+	// ID is the domain, e.g. example.com or localhost
+	ID   string
+	Name string
+	// Origin is the scheme, host and port the browser reports, e.g. https://example.com
+	Origin string
+}
+
+// Credential is a public key registered by an authenticator
+type Credential struct {
+	ID        string
+	PublicKey []byte
+	SignCount uint32
+}
+
+// RegistrationResponse is the credential from navigator.credentials.create
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the credential from navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Assertion is the verified result of a sign-in
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a random challenge to keep in the session until the ceremony finishes
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// CreationOptions returns the publicKey options for navigator.credentials.create,
+// asking for a discoverable credential so users can sign in without an email
+func (rp RelyingParty) CreationOptions(challenge string, userHandle []byte, userName, displayName string, exclude []string) map[string]any {
+	excluded := make([]map[string]any, len(exclude))
+	for i, id := range exclude {
+		excluded[i] = map[string]any{"type": "public-key", "id": id}
+	}
+	return map[string]any{
+		"challenge": challenge,
+		"rp":        map[string]any{"id": rp.ID, "name": rp.Name},
+		"user": map[string]any{
+			"id":          encoding.EncodeToString(userHandle),
+			"name":        userName,
+			"displayName": displayName,
+		},
+		"pubKeyCredParams": []map[string]any{
+			{"type": "public-key", "alg": AlgES256},
+			{"type": "public-key", "alg": AlgRS256},
+		},
+		"authenticatorSelection": map[string]any{
+			"residentKey":      "preferred",
+			"userVerification": "preferred",
+		},
+		"excludeCredentials": excluded,
+		"attestation":        "none",
+		"timeout":            60000,
+	}
+}
+
+// RequestOptions returns the publicKey options for navigator.credentials.get
+func (rp RelyingParty) RequestOptions(challenge string) map[string]any {
+	return map[string]any{
+		"challenge":        challenge,
+		"rpId":             rp.ID,
+		"userVerification": "preferred",
+		"timeout":          60000,
+	}
+}
+
+// ParseRegistration decodes the JSON credential posted after registration
+func ParseRegistration(body []byte) (*RegistrationResponse, error) {
+	var response RegistrationResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: credential type %q", ErrMalformed, response.Type)
+	}
+	return &response, nil
+}
+
+// ParseAssertion decodes the JSON credential posted to sign in, so the stored
+// credential can be looked up by ID before it is verified
+func ParseAssertion(body []byte) (*AssertionResponse, error) {
+	var response AssertionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if response.Type != "public-key" {
+		return nil, fmt.Errorf("%w: credential type %q", ErrMalformed, response.Type)
+	}
+	return &response, nil
+}
+
+// VerifyRegistration checks a new credential against the challenge issued for it
+func (rp RelyingParty) VerifyRegistration(challenge string, response *RegistrationResponse) (Credential, error) {
+	if _, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	attestation, err := encoding.DecodeString(response.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: attestation object: %v", ErrMalformed, err)
+	}
+	decoded, _, err := decodeCBOR(attestation)
+	if err != nil {
+		return Credential{}, err
+	}
+	object, ok := decoded.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object", ErrMalformed)
+	}
+	// attestation statements are not checked since "none" is requested
+	rawAuthData, ok := object["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authenticator data", ErrMalformed)
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return Credential{}, fmt.Errorf("%w: missing attested credential", ErrMalformed)
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return Credential{}, err
+	}
+	id := encoding.EncodeToString(authData.credentialID)
+	if id != response.ID {
+		return Credential{}, fmt.Errorf("%w: credential id mismatch", ErrMalformed)
+	}
+	return Credential{ID: id, PublicKey: authData.publicKey, SignCount: authData.signCount}, nil
+}
+
+// VerifyAssertion checks a sign-in against the challenge and the stored credential
+func (rp RelyingParty) VerifyAssertion(challenge string, response *AssertionResponse, credential Credential) (Assertion, error) {
+	clientDataJSON, err := rp.verifyClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return Assertion{}, err
+	}
+	rawAuthData, err := encoding.DecodeString(response.Response.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: authenticator data: %v", ErrMalformed, err)
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Assertion{}, err
+	}
+	signature, err := encoding.DecodeString(response.Response.Signature)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := make([]byte, 0, len(rawAuthData)+len(clientDataHash))
+	signed = append(append(signed, rawAuthData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+	if !key.verify(digest[:], signature) {
+		return Assertion{}, ErrSignature
+	}
+
+	// authenticators that don't count always report zero
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return Assertion{}, ErrSignCount
+	}
+	return Assertion{SignCount: authData.signCount, UserVerified: authData.flags&flagUserVerified != 0}, nil
+}
+
+func (rp RelyingParty) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, err := encoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: client data: %v", ErrMalformed, err)
+	}
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("%w: client data: %v", ErrMalformed, err)
+	}
+	if data.Type != ceremony {
+		return nil, fmt.Errorf("%w: client data type %q", ErrMalformed, data.Type)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(challenge)) != 1 {
+		return nil, ErrChallenge
+	}
+	if data.Origin != rp.Origin {
+		return nil, ErrOrigin
+	}
+	return raw, nil
+}
+
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte) (authenticatorData, error) {
+	var data authenticatorData
+	if len(raw) < 37 {
+		return data, fmt.Errorf("%w: authenticator data too short", ErrMalformed)
+	}
+	data.rpIDHash = raw[:32]
+	data.flags = raw[32]
+	data.signCount = binary.BigEndian.Uint32(raw[33:37])
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return data, ErrRelyingParty
+	}
+	if data.flags&flagUserPresent == 0 {
+		return data, ErrUserPresence
+	}
+
+	if data.flags&flagAttestedData != 0 {
+		// aaguid, credential id length and id, then the COSE key
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return data, fmt.Errorf("%w: attested credential data too short", ErrMalformed)
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return data, fmt.Errorf("%w: credential id too short", ErrMalformed)
+		}
+		data.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return data, err
+		}
+		data.publicKey = rest[:len(rest)-len(after)]
+	}
+	return data, nil
+}
+
+type publicKey struct {
+	ecdsa *ecdsa.PublicKey
+	rsa   *rsa.PublicKey
+}
+
+func (key publicKey) verify(digest, signature []byte) bool {
+	if key.ecdsa != nil {
+		return ecdsa.VerifyASN1(key.ecdsa, digest, signature)
+	}
+	return rsa.VerifyPKCS1v15(key.rsa, crypto.SHA256, digest, signature) == nil
+}
+
+// COSE key parameters
+const (
+	coseKty  = 1
+	coseAlg  = 3
+	coseCrv  = -1
+	coseX    = -2
+	coseY    = -3
+	coseRSAN = -1
+	coseRSAE = -2
+	coseEC2  = 2
+	coseRSA  = 3
+	coseP256 = 1
+)
+
+// parsePublicKey reads an ES256 or RS256 COSE key
+func parsePublicKey(raw []byte) (publicKey, error) {
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return publicKey{}, err
+	}
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return publicKey{}, fmt.Errorf("%w: public key", ErrMalformed)
+	}
+	kty, _ := key[int64(coseKty)].(int64)
+	alg, _ := key[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseEC2 && alg == AlgES256:
+		crv, _ := key[int64(coseCrv)].(int64)
+		x, _ := key[int64(coseX)].([]byte)
+		y, _ := key[int64(coseY)].([]byte)
+		if crv != coseP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, fmt.Errorf("%w: ec2 key", ErrUnsupported)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return publicKey{ecdsa: pub}, nil
+	case kty == coseRSA && alg == AlgRS256:
+		n, _ := key[int64(coseRSAN)].([]byte)
+		e, _ := key[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, fmt.Errorf("%w: rsa key", ErrUnsupported)
+		}
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+		return publicKey{rsa: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+	return publicKey{}, fmt.Errorf("%w: key type %d algorithm %d", ErrUnsupported, kty, alg)
+}
@@ -0,0 +1,328 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testRP = RelyingParty{ID: "example.com", Name: "Example", Origin: "https://example.com"}
+
+// cborPairs is a CBOR map that keeps its keys in order
+type cborPairs [][2]any
+
+// encodeCBOR writes the subset of CBOR decodeCBOR reads
+func encodeCBOR(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		default:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case cborPairs:
+		out := head(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair[0])...)
+			out = append(out, encodeCBOR(pair[1])...)
+		}
+		return out
+	}
+	panic("unsupported cbor value")
+}
+
+// authenticator is a software passkey that signs what the fields say,
+// so tests can make it misbehave
+type authenticator struct {
+	rpID     string
+	origin   string
+	ceremony string
+	flags    byte
+	counter  uint32
+	id       []byte
+	ecKey    *ecdsa.PrivateKey
+	rsaKey   *rsa.PrivateKey
+}
+
+func newAuthenticator(t *testing.T, alg int) *authenticator {
+	t.Helper()
+	a := &authenticator{rpID: testRP.ID, origin: testRP.Origin, flags: flagUserPresent | flagUserVerified, id: []byte("credential-1")}
+	var err error
+	if alg == AlgRS256 {
+		a.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		a.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *authenticator) coseKey() []byte {
+	if a.rsaKey != nil {
+		e := binary.BigEndian.AppendUint32(nil, uint32(a.rsaKey.E))
+		return encodeCBOR(cborPairs{
+			{coseKty, coseRSA}, {coseAlg, AlgRS256},
+			{coseRSAN, a.rsaKey.N.Bytes()}, {coseRSAE, bytes.TrimLeft(e, "\x00")},
+		})
+	}
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.ecKey.X.FillBytes(x)
+	a.ecKey.Y.FillBytes(y)
+	return encodeCBOR(cborPairs{{coseKty, coseEC2}, {coseAlg, AlgES256}, {coseCrv, coseP256}, {coseX, x}, {coseY, y}})
+}
+
+func (a *authenticator) clientData(ceremony, challenge string) []byte {
+	if a.ceremony != "" {
+		ceremony = a.ceremony
+	}
+	data, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	return data
+}
+
+func (a *authenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(append(data, a.id...), a.coseKey()...)
+	}
+	return data
+}
+
+func (a *authenticator) register(challenge string) *RegistrationResponse {
+	response := &RegistrationResponse{ID: encoding.EncodeToString(a.id), Type: "public-key"}
+	response.Response.ClientDataJSON = encoding.EncodeToString(a.clientData("webauthn.create", challenge))
+	response.Response.AttestationObject = encoding.EncodeToString(encodeCBOR(cborPairs{
+		{"fmt", "none"}, {"attStmt", cborPairs{}}, {"authData", a.authData(true)},
+	}))
+	return response
+}
+
+func (a *authenticator) assert(t *testing.T, challenge string) *AssertionResponse {
+	t.Helper()
+	clientDataJSON := a.clientData("webauthn.get", challenge)
+	authData := a.authData(false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	var signature []byte
+	var err error
+	if a.rsaKey != nil {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, a.rsaKey, crypto.SHA256, digest[:])
+	} else {
+		signature, err = ecdsa.SignASN1(rand.Reader, a.ecKey, digest[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &AssertionResponse{ID: encoding.EncodeToString(a.id), Type: "public-key"}
+	response.Response.ClientDataJSON = encoding.EncodeToString(clientDataJSON)
+	response.Response.AuthenticatorData = encoding.EncodeToString(authData)
+	response.Response.Signature = encoding.EncodeToString(signature)
+	return response
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	tests := []struct {
+		name   string
+		alg    int
+		tamper func(a *authenticator, response *RegistrationResponse)
+		want   error
+	}{
+		{name: "ES256", alg: AlgES256},
+		{name: "RS256", alg: AlgRS256},
+		{name: "another origin", tamper: func(a *authenticator, _ *RegistrationResponse) { a.origin = "https://evil.example" }, want: ErrOrigin},
+		{name: "another relying party", tamper: func(a *authenticator, _ *RegistrationResponse) { a.rpID = "evil.example" }, want: ErrRelyingParty},
+		{name: "user not present", tamper: func(a *authenticator, _ *RegistrationResponse) { a.flags = 0 }, want: ErrUserPresence},
+		{name: "sign in ceremony", tamper: func(a *authenticator, _ *RegistrationResponse) { a.ceremony = "webauthn.get" }, want: ErrMalformed},
+		{name: "other credential id", tamper: func(_ *authenticator, response *RegistrationResponse) { response.ID = "other" }, want: ErrMalformed},
+		{name: "stale challenge", tamper: func(_ *authenticator, response *RegistrationResponse) {
+			stale, _ := NewChallenge()
+			response.Response.ClientDataJSON = encoding.EncodeToString(
+				[]byte(`{"type":"webauthn.create","challenge":"` + stale + `","origin":"https://example.com"}`))
+		}, want: ErrChallenge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuthenticator(t, tt.alg)
+			challenge, err := NewChallenge()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var response *RegistrationResponse
+			if tt.tamper != nil {
+				// the authenticator fields apply when the response is built, the response ones after
+				tt.tamper(a, &RegistrationResponse{})
+				response = a.register(challenge)
+				tt.tamper(a, response)
+			} else {
+				response = a.register(challenge)
+			}
+
+			// the response goes through JSON like the browser's
+			body, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseRegistration(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			credential, err := testRP.VerifyRegistration(challenge, parsed)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyRegistration error = %v; want %v", err, tt.want)
+			}
+			if err == nil && (credential.ID != response.ID || !bytes.Equal(credential.PublicKey, a.coseKey())) {
+				t.Fatalf("credential = %+v; want id %s and the authenticator's key", credential, response.ID)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  int
+		// stored is the counter on record, counter the one the authenticator reports
+		stored, counter uint32
+		forge           bool
+		wrongChallenge  bool
+		want            error
+	}{
+		{name: "ES256", alg: AlgES256, stored: 1, counter: 2},
+		{name: "RS256", alg: AlgRS256, stored: 1, counter: 2},
+		{name: "authenticator without a counter", counter: 0},
+		{name: "counter went backwards", stored: 5, counter: 5, want: ErrSignCount},
+		{name: "signed by another key", forge: true, want: ErrSignature},
+		{name: "stale challenge", wrongChallenge: true, want: ErrChallenge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuthenticator(t, tt.alg)
+			challenge, err := NewChallenge()
+			if err != nil {
+				t.Fatal(err)
+			}
+			credential, err := testRP.VerifyRegistration(challenge, a.register(challenge))
+			if err != nil {
+				t.Fatal(err)
+			}
+			credential.SignCount = tt.stored
+
+			a.counter = tt.counter
+			if tt.forge {
+				a.ecKey, a.rsaKey = newAuthenticator(t, tt.alg).ecKey, nil
+			}
+			signed := challenge
+			if tt.wrongChallenge {
+				signed, _ = NewChallenge()
+			}
+			body, err := json.Marshal(a.assert(t, signed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseAssertion(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertion, err := testRP.VerifyAssertion(challenge, parsed, credential)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyAssertion error = %v; want %v", err, tt.want)
+			}
+			if err == nil && (assertion.SignCount != tt.counter || !assertion.UserVerified) {
+				t.Fatalf("assertion = %+v; want counter %d, user verified", assertion, tt.counter)
+			}
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		body string
+		want error
+	}{
+		{`{"id":"a","type":"public-key"}`, nil},
+		{`{"id":"a","type":"password"}`, ErrMalformed},
+		{`not json`, ErrMalformed},
+	}
+	for _, tt := range tests {
+		if _, err := ParseRegistration([]byte(tt.body)); !errors.Is(err, tt.want) {
+			t.Errorf("ParseRegistration(%s) = %v; want %v", tt.body, err, tt.want)
+		}
+		if _, err := ParseAssertion([]byte(tt.body)); !errors.Is(err, tt.want) {
+			t.Errorf("ParseAssertion(%s) = %v; want %v", tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want any
+		err  bool
+	}{
+		{"small integer", []byte{0x17}, int64(23), false},
+		{"one byte integer", []byte{0x18, 0x64}, int64(100), false},
+		{"two byte integer", []byte{0x19, 0x03, 0xe8}, int64(1000), false},
+		{"negative integer", []byte{0x38, 0x63}, int64(-100), false},
+		{"byte string", []byte{0x42, 0x01, 0x02}, []byte{1, 2}, false},
+		{"text string", []byte{0x63, 'f', 'o', 'o'}, "foo", false},
+		{"array", []byte{0x82, 0x01, 0xf5}, []any{int64(1), true}, false},
+		{"map", []byte{0xa2, 0x01, 0x02, 0x20, 0xf6}, map[any]any{int64(1): int64(2), int64(-1): nil}, false},
+		{"tag", []byte{0xc1, 0x1a, 0x51, 0x4b, 0x67, 0xb0}, int64(1363896240), false},
+		{"truncated string", []byte{0x43, 0x01}, nil, true},
+		{"truncated argument", []byte{0x19, 0x03}, nil, true},
+		{"indefinite length", []byte{0x5f, 0x41, 0x01, 0xff}, nil, true},
+		{"byte string map key", []byte{0xa1, 0x41, 0x01, 0x01}, nil, true},
+		{"float", []byte{0xf9, 0x3c, 0x00}, nil, true},
+		{"integer overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{"nested too deeply", bytes.Repeat([]byte{0x81}, cborMaxDepth+2), nil, true},
+		{"empty", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := decodeCBOR(tt.data)
+			if tt.err {
+				if !errors.Is(err, errCBOR) {
+					t.Fatalf("decodeCBOR(% x) error = %v; want errCBOR", tt.data, err)
+				}
+				return
+			}
+			if err != nil || len(rest) != 0 || !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeCBOR(% x) = %#v, % x, %v; want %#v", tt.data, got, rest, err, tt.want)
+			}
+		})
+	}
+}